@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+import (
+	"github.com/vouquet/shop"
+)
+
+import (
+	"miniquet2/miniquet"
+)
+
+func (self *Miniket2) serveApi(c *miniquet.ApiConfig) error {
+	if c.Listen == "" {
+		return nil
+	}
+
+	token, err := c.LoadToken()
+	if err != nil {
+		return fmt.Errorf("cannot load api token: %s", err)
+	}
+	api, err := miniquet.NewApiServer(self, token)
+	if err != nil {
+		return err
+	}
+	if err := api.Serve(c.Listen); err != nil {
+		return fmt.Errorf("cannot listen api: %s", err)
+	}
+
+	self.api = api
+	self.log.WriteMsgLog("api listening on %s", c.Listen)
+	return nil
+}
+
+//Traders, Rates, Logs, History, Archive and Exec make Miniket2 a miniquet.ApiBackend.
+func (self *Miniket2) Traders() []*miniquet.Trader {
+	return self.traders()
+}
+
+//Rates returns the last rates and when they were fetched.
+func (self *Miniket2) Rates() (map[string]shop.Rate, time.Time) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	return self.rates, self.rates_t
+}
+
+func (self *Miniket2) setRates(rates map[string]shop.Rate) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	self.rates = rates
+	self.rates_t = time.Now()
+}
+
+func (self *Miniket2) Logs(n int) []*miniquet.LogRecord {
+	return self.logs.Records(n)
+}
+
+func (self *Miniket2) History(n int) ([]*miniquet.AuditRecord, error) {
+	return self.audit.Records(n)
+}
+
+//Archive returns the last n archived entries, the oldest first.
+func (self *Miniket2) Archive(n int) ([]*miniquet.ArchiveRecord, error) {
+	return self.st.Archived(n)
+}
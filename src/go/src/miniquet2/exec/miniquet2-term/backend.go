@@ -0,0 +1,86 @@
+package main
+
+import (
+	"time"
+)
+
+import (
+	"miniquet2/miniquet"
+)
+
+//Backend is what the terminal shows and operates. It is the Miniket2 of
+//this process, or a daemon attached through miniquet.ApiClient.
+type Backend interface {
+	Traders() ([]*miniquet.TraderInfo, error)
+	Rates() ([]*miniquet.RateInfo, error)
+	Logs(n int) ([]*miniquet.LogInfo, error)
+	History(n int) ([]*miniquet.AuditRecord, error)
+	Archive(n int) ([]*miniquet.ArchiveInfo, error)
+	Exec(command string) ([]string, error)
+	Stats() (*miniquet.StorageStats, error)
+}
+
+type localBackend struct {
+	m2 *Miniket2
+}
+
+func newLocalBackend(m2 *Miniket2) *localBackend {
+	return &localBackend{m2: m2}
+}
+
+func (self *localBackend) Traders() ([]*miniquet.TraderInfo, error) {
+	infos := []*miniquet.TraderInfo{}
+	for _, tr := range self.m2.traders() {
+		infos = append(infos, miniquet.NewTraderInfo(tr))
+	}
+	return infos, nil
+}
+
+func (self *localBackend) Rates() ([]*miniquet.RateInfo, error) {
+	rates, t := self.m2.Rates()
+	return miniquet.NewRateInfos(rates, t), nil
+}
+
+func (self *localBackend) Logs(n int) ([]*miniquet.LogInfo, error) {
+	infos := []*miniquet.LogInfo{}
+	for _, r := range self.m2.Logs(n) {
+		infos = append(infos, miniquet.NewLogInfo(r))
+	}
+	return infos, nil
+}
+
+func (self *localBackend) History(n int) ([]*miniquet.AuditRecord, error) {
+	return self.m2.History(n)
+}
+
+func (self *localBackend) Archive(n int) ([]*miniquet.ArchiveInfo, error) {
+	rs, err := self.m2.Archive(n)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := []*miniquet.ArchiveInfo{}
+	for _, r := range rs {
+		infos = append(infos, miniquet.NewArchiveInfo(r))
+	}
+	return infos, nil
+}
+
+func (self *localBackend) Exec(command string) ([]string, error) {
+	return self.m2.Exec(SourceTerm, "", command)
+}
+
+func (self *localBackend) Stats() (*miniquet.StorageStats, error) {
+	return self.m2.Stats()
+}
+
+//ratesTime returns when the rates were fetched.
+func ratesTime(rates []*miniquet.RateInfo) time.Time {
+	var t time.Time
+	for _, r := range rates {
+		if r.Time.After(t) {
+			t = r.Time
+		}
+	}
+	return t
+}
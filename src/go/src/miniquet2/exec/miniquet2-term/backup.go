@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"strings"
+	"path/filepath"
+)
+
+import (
+	"miniquet2/miniquet"
+)
+
+//backup takes a snapshot of the storage while trading. The path is
+//'<storage path>.backup-<time>' in Backup.Dir when it is not given.
+func (self *Miniket2) backup(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("USAGE: backup [<path>]")
+	}
+
+	var path string
+	if len(args) == 1 {
+		path = args[0]
+	} else {
+		path = miniquet.BackupPath(self.config().Backup.BackupDir(self.st_path),
+							self.st_path, miniquet.BACKUP_MANUAL, time.Now())
+	}
+	return self.backupTo(path)
+}
+
+func (self *Miniket2) backupTo(path string) error {
+	start := time.Now()
+	if err := self.st.Backup(path); err != nil {
+		return err
+	}
+	self.log.Info("backed up the storage", "path", path, "took", time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+//compact compacts the storage. The traders wait for it.
+func (self *Miniket2) compact(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("USAGE: compact")
+	}
+
+	before, err := self.st.Stats()
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	if err := self.st.Compact(); err != nil {
+		return err
+	}
+	after, err := self.st.Stats()
+	if err != nil {
+		return err
+	}
+	self.log.Info("compacted the storage", "before", miniquet.FormatBytes(before.Size),
+					"after", miniquet.FormatBytes(after.Size),
+					"took", time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+//Stats makes Miniket2 a miniquet.ApiBackend with Traders and the others.
+func (self *Miniket2) Stats() (*miniquet.StorageStats, error) {
+	return self.st.Stats()
+}
+
+//run_backup takes a backup every Backup.Interval, and removes the old
+//ones over Backup.Keep. Reload sends a new config to backup_ch.
+func (self *Miniket2) run_backup(wg *sync.WaitGroup) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		conf := self.config().Backup
+		for {
+			//a nil channel never fires while backups are not scheduled.
+			var t *time.Timer
+			var t_ch <-chan time.Time
+			if d := conf.Every(); d > 0 {
+				t = time.NewTimer(d)
+				t_ch = t.C
+			}
+
+			select {
+			case <- self.ctx.Done():
+			case conf = <- self.backup_ch:
+			case <- t_ch:
+				self.scheduledBackup(&conf)
+			}
+			if t != nil {
+				t.Stop()
+			}
+			if self.ctx.Err() != nil {
+				return
+			}
+		}
+	}()
+}
+
+func (self *Miniket2) scheduledBackup(conf *miniquet.BackupConfig) {
+	dir := conf.BackupDir(self.st_path)
+	path := miniquet.BackupPath(dir, self.st_path, miniquet.BACKUP_AUTO, time.Now())
+	if err := self.backupTo(path); err != nil {
+		self.log.WriteErrLog("scheduled backup failed: %s", err)
+		return
+	}
+
+	removed, err := miniquet.PruneBackups(dir, self.st_path, miniquet.BACKUP_AUTO, conf.KeepCount())
+	for _, path := range removed {
+		self.log.Info("removed an old backup", "path", path)
+	}
+	if err != nil {
+		self.log.WriteErrLog("cannot remove old backups: %s", err)
+	}
+}
+
+//statsLine is the stats in one line for the terminal.
+func statsLine(stats *miniquet.StorageStats) string {
+	ns := []string{}
+	for _, n := range stats.Namespaces {
+		if n.Keys == 0 {
+			continue
+		}
+		ns = append(ns, fmt.Sprintf("%s %d", strings.TrimSuffix(n.Name, "/"), n.Keys))
+	}
+	return fmt.Sprintf("%s: %d keys (%s), %s on disk", filepath.Base(stats.Name), stats.Keys(),
+						strings.Join(ns, ", "), miniquet.FormatBytes(stats.Size))
+}
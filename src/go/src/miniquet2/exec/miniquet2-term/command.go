@@ -0,0 +1,301 @@
+package main
+
+import (
+	"fmt"
+	"flag"
+	"time"
+	"strings"
+	"strconv"
+	"io/ioutil"
+)
+
+import (
+	"github.com/vouquet/go-gmo-coin/gomocoin"
+)
+
+import (
+	"miniquet2/miniquet"
+)
+
+const (
+	SourceTerm   string = "term"
+	SourceSignal string = "signal"
+)
+
+//Exec runs a command which changes the entries or the config, and records
+//it in the audit log with its source and user. An empty user is the OS
+//user of this process. It returns the ids of the changed entries.
+func (self *Miniket2) Exec(source string, user string, command string) ([]string, error) {
+	c_s := strings.SplitN(command, " ", 5)
+
+	var ids []string
+	var err error
+	switch c_s[0] {
+	case "add":
+		ids, err = self.add(c_s[1:])
+	case "stop":
+		ids, err = self.stop(c_s[1:])
+	case "kill9":
+		ids, err = self.kill9(c_s[1:])
+	case "edit":
+		ids, err = self.edit(c_s[1:])
+	case "revive":
+		ids, err = self.revive(c_s[1:])
+	case "undo":
+		ids, err = self.undo(c_s[1:])
+	case "reload":
+		err = self.Reload()
+	case "backup":
+		err = self.backup(c_s[1:])
+	case "compact":
+		err = self.compact(c_s[1:])
+	default:
+		err := fmt.Errorf("undefined operation: %s", command)
+		self.writeAudit(source, user, command, c_s, nil, err)
+		self.log.Warn(err.Error(), "source", source)
+		return nil, err
+	}
+
+	self.writeAudit(source, user, command, c_s, ids, err)
+	if err != nil {
+		err = fmt.Errorf("%s command error: %s", c_s[0], err)
+		self.log.Warn(err.Error(), "source", source)
+		return ids, err
+	}
+	return ids, nil
+}
+
+func (self *Miniket2) writeAudit(source string, user string, input string, c_s []string,
+												ids []string, err error) {
+	rec := miniquet.NewAuditRecord(input, c_s[0], c_s[1:])
+	rec.Source = source
+	if user != "" {
+		rec.User = user
+	}
+	rec.Done(ids, err)
+
+	if err := self.audit.Append(rec); err != nil {
+		self.log.WriteErrLog("cannot write the audit log: %s", err)
+	}
+}
+
+func (self *Miniket2) add(args []string) ([]string, error) {
+	if len(args) != 4 {
+		return nil, fmt.Errorf("args less than 4. USAGE: add <trader name> <symbol> <size> <buy rate>, %d, %s", len(args), args)
+	}
+
+	t_name := args[0]
+	symbol := args[1]
+	size, err := strconv.ParseFloat(args[2], 64)
+	if err != nil {
+		return nil, err
+	}
+	want_rate, err := strconv.ParseFloat(args[3], 64)
+	if err != nil {
+		return nil, err
+	}
+
+	tr, ok := self.trader(t_name)
+	if !ok {
+		return nil, fmt.Errorf("unkown trader name. :%s", t_name)
+	}
+
+	entry, err := tr.Add(symbol, size, want_rate)
+	if err != nil {
+		return nil, err
+	}
+
+	self.log.Info("added", "trader", t_name, "entry", entry.Id(),
+						"symbol", symbol, "size", size, "rate", want_rate)
+	return []string{entry.Id()}, nil
+}
+
+func (self *Miniket2) stop(args []string) ([]string, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("args less than 2. USAGE: stop <trader name> <id>")
+	}
+
+	t_name := args[0]
+	tr, ok := self.trader(t_name)
+	if !ok {
+		return nil, fmt.Errorf("unkown trader name. :%s", t_name)
+	}
+	id, err := entryId(tr, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tr.RequestStop(id); err != nil {
+		return nil, err
+	}
+
+	self.log.Info("stoped", "trader", t_name, "entry", id)
+	return []string{id}, nil
+}
+
+func (self *Miniket2) kill9(args []string) ([]string, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("args less than 2. USAGE: kill9 <trader name> <id>")
+	}
+
+	t_name := args[0]
+	tr, ok := self.trader(t_name)
+	if !ok {
+		return nil, fmt.Errorf("unkown trader name. :%s", t_name)
+	}
+	id, err := entryId(tr, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tr.RequestKill9(id); err != nil {
+		return nil, err
+	}
+
+	self.log.Info("killed", "trader", t_name, "entry", id, "revive_until",
+						time.Now().Add(self.killGrace()).Format(FmtTime))
+	return []string{id}, nil
+}
+
+//edit changes an entry while trading.
+//edit <trader name> <id> [-size <size>] [-position BUY|SELL] [-rate <last rate>]
+func (self *Miniket2) edit(args []string) ([]string, error) {
+	usage := fmt.Errorf("USAGE: edit <trader name> <id> [-size <size>] [-position BUY|SELL] [-rate <last rate>]")
+	if len(args) < 2 {
+		return nil, usage
+	}
+
+	fs := flag.NewFlagSet("edit", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	ed := &entryEdit{}
+	fs.StringVar(&ed.size, "size", "", "")
+	fs.StringVar(&ed.position, "position", "", "")
+	fs.StringVar(&ed.rate, "rate", "", "")
+	if err := fs.Parse(strings.Fields(strings.Join(args[2:], " "))); err != nil {
+		return nil, usage
+	}
+	if fs.NArg() > 0 || ed.empty() {
+		return nil, usage
+	}
+
+	t_name := args[0]
+	tr, ok := self.trader(t_name)
+	if !ok {
+		return nil, fmt.Errorf("unkown trader name. :%s", t_name)
+	}
+	id, err := entryId(tr, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := tr.Edit(id, ed.apply)
+	if err != nil {
+		return nil, err
+	}
+
+	self.log.Info("edited", "trader", t_name, "entry", id, "position", e.Position,
+						"size", e.Size, "rate", e.LastRate())
+	return []string{id}, nil
+}
+
+//entryId returns the id of the entry of the trader which starts with the
+//prefix.
+func entryId(tr *miniquet.Trader, prefix string) (string, error) {
+	e, err := tr.FindEntry(prefix)
+	if err != nil {
+		return "", err
+	}
+	return e.Id(), nil
+}
+
+//entryEdit is a change of an entry by edit. Empty values are kept.
+type entryEdit struct {
+	size     string
+	position string
+	rate     string
+}
+
+func (self *entryEdit) empty() bool {
+	return self.size == "" && self.position == "" && self.rate == ""
+}
+
+//apply checks the values and changes the entry.
+func (self *entryEdit) apply(e *miniquet.Entry) error {
+	if self.size != "" {
+		v, err := strconv.ParseFloat(self.size, 64)
+		if err != nil || v <= 0 {
+			return fmt.Errorf("invalid size: '%s'", self.size)
+		}
+		e.Size = v
+	}
+	if self.position != "" {
+		switch strings.ToUpper(self.position) {
+		case gomocoin.SIDE_BUY:
+			e.Position = gomocoin.SIDE_BUY
+		case gomocoin.SIDE_SELL:
+			e.Position = gomocoin.SIDE_SELL
+		default:
+			return fmt.Errorf("invalid position: '%s'", self.position)
+		}
+	}
+	if self.rate != "" {
+		v, err := strconv.ParseFloat(self.rate, 64)
+		if err != nil || v <= 0 {
+			return fmt.Errorf("invalid rate: '%s'", self.rate)
+		}
+		e.Last_fix_rate = v
+	}
+	return nil
+}
+
+//revive brings back an entry stopped by kill9 within the grace period.
+func (self *Miniket2) revive(args []string) ([]string, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("USAGE: revive <id>")
+	}
+
+	ids := []string{}
+	by_id := make(map[string]*miniquet.Trader)
+	for _, tr := range self.traders() {
+		for _, r := range tr.Killed() {
+			ids = append(ids, r.Entry.Id())
+			by_id[r.Entry.Id()] = tr
+		}
+	}
+	id, err := miniquet.MatchId(args[0], ids)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not killed, or is already archived: %s", args[0], err)
+	}
+	return self.reviveEntry(by_id[id], id)
+}
+
+//undo revives the last killed entry.
+func (self *Miniket2) undo(args []string) ([]string, error) {
+	if len(args) != 0 {
+		return nil, fmt.Errorf("USAGE: undo")
+	}
+
+	var last *miniquet.ArchiveRecord
+	var last_tr *miniquet.Trader
+	for _, tr := range self.traders() {
+		for _, r := range tr.Killed() {
+			if last == nil || r.ClosedAt.After(last.ClosedAt) {
+				last = r
+				last_tr = tr
+			}
+		}
+	}
+	if last == nil {
+		return nil, fmt.Errorf("nothing to undo, no killed entry can be revived.")
+	}
+	return self.reviveEntry(last_tr, last.Entry.Id())
+}
+
+func (self *Miniket2) reviveEntry(tr *miniquet.Trader, id string) ([]string, error) {
+	if _, err := tr.RequestRevive(id); err != nil {
+		return nil, err
+	}
+
+	self.log.Info("revived", "trader", tr.Name(), "entry", id)
+	return []string{id}, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"miniquet2/miniquet"
+)
+
+//CommandNames are the commands completed at the head of the line.
+var CommandNames = []string{
+	"add", "archive", "backup", "compact", "detail", "edit", "help",
+	"history", "kill9", "log", "reload", "revive", "stats", "stop", "undo",
+}
+
+var editFlags = []string{"-size", "-position", "-rate"}
+
+//completeArgs returns the candidates of the word after args: the command
+//names, the trader names, the symbols of the rates, or the entry ids.
+func completeArgs(args []string, trs []*miniquet.TraderInfo, symbols []string) []string {
+	if len(args) < 1 {
+		return CommandNames
+	}
+
+	switch args[0] {
+	case "add":
+		switch len(args) {
+		case 1:
+			return traderNames(trs)
+		case 2:
+			return symbols
+		}
+	case "stop", "kill9":
+		switch len(args) {
+		case 1:
+			return traderNames(trs)
+		case 2:
+			return entryIds(trs, args[1])
+		}
+	case "edit":
+		switch len(args) {
+		case 1:
+			return traderNames(trs)
+		case 2:
+			return entryIds(trs, args[1])
+		}
+		switch args[len(args) - 1] {
+		case "-position":
+			return []string{"BUY", "SELL"}
+		case "-size", "-rate":
+			return nil
+		}
+		return editFlags
+	case "detail":
+		if len(args) == 1 {
+			return entryIds(trs, "")
+		}
+	case "revive":
+		if len(args) == 1 {
+			return killedIds(trs)
+		}
+	case "log":
+		switch {
+		case len(args) == 1:
+			return []string{"clear", "entry", "errors", "trader"}
+		case len(args) == 2 && args[1] == "trader":
+			return traderNames(trs)
+		case len(args) == 2 && args[1] == "entry":
+			return entryIds(trs, "")
+		}
+	}
+	return nil
+}
+
+func traderNames(trs []*miniquet.TraderInfo) []string {
+	names := make([]string, 0, len(trs))
+	for _, tr := range trs {
+		names = append(names, tr.Name)
+	}
+	return names
+}
+
+//entryIds returns the ids of the entries of the trader t_name, or of all
+//traders for "".
+func entryIds(trs []*miniquet.TraderInfo, t_name string) []string {
+	ids := make([]string, 0)
+	for _, tr := range trs {
+		if t_name != "" && tr.Name != t_name {
+			continue
+		}
+		for _, en := range tr.Entries {
+			ids = append(ids, en.Id)
+		}
+	}
+	return ids
+}
+
+func killedIds(trs []*miniquet.TraderInfo) []string {
+	ids := make([]string, 0)
+	for _, tr := range trs {
+		for _, k := range tr.Killed {
+			ids = append(ids, k.Id)
+		}
+	}
+	return ids
+}
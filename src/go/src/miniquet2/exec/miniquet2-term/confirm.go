@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+import (
+	"miniquet2/miniquet"
+)
+
+//findEntryInfo returns the entry of the trader whose id is or starts with
+//id.
+func findEntryInfo(trs []*miniquet.TraderInfo, t_name string, id string) (*miniquet.EntryInfo, error) {
+	ids := []string{}
+	by_id := make(map[string]*miniquet.EntryInfo)
+	for _, tr := range trs {
+		if tr.Name != t_name {
+			continue
+		}
+		for _, en := range tr.Entries {
+			ids = append(ids, en.Id)
+			by_id[en.Id] = en
+		}
+	}
+
+	full, err := miniquet.MatchId(id, ids)
+	if err != nil {
+		return nil, err
+	}
+	return by_id[full], nil
+}
+
+//kill9Prompt shows the entry which the kill9 command stops, and asks to
+//confirm it. It returns the command with the whole id, so that the entry
+//confirmed is killed. A typo in the id fails here, before anything is
+//asked.
+func kill9Prompt(trs []*miniquet.TraderInfo, command string) (string, string, error) {
+	args := strings.Fields(command)
+	if len(args) != 3 {
+		return "", "", fmt.Errorf("USAGE: kill9 <trader name> <id>")
+	}
+
+	en, err := findEntryInfo(trs, args[1], args[2])
+	if err != nil {
+		return "", "", fmt.Errorf("kill9: %s of %s: %s", args[2], args[1], err)
+	}
+	prompt := fmt.Sprintf("kill9 %s %s: %s %s(%.5f), win %.3f, last %.3f at %s. kill it? [y/N]",
+				en.Trader, en.Id, en.Position, en.Symbol, en.Size, en.Win,
+				en.LastRate, en.LastDate.Format(FmtTime))
+	return prompt, "kill9 " + en.Trader + " " + en.Id, nil
+}
+
+func isYes(s string) bool {
+	s = strings.ToLower(strings.TrimSpace(s))
+	return s == "y" || s == "yes"
+}
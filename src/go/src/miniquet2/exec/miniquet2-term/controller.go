@@ -35,12 +35,12 @@ type Controller struct {
 
 func NewController(p_ctx context.Context, pollevt func() termbox.Event,
 		msg_send chan *Message, prnt_err func(string, ...interface{})) (*Controller, error) {
-	ctx, cancel := context.WithCancel(p_ctx)
-
 	if pollevt == nil {
 		return nil, fmt.Errorf("cannnot set nil address to poll event handler")
 	}
 
+	ctx, cancel := context.WithCancel(p_ctx)
+
 	return &Controller{
 		pollevt: pollevt,
 		msg_buf: make(chan *Message, SIZE_INPUT_BUFFER),
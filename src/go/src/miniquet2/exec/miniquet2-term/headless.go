@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io"
+	"os"
+	"fmt"
+	"bufio"
+	"strings"
+)
+
+import (
+	"miniquet2/miniquet"
+)
+
+const (
+	SourceStdin string = "stdin"
+
+	SIZE_HEADLESS_HISTORY int = 20
+	SIZE_HEADLESS_ARCHIVE int = 20
+)
+
+//runHeadless trades without the terminal until SIGINT, SIGTERM or 'quit'.
+//Logs are written to stdout as well as the log file, and every line of
+//stdin is run as a command.
+func runHeadless(m2 *Miniket2) {
+	m2.Logger().AddSink(miniquet.NewWriterSink(os.Stdout))
+	if w := m2.Warning(); w != "" {
+		fmt.Fprintf(os.Stdout, "warning: %s\n", w)
+	}
+	go readCommands(m2, os.Stdin, os.Stdout)
+
+	m2.Run()
+}
+
+//readCommands runs the lines of r as commands until EOF. Commands may
+//start with ':' as in the terminal.
+func readCommands(m2 *Miniket2, r io.Reader, w io.Writer) {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		command := strings.TrimPrefix(strings.TrimSpace(sc.Text()), ":")
+		if command == "" {
+			continue
+		}
+
+		switch strings.SplitN(command, " ", 2)[0] {
+		case "quit", "exit":
+			m2.Stop()
+			return
+		case "help":
+			fmt.Fprintln(w, "commands: add, stop, kill9, undo, revive, edit, reload, history, archive, backup, compact, stats, quit. show https://github.com/vouquet/miniquet2")
+		case "history":
+			rs, err := m2.History(SIZE_HEADLESS_HISTORY)
+			if err != nil {
+				m2.Logger().WriteErrLog("history: %s", err)
+				continue
+			}
+			for _, r := range rs {
+				fmt.Fprintln(w, r)
+			}
+		case "kill9":
+			trs, _ := newLocalBackend(m2).Traders()
+			prompt, confirmed, err := kill9Prompt(trs, command)
+			if err != nil {
+				m2.Logger().WriteErrLog("%s", err)
+				continue
+			}
+			fmt.Fprintln(w, prompt)
+			if !sc.Scan() {
+				return
+			}
+			if !isYes(sc.Text()) {
+				fmt.Fprintln(w, "canceled.")
+				continue
+			}
+			m2.Exec(SourceStdin, "", confirmed)
+		case "archive":
+			rs, err := m2.Archive(SIZE_HEADLESS_ARCHIVE)
+			if err != nil {
+				m2.Logger().WriteErrLog("archive: %s", err)
+				continue
+			}
+			for _, r := range rs {
+				fmt.Fprintln(w, r)
+			}
+		case "stats":
+			stats, err := m2.Stats()
+			if err != nil {
+				m2.Logger().WriteErrLog("stats: %s", err)
+				continue
+			}
+			fmt.Fprintln(w, statsLine(stats))
+		default:
+			//errors are logged by Exec.
+			m2.Exec(SourceStdin, "", command)
+		}
+	}
+}
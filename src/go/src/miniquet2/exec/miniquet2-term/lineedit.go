@@ -0,0 +1,387 @@
+package main
+
+import (
+	"os"
+	"sync"
+	"bufio"
+	"strings"
+	"path/filepath"
+)
+
+const (
+	LineHistorySize int = 500
+	LineHistoryFile string = ".miniquet2_history"
+)
+
+//LineEditor is the command line of the terminal. The line is kept as
+//runes, so that the cursor moves over a multibyte character as one.
+type LineEditor struct {
+	buf     []rune
+	pos     int
+	editing bool
+
+	//history is the lines entered, the oldest first. h_pos is the line
+	//shown while browsing it, and h_line keeps the line being typed.
+	history []string
+	h_pos   int
+	h_line  []rune
+	h_path  string
+	h_limit int
+
+	mtx *sync.Mutex
+}
+
+//NewLineEditor returns an empty line. The lines entered are appended to
+//h_path, or kept only in the memory for "".
+func NewLineEditor(h_path string) *LineEditor {
+	return &LineEditor{
+		buf:make([]rune, 0),
+		history:make([]string, 0),
+		h_path:h_path,
+		h_limit:LineHistorySize,
+		mtx:new(sync.Mutex),
+	}
+}
+
+//lineHistoryPath returns the history file in the home directory, or ""
+//when the home directory is unknown.
+func lineHistoryPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil || home == "" {
+		return ""
+	}
+	return filepath.Join(home, LineHistoryFile)
+}
+
+//LoadHistory reads the lines entered in the past sessions. A missing
+//file is an empty history.
+func (self *LineEditor) LoadHistory() error {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	if self.h_path == "" {
+		return nil
+	}
+
+	f, err := os.Open(self.h_path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	lines := make([]string, 0)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if sc.Text() == "" {
+			continue
+		}
+		lines = append(lines, sc.Text())
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	if len(lines) > self.h_limit {
+		lines = lines[len(lines) - self.h_limit:]
+	}
+	self.history = lines
+	self.h_pos = len(self.history)
+
+	if len(lines) < self.h_limit {
+		return nil
+	}
+	return self.rewriteHistory()
+}
+
+//rewriteHistory drops the lines over the limit from the file.
+func (self *LineEditor) rewriteHistory() error {
+	tmp := self.h_path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	for _, l := range self.history {
+		if _, err := f.WriteString(l + "\n"); err != nil {
+			f.Close()
+			os.Remove(tmp)
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, self.h_path)
+}
+
+func (self *LineEditor) appendHistory(line string) error {
+	if n := len(self.history); n > 0 && self.history[n - 1] == line {
+		return nil
+	}
+	self.history = append(self.history, line)
+	if len(self.history) > self.h_limit {
+		self.history = self.history[len(self.history) - self.h_limit:]
+	}
+
+	if self.h_path == "" {
+		return nil
+	}
+	f, err := os.OpenFile(self.h_path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(line + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+//Start begins to edit the line s, with the cursor at the end.
+func (self *LineEditor) Start(s string) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	self.set([]rune(s))
+	self.editing = true
+	self.h_pos = len(self.history)
+	self.h_line = nil
+}
+
+//Stop clears the line and ends the editing.
+func (self *LineEditor) Stop() {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	self.set([]rune{})
+	self.editing = false
+}
+
+func (self *LineEditor) Editing() bool {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	return self.editing
+}
+
+//Line returns the line, and the cursor as the index of the rune.
+func (self *LineEditor) Line() (string, int) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	return string(self.buf), self.pos
+}
+
+//Commit ends the editing and returns the line. A line which is not
+//empty is added to the history.
+func (self *LineEditor) Commit() (string, error) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	line := string(self.buf)
+	self.set([]rune{})
+	self.editing = false
+
+	if strings.TrimSpace(line) == "" {
+		return line, nil
+	}
+	return line, self.appendHistory(line)
+}
+
+func (self *LineEditor) set(buf []rune) {
+	self.buf = buf
+	self.pos = len(buf)
+}
+
+func (self *LineEditor) Insert(r rune) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	buf := make([]rune, 0, len(self.buf) + 1)
+	buf = append(buf, self.buf[:self.pos]...)
+	buf = append(buf, r)
+	buf = append(buf, self.buf[self.pos:]...)
+	self.buf = buf
+	self.pos++
+}
+
+//Backspace deletes the rune before the cursor.
+func (self *LineEditor) Backspace() {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	if self.pos < 1 {
+		return
+	}
+	self.delete(self.pos - 1, self.pos)
+}
+
+//Delete deletes the rune at the cursor.
+func (self *LineEditor) Delete() {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	if self.pos >= len(self.buf) {
+		return
+	}
+	self.delete(self.pos, self.pos + 1)
+}
+
+//DeleteWord deletes the word before the cursor, and the spaces after it.
+func (self *LineEditor) DeleteWord() {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	i := self.pos
+	for i > 0 && self.buf[i - 1] == ' ' {
+		i--
+	}
+	for i > 0 && self.buf[i - 1] != ' ' {
+		i--
+	}
+	self.delete(i, self.pos)
+}
+
+//DeleteHead deletes from the head of the line to the cursor.
+func (self *LineEditor) DeleteHead() {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	self.delete(0, self.pos)
+}
+
+//DeleteTail deletes from the cursor to the end of the line.
+func (self *LineEditor) DeleteTail() {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	self.delete(self.pos, len(self.buf))
+}
+
+func (self *LineEditor) delete(from int, to int) {
+	buf := make([]rune, 0, len(self.buf) - (to - from))
+	buf = append(buf, self.buf[:from]...)
+	buf = append(buf, self.buf[to:]...)
+	self.buf = buf
+	self.pos = from
+}
+
+func (self *LineEditor) Left() {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	if self.pos > 0 {
+		self.pos--
+	}
+}
+
+func (self *LineEditor) Right() {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	if self.pos < len(self.buf) {
+		self.pos++
+	}
+}
+
+func (self *LineEditor) Home() {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	self.pos = 0
+}
+
+func (self *LineEditor) End() {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	self.pos = len(self.buf)
+}
+
+//Prev shows the line entered before the one shown.
+func (self *LineEditor) Prev() {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	if self.h_pos < 1 {
+		return
+	}
+	if self.h_pos == len(self.history) {
+		self.h_line = self.buf
+	}
+	self.h_pos--
+	self.set([]rune(self.history[self.h_pos]))
+}
+
+//Next shows the line entered after the one shown, and at last the line
+//being typed.
+func (self *LineEditor) Next() {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	if self.h_pos >= len(self.history) {
+		return
+	}
+	self.h_pos++
+	if self.h_pos == len(self.history) {
+		self.set(self.h_line)
+		self.h_line = nil
+		return
+	}
+	self.set([]rune(self.history[self.h_pos]))
+}
+
+//Complete completes the word at the cursor. f returns the candidates of
+//the word after the words args. The word is replaced with the only
+//candidate matched, or the prefix all of them share. It returns the
+//candidates matched.
+func (self *LineEditor) Complete(f func(args []string) []string) []string {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	start := self.pos
+	for start > 0 && self.buf[start - 1] != ' ' {
+		start--
+	}
+	word := string(self.buf[start:self.pos])
+	args := strings.Fields(string(self.buf[:start]))
+
+	matched := make([]string, 0)
+	for _, c := range f(args) {
+		if strings.HasPrefix(c, word) {
+			matched = append(matched, c)
+		}
+	}
+	if len(matched) < 1 {
+		return matched
+	}
+
+	fill := commonPrefix(matched)
+	if len(matched) == 1 && (self.pos == len(self.buf) || self.buf[self.pos] != ' ') {
+		fill += " "
+	}
+
+	buf := make([]rune, 0, len(self.buf) + len(fill))
+	buf = append(buf, self.buf[:start]...)
+	buf = append(buf, []rune(fill)...)
+	pos := len(buf)
+	buf = append(buf, self.buf[self.pos:]...)
+	self.buf = buf
+	self.pos = pos
+	return matched
+}
+
+func commonPrefix(ss []string) string {
+	prefix := []rune(ss[0])
+	for _, s := range ss[1:] {
+		rs := []rune(s)
+		i := 0
+		for i < len(prefix) && i < len(rs) && prefix[i] == rs[i] {
+			i++
+		}
+		prefix = prefix[:i]
+	}
+	return string(prefix)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"io/ioutil"
+	"path/filepath"
+)
+
+func typeLine(le *LineEditor, s string) {
+	for _, r := range s {
+		le.Insert(r)
+	}
+}
+
+func expectLine(t *testing.T, le *LineEditor, want string, want_pos int) {
+	t.Helper()
+	line, pos := le.Line()
+	if line != want || pos != want_pos {
+		t.Fatalf("want '%s' at %d, got '%s' at %d", want, want_pos, line, pos)
+	}
+}
+
+func TestLineEditorEdit(t *testing.T) {
+	le := NewLineEditor("")
+	le.Start("")
+	typeLine(le, "stop 日本")
+	expectLine(t, le, "stop 日本", 7)
+
+	le.Left()
+	le.Insert('x')
+	expectLine(t, le, "stop 日x本", 7)
+	le.Backspace()
+	le.Delete()
+	expectLine(t, le, "stop 日", 6)
+
+	le.Home()
+	le.Left()
+	expectLine(t, le, "stop 日", 0)
+	le.Right()
+	le.DeleteHead()
+	expectLine(t, le, "top 日", 0)
+	le.End()
+	le.Right()
+	expectLine(t, le, "top 日", 5)
+
+	typeLine(le, "  ")
+	le.DeleteWord()
+	expectLine(t, le, "top ", 4)
+	le.Home()
+	le.Right()
+	le.DeleteTail()
+	expectLine(t, le, "t", 1)
+
+	line, err := le.Commit()
+	if err != nil || line != "t" {
+		t.Fatalf("commit returned '%s', %v", line, err)
+	}
+	if le.Editing() {
+		t.Fatal("still editing after commit.")
+	}
+	expectLine(t, le, "", 0)
+}
+
+func TestLineEditorHistory(t *testing.T) {
+	le := NewLineEditor("")
+	for _, l := range []string{"list", "stop a", "stop a", " ", "kill9 b"} {
+		le.Start("")
+		typeLine(le, l)
+		if _, err := le.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if strings.Join(le.history, ",") != "list,stop a,kill9 b" {
+		t.Fatalf("history is %q", le.history)
+	}
+
+	le.Start("")
+	typeLine(le, "sh")
+	le.Next()
+	expectLine(t, le, "sh", 2)
+	le.Prev()
+	expectLine(t, le, "kill9 b", 7)
+	le.Prev()
+	le.Prev()
+	le.Prev()
+	expectLine(t, le, "list", 4)
+	le.Next()
+	expectLine(t, le, "stop a", 6)
+	le.Next()
+	le.Next()
+	expectLine(t, le, "sh", 2)
+}
+
+func TestLineEditorHistoryFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "miniquet2-history-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, LineHistoryFile)
+
+	le := NewLineEditor(path)
+	le.h_limit = 3
+	for _, l := range []string{"a", "b", "c", "d"} {
+		le.Start(l)
+		if _, err := le.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	again := NewLineEditor(path)
+	again.h_limit = 3
+	if err := again.LoadHistory(); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(again.history, ",") != "b,c,d" {
+		t.Fatalf("history is %q", again.history)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "b\nc\nd\n" {
+		t.Fatalf("history file is not trimmed: %q", b)
+	}
+
+	if err := NewLineEditor(filepath.Join(dir, "missing")).LoadHistory(); err != nil {
+		t.Fatalf("missing file: %s", err)
+	}
+}
+
+func TestLineEditorComplete(t *testing.T) {
+	cands := func(args []string) []string {
+		if len(args) == 0 {
+			return []string{"stop", "stats", "kill9"}
+		}
+		return []string{"alice", "john"}
+	}
+
+	le := NewLineEditor("")
+	le.Start("st")
+	if m := le.Complete(cands); len(m) != 2 {
+		t.Fatalf("matched %v", m)
+	}
+	expectLine(t, le, "st", 2)
+
+	typeLine(le, "o")
+	le.Complete(cands)
+	expectLine(t, le, "stop ", 5)
+
+	typeLine(le, "j")
+	le.Complete(cands)
+	expectLine(t, le, "stop john ", 10)
+
+	le.Start("stop x")
+	if m := le.Complete(cands); len(m) != 0 {
+		t.Fatalf("matched %v", m)
+	}
+	expectLine(t, le, "stop x", 6)
+
+	//the word before the cursor is completed, and the rest is kept.
+	le.Start("k alice")
+	le.Home()
+	le.Right()
+	le.Complete(cands)
+	expectLine(t, le, "kill9 alice", 5)
+}
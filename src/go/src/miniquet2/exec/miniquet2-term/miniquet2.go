@@ -1,21 +1,24 @@
 package main
 
-import "time"
-
 import (
 	"os"
 	"os/user"
+	"os/signal"
 	"fmt"
 	"flag"
 	"path/filepath"
 	"sync"
+	"time"
+	"syscall"
 	"context"
-	"strconv"
+	"strings"
+	"sort"
+	"net/http"
 )
 
 import (
+	"github.com/vouquet/shop"
 	"github.com/vouquet/go-gmo-coin/gomocoin"
-	"github.com/vouquet/brain"
 )
 
 import (
@@ -24,79 +27,274 @@ import (
 
 const (
 	MiniketName string = "miniquet2-term v0.0.1"
+	DefaultLogPath string = "./miniquet2.log"
+	DefaultAuditPath string = "./miniquet2.audit"
+
+	SIZE_LOG_RING int = 10000
+
+	//KillReapInterval is how often the killed entries over the grace
+	//period are archived.
+	KillReapInterval time.Duration = 10 * time.Second
 )
 
 var (
 	StoragePath  string
+	StorageType  string
+	ConfPath     string
 	Conf         *miniquet.Config
+	Subcommand   string
+	Passphrase   []byte
+	Daemon       bool
+	Attach       bool
+	Headless     bool
 )
 
+//Miniket2 owns the traders, the storage and the connection to the shop.
+//Terminals show and operate it through a Backend.
 type Miniket2 struct {
-	m   *Model
-
-	trs  map[string]*miniquet.Trader
-	shop *gomocoin.GoMOcoin
-	st   *miniquet.Storage
+	conf   *miniquet.Config
+	trs    map[string]*miniquet.Trader
+	shop   *gomocoin.GoMOcoin
+	st     *miniquet.Storage
+	st_path string
+	nt     *miniquet.NotifyHub
+	nt_sub *miniquet.Subscription
+	bus    *miniquet.EventBus
+	logf   *miniquet.RotateFile
+	audit  *miniquet.AuditLog
+	mtrc   *miniquet.Metrics
+	mtrc_srv *http.Server
+	api    *miniquet.ApiServer
+	log    *miniquet.LevelLogger
+	logs   *miniquet.LogRing
+	rates  map[string]shop.Rate
+	rates_t time.Time
+
+	warning string
+	kill_grace time.Duration
+
+	tick_ch chan time.Duration
+	backup_ch chan miniquet.BackupConfig
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	//run_wg waits for Run on Close. closed stops a Run started after it.
+	run_wg *sync.WaitGroup
+	closed bool
+	mtx        *sync.Mutex
+	reload_mtx *sync.Mutex
 }
 
-func NewMiniket2(api string, secret string, s_path string) (*Miniket2, error) {
-	m, err := NewModel(context.Background())
+func NewMiniket2(conf *miniquet.Config, s_path string) (*Miniket2, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	gmocoin, err := gomocoin.NewGoMOcoin(conf.ApiKey, conf.SecretKey, ctx)
 	if err != nil {
+		cancel()
 		return nil, err
 	}
 
-	gmocoin, err := gomocoin.NewGoMOcoin(api, secret, m.ContextWithCancel())
+	storage, err := miniquet.OpenStorageWith(StorageType, s_path, nil)
 	if err != nil {
+		cancel()
 		return nil, err
 	}
 
-	storage, err := miniquet.OpenStorage(s_path, nil)
+	logf, err := openLogFile(&conf.Log)
 	if err != nil {
+		cancel()
+		storage.Close()
 		return nil, err
 	}
+	logs := miniquet.NewLogRing(SIZE_LOG_RING)
+	tail, tail_err := logf.Tail(SIZE_LOG_RING)
+	for _, r := range tail {
+		logs.WriteRecord(r)
+	}
+	level, _ := miniquet.ParseLevel(conf.Log.Level)
+	log := miniquet.NewLevelLogger(level, logf, logs)
+	if tail_err != nil {
+		log.Warn("cannot read the last logs", "path", logf.Path(), "err", tail_err)
+	}
+
+	a_path := conf.Log.AuditFile
+	if a_path == "" {
+		a_path = DefaultAuditPath
+	}
+	audit, err := miniquet.OpenAuditLog(a_path)
+	if err != nil {
+		cancel()
+		logf.Close()
+		storage.Close()
+		return nil, err
+	}
+	for _, e := range audit.Skipped() {
+		log.Warn("skipped a broken line of the audit log", "path", e.Path, "line", e.Line, "err", e.Err)
+	}
+
+	bus := miniquet.NewEventBus()
 
 	self := &Miniket2{
-		m:m,
+		conf: conf,
 		trs: make(map[string]*miniquet.Trader),
 		shop: gmocoin,
 		st: storage,
+		st_path: s_path,
+		bus: bus,
+		logf: logf,
+		audit: audit,
+		log: log,
+		logs: logs,
+		rates: make(map[string]shop.Rate),
+		kill_grace: conf.Grace(),
+		tick_ch: make(chan time.Duration, 1),
+		backup_ch: make(chan miniquet.BackupConfig, 1),
+		ctx: ctx,
+		cancel: cancel,
+		run_wg: new(sync.WaitGroup),
+		mtx: new(sync.Mutex),
+		reload_mtx: new(sync.Mutex),
 	}
 
-	if err := self.buildTrader(); err != nil {
+	if err := self.start(conf); err != nil {
+		self.Close()
 		return nil, err
 	}
-	if err := self.buildCommand(); err != nil {
-		return nil, err
+	return self, nil
+}
+
+//start builds the traders and the servers on the storage. Close closes
+//what was built when it fails.
+func (self *Miniket2) start(conf *miniquet.Config) error {
+	nt, err := self.buildNotifier(conf.Notifier)
+	if err != nil {
+		return err
+	}
+	self.setNotifier(nt)
+
+	if err := self.buildTrader(conf.Trader); err != nil {
+		return err
+	}
+	if err := self.verifyStorage(); err != nil {
+		return err
 	}
 	if err := self.loadStorage(); err != nil {
-		return nil, err
+		return err
+	}
+	if err := self.serveMetrics(&conf.Metrics); err != nil {
+		return err
+	}
+	return self.serveApi(&conf.Api)
+}
+
+func (self *Miniket2) serveMetrics(c *miniquet.MetricsConfig) error {
+	if c.Listen == "" {
+		return nil
 	}
 
-	return self, nil
+	mtrc := miniquet.NewMetrics()
+	mtrc.TraderSource(self.traders)
+	mtrc.Listen(self.bus.Subscribe(miniquet.SIZE_EVENT_BUFFER, miniquet.DropOldest,
+			miniquet.EventOrderSubmitted, miniquet.EventOrderFilled, miniquet.EventTradeFailed))
+	self.st.ObserveHandler(mtrc.ObserveStorage)
+
+	srv, err := mtrc.Serve(c.Listen)
+	if err != nil {
+		return fmt.Errorf("cannot listen metrics: %s", err)
+	}
+	self.mtrc = mtrc
+	self.mtrc_srv = srv
+	return nil
 }
 
+//Run trades until Stop, SIGINT or SIGTERM. SIGHUP reloads the config.
+//It returns at once after Close.
 func (self *Miniket2) Run() {
+	self.mtx.Lock()
+	if self.closed {
+		self.mtx.Unlock()
+		return
+	}
+	self.run_wg.Add(1)
+	self.mtx.Unlock()
+	defer self.run_wg.Done()
+
 	wg := new(sync.WaitGroup)
 
-	self.run_model(wg)
 	self.run_trader(wg)
+	self.run_summary(wg)
+	self.run_signal(wg)
+	self.run_backup(wg)
+	self.run_reaper(wg)
 
-	self.m.WriteMsgLog("started miniquet2")
+	self.log.WriteMsgLog("started miniquet2")
 
 	wg.Wait()
+	self.log.WriteMsgLog("stopped miniquet2")
+}
+
+func (self *Miniket2) Stop() {
+	self.cancel()
+}
+
+//Context is done when Miniket2 stops.
+func (self *Miniket2) Context() context.Context {
+	return self.ctx
+}
+
+func (self *Miniket2) Logger() *miniquet.LevelLogger {
+	return self.log
 }
 
+//Close stops Run and waits for it, including the trades in flight, before
+//closing the storage.
 func (self *Miniket2) Close() error {
-	self.m.Close()
+	self.mtx.Lock()
+	self.closed = true
+	self.mtx.Unlock()
+
+	self.cancel()
+	self.run_wg.Wait()
+
+	self.bus.Close()
+	if nt := self.notifier(); nt != nil {
+		nt.Close()
+	}
+	self.logf.Close()
+	self.audit.Close()
+	if self.mtrc_srv != nil {
+		self.mtrc_srv.Close()
+	}
+	if self.api != nil {
+		self.api.Close()
+	}
 	return self.st.Close()
 }
 
-func (self *Miniket2) run_model(wg *sync.WaitGroup) {
+func (self *Miniket2) run_signal(wg *sync.WaitGroup) {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 
-		self.m.Run()
+		sig_ch := make(chan os.Signal, 1)
+		signal.Notify(sig_ch, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+		defer signal.Stop(sig_ch)
+
+		for {
+			select {
+			case <- self.ctx.Done():
+				return
+			case sig := <- sig_ch:
+				if sig != syscall.SIGHUP {
+					self.log.WriteMsgLog("got %s, stopping", sig)
+					self.Stop()
+					return
+				}
+				if _, err := self.Exec(SourceSignal, "", "reload"); err != nil {
+					self.log.WriteErrLog("%s", err)
+				}
+			}
+		}
 	}()
 }
 
@@ -105,23 +303,41 @@ func (self *Miniket2) run_trader(wg *sync.WaitGroup) {
 	go func() {
 		defer wg.Done()
 
-		ctx := self.m.ContextWithCancel()
-		t := time.NewTicker(1 * time.Second)
+		ctx := self.ctx
+		t := time.NewTicker(self.config().Tick())
+		defer func() {
+			t.Stop()
+		}()
+
 		for {
 			select {
 			case <- ctx.Done():
 				return
-			case <- t.C:
+			case d := <- self.tick_ch:
+				t.Stop()
+				t = time.NewTicker(d)
+			case tick := <- t.C:
+				wg.Add(1)
 				go func() {
+					defer wg.Done()
+
+					start := time.Now()
 					rates, err := self.shop.GetRate()
+					self.mtrc.ObserveRateFetch(time.Since(start), err)
 					if err != nil {
-						self.m.WriteErrLog("cannot update gmocoin: %s", err)
+						self.log.WriteErrLog("cannot update gmocoin: %s", err)
 						return
 					}
 
-					self.m.UpdateStatus(rates)
-					for _, t := range self.trs {
-						go t.Do(self.m, rates)
+					self.mtrc.ObserveTickLag(time.Since(tick))
+					self.setRates(rates)
+					self.bus.Publish(miniquet.NewRateUpdated(rates))
+					for _, t := range self.traders() {
+						wg.Add(1)
+						go func(t *miniquet.Trader) {
+							defer wg.Done()
+							t.Do(self.log, rates)
+						}(t)
 					}
 				}()
 			}
@@ -129,6 +345,113 @@ func (self *Miniket2) run_trader(wg *sync.WaitGroup) {
 	}()
 }
 
+//run_reaper archives the entries killed before the grace period. They
+//cannot be revived after it.
+func (self *Miniket2) run_reaper(wg *sync.WaitGroup) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		t := time.NewTicker(KillReapInterval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <- self.ctx.Done():
+				return
+			case <- t.C:
+				self.reapKilled()
+			}
+		}
+	}()
+}
+
+func (self *Miniket2) reapKilled() {
+	before := time.Now().Add(-self.killGrace())
+	for _, tr := range self.traders() {
+		ids, err := tr.ExpireKilled(before)
+		for _, id := range ids {
+			self.log.Info("archived a killed entry", "trader", tr.Name(), "entry", id)
+		}
+		if err != nil {
+			self.log.WriteErrLog("cannot archive the killed entries of %s: %s", tr.Name(), err)
+		}
+	}
+}
+
+func (self *Miniket2) killGrace() time.Duration {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	return self.kill_grace
+}
+
+func (self *Miniket2) setKillGrace(d time.Duration) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	self.kill_grace = d
+}
+
+func (self *Miniket2) run_summary(wg *sync.WaitGroup) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ctx := self.ctx
+		for {
+			now := time.Now()
+			y, m, d := now.Date()
+			next := time.Date(y, m, d + 1, 0, 0, 0, 0, now.Location())
+
+			t := time.NewTimer(next.Sub(now))
+			select {
+			case <- ctx.Done():
+				t.Stop()
+				return
+			case <- t.C:
+				self.notifier().Notify(self.summary())
+			}
+		}
+	}()
+}
+
+func (self *Miniket2) summary() *miniquet.Notice {
+	lines := []string{}
+	for _, tr := range self.traders() {
+		closed, _ := tr.ClosedWin()
+		lines = append(lines, fmt.Sprintf("%s: win %.3f (%d entries, %d closed)", tr.Name(), tr.Win(),
+												len(tr.Entries()), closed))
+	}
+	return miniquet.NewNotice(miniquet.NoticeDailySummary, "%s", strings.Join(lines, ", "))
+}
+
+//verifyStorage quarantines the entries which cannot be decoded, so that
+//the healthy ones are still traded. The quarantined ones are warned.
+func (self *Miniket2) verifyStorage() error {
+	report, err := self.st.Check(true)
+	if err != nil {
+		return fmt.Errorf("cannot check the storage: %s", err)
+	}
+
+	for _, r := range report.Broken {
+		self.log.Warn("quarantined a broken entry", "key", r.Key, "reason", r.Reason)
+	}
+	if report.Dangling > 0 || report.Missing > 0 {
+		self.log.Warn("repaired the entry indexes", "dangling", report.Dangling, "missing", report.Missing)
+	}
+	if n := len(report.Broken) + report.Quarantined; n > 0 {
+		self.warning = fmt.Sprintf("%d broken entries are quarantined and not traded. see 'miniquet2-term check'.", n)
+		self.log.Warn(self.warning)
+	}
+	return nil
+}
+
+//Warning is shown when the terminal starts.
+func (self *Miniket2) Warning() string {
+	return self.warning
+}
+
 func (self *Miniket2) loadStorage() error {
 	ens, err := self.st.Walk()
 	if err != nil {
@@ -136,7 +459,7 @@ func (self *Miniket2) loadStorage() error {
 	}
 
 	for _, en := range ens {
-		tr, ok := self.trs[en.Trader]
+		tr, ok := self.trader(en.Trader)
 		if !ok {
 			return fmt.Errorf("cannt found '%s' trader.", en.Trader)
 		}
@@ -145,96 +468,162 @@ func (self *Miniket2) loadStorage() error {
 			return fmt.Errorf("cannt append, %s", err)
 		}
 	}
-	return nil
-}
-
-func (self *Miniket2) buildTrader() error {
-	a_tr := miniquet.NewTrader("alice", "Trade with a difference of 0.2 point.", self.shop, self.st)
-	a_tr.SetCheckFunc(brain.Alice)
-	self.trs["alice"] = a_tr
 
-	j_tr := miniquet.NewTrader("john", "Trade with a difference of 1 point.", self.shop, self.st)
-	j_tr.SetCheckFunc(brain.John)
-	self.trs["john"] = j_tr
+	err = self.st.ForEachKilled(func(r *miniquet.ArchiveRecord) error {
+		tr, ok := self.trader(r.Entry.Trader)
+		if !ok {
+			return fmt.Errorf("cannt found '%s' trader.", r.Entry.Trader)
+		}
+		return tr.AppendKilled(r)
+	})
+	if err != nil {
+		return fmt.Errorf("cannot load the killed entries: %s", err)
+	}
+	return self.loadArchive(self.traders()...)
+}
 
-	for _, tr := range self.trs {
-		self.m.AddTrader(tr)
+//loadArchive counts the archived entries of the traders. The archive of
+//a removed trader is kept, and counted when it is added again.
+func (self *Miniket2) loadArchive(trs ...*miniquet.Trader) error {
+	by_name := make(map[string]*miniquet.Trader)
+	for _, tr := range trs {
+		by_name[tr.Name()] = tr
 	}
 
+	err := self.st.ForEachArchive(func(r *miniquet.ArchiveRecord) error {
+		if tr, ok := by_name[r.Entry.Trader]; ok {
+			tr.AddClosed(r.Win)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("cannot load the archive: %s", err)
+	}
 	return nil
 }
 
-func (self *Miniket2) buildCommand() error {
-	self.m.CommandHandlerAdd(func(args []string) error {
-		if len(args) != 4 {
-			return fmt.Errorf("args less than 4. USAGE: add <trader name> <symbol> <size> <buy rate>, %s, %s", len(args), args)
-		}
+func (self *Miniket2) buildTrader(confs []miniquet.TraderConfig) error {
+	if len(confs) < 1 {
+		confs = DefaultTraders
+	}
 
-		t_name := args[0]
-		symbol := args[1]
-		size, err := strconv.ParseFloat(args[2], 64)
+	for i := range confs {
+		tr, err := miniquet.NewTraderFromConfig(&confs[i], self.shop, self.st)
 		if err != nil {
 			return err
 		}
-		want_rate, err := strconv.ParseFloat(args[3], 64)
-		if err != nil {
+		if err := self.addTrader(tr); err != nil {
 			return err
 		}
+	}
+	return nil
+}
 
-		tr, ok := self.trs[t_name]
-		if !ok {
-			return fmt.Errorf("unkown trader name. :%s", t_name)
-		}
+func (self *Miniket2) addTrader(tr *miniquet.Trader) error {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
 
-		if err := tr.Add(symbol, size, want_rate); err != nil {
-			return err
-		}
+	if _, ok := self.trs[tr.Name()]; ok {
+		return fmt.Errorf("trader '%s' is defined twice.", tr.Name())
+	}
 
-		self.m.WriteMsgLog("added %s, %s, %v, %v", t_name, symbol, size, want_rate)
-		return nil
-	})
+	r := &miniquet.TraderRecord{Name: tr.Name(), Description: tr.Description(), Updated: time.Now()}
+	if err := self.st.PutTrader(r); err != nil {
+		return err
+	}
 
-	self.m.CommandHandlerStop(func(args []string) error {
-		if len(args) != 2 {
-			return fmt.Errorf("args less than 2. USAGE: stop <trader name> <id>")
-		}
+	tr.SetEventBus(self.bus)
+	self.trs[tr.Name()] = tr
+	return nil
+}
 
-		t_name := args[0]
-		id := args[1]
-		tr, ok := self.trs[t_name]
-		if !ok {
-			return fmt.Errorf("unkown trader name. :%s", t_name)
-		}
+func (self *Miniket2) removeTrader(tr *miniquet.Trader) error {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
 
-		if err := tr.RequestStop(id); err != nil {
-			return err
-		}
+	if _, ok := self.trs[tr.Name()]; !ok {
+		return fmt.Errorf("trader '%s' does not exist.", tr.Name())
+	}
 
-		self.m.WriteMsgLog("stoped : %s", id)
-		return nil
-	})
+	if err := self.st.DeleteTrader(tr.Name()); err != nil {
+		return err
+	}
+	delete(self.trs, tr.Name())
+	return nil
+}
 
-	self.m.CommandHandlerKill9(func(args []string) error {
-		if len(args) != 2 {
-			return fmt.Errorf("args less than 2. USAGE: kill9 <trader name> <id>")
-		}
+func openLogFile(c *miniquet.LogConfig) (*miniquet.RotateFile, error) {
+	path := c.File
+	if path == "" {
+		path = DefaultLogPath
+	}
+	return miniquet.OpenRotateFile(path, c.RotateOpt())
+}
 
-		t_name := args[0]
-		id := args[1]
-		tr, ok := self.trs[t_name]
-		if !ok {
-			return fmt.Errorf("unkown trader name. :%s", t_name)
-		}
+func (self *Miniket2) trader(name string) (*miniquet.Trader, bool) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
 
-		if err := tr.RequestKill9(id); err != nil {
-			return err
-		}
+	tr, ok := self.trs[name]
+	return tr, ok
+}
 
-		self.m.WriteMsgLog("killed : %s", id)
-		return nil
-	})
+//traders returns the traders sorted by name.
+func (self *Miniket2) traders() []*miniquet.Trader {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
 
-	return nil
+	trs := make([]*miniquet.Trader, 0, len(self.trs))
+	for _, tr := range self.trs {
+		trs = append(trs, tr)
+	}
+	sort.SliceStable(trs, func(i, j int) bool { return trs[i].Name() < trs[j].Name() })
+	return trs
+}
+
+func (self *Miniket2) notifier() *miniquet.NotifyHub {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	return self.nt
+}
+
+func (self *Miniket2) buildNotifier(confs []miniquet.NotifierConfig) (*miniquet.NotifyHub, error) {
+	nt, err := miniquet.NewNotifyHub(confs)
+	if err != nil {
+		return nil, err
+	}
+	nt.ErrorHandler(self.log.WriteErrLog)
+	return nt, nil
+}
+
+//setNotifier subscribes the notifier to the bus, and returns the previous
+//one after unsubscribing it.
+func (self *Miniket2) setNotifier(nt *miniquet.NotifyHub) *miniquet.NotifyHub {
+	sub := self.bus.Subscribe(miniquet.SIZE_EVENT_BUFFER, miniquet.DropOldest,
+						miniquet.EventOrderFilled, miniquet.EventEntryClosed,
+						miniquet.EventTradeFailed, miniquet.EventRiskBreach)
+	nt.Listen(sub)
+
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	old := self.nt
+	if self.nt_sub != nil {
+		self.nt_sub.Close()
+	}
+	self.nt = nt
+	self.nt_sub = sub
+	return old
+}
+
+func isStorageType(s_type string) bool {
+	for _, t := range miniquet.StorageTypes {
+		if t == s_type {
+			return true
+		}
+	}
+	return false
 }
 
 func die(s string, msg ...interface{}) {
@@ -242,20 +631,40 @@ func die(s string, msg ...interface{}) {
 	os.Exit(1)
 }
 
-func init() {
+//setup parses the flags and loads the config. It is not init, so that
+//the tests of this package run without the flags and the config.
+func setup() {
 	var c_path string
 	var r_path string
 	flag.StringVar(&c_path, "c", "", "config path.")
 	flag.StringVar(&r_path, "r", "./miniquet2.ldb", "record storage path.")
+	flag.StringVar(&StorageType, "storage", miniquet.STORAGE_LEVELDB, "record storage type. leveldb, memory or log.")
+	flag.BoolVar(&Daemon, "daemon", false, "trade without the terminal. attach to it with -attach.")
+	flag.BoolVar(&Attach, "attach", false, "attach the terminal to a running daemon.")
+	flag.BoolVar(&Headless, "headless", false, "trade without the terminal, logging to stdout and reading commands from stdin.")
 	flag.Parse()
 
 	if flag.NArg() < 0 {
-		die("usage : miniquet2 -c <config path> -b <record storage path>")
+		die("usage : miniquet2-term [-c <config path>] [-r <record storage path>] [-storage <type>] [-daemon|-attach|-headless] [<subcommand>]")
+	}
+	modes := 0
+	for _, b := range []bool{Daemon, Attach, Headless} {
+		if b {
+			modes++
+		}
+	}
+	if modes > 1 {
+		die("only one of -daemon, -attach and -headless can be used.")
 	}
 
 	if r_path == "" {
 		die("empty record storage path.")
 	}
+	if !isStorageType(StorageType) {
+		die("unknown storage type '%s', use one of %s.", StorageType, strings.Join(miniquet.StorageTypes, ", "))
+	}
+	registerStrategies()
+
 	if c_path == "" {
 		usr, err := user.Current()
 		if err != nil {
@@ -264,21 +673,114 @@ func init() {
 		c_path = usr.HomeDir + "/.miniquet2"
 	}
 
-	cfg, err := miniquet.LoadConfig(filepath.Clean(c_path))
+	ConfPath = filepath.Clean(c_path)
+	StoragePath  = r_path
+	Subcommand = flag.Arg(0)
+	if Subcommand != "" {
+		return
+	}
+
+	opt := *miniquet.DefaultConfigOpt
+	opt.SkipSecret = Attach
+	opt.SkipStrategy = Attach
+	opt.Passphrase = func(prompt string) ([]byte, error) {
+		pass, err := miniquet.ReadPassphrase(prompt)
+		if err != nil {
+			return nil, err
+		}
+		Passphrase = pass
+		return pass, nil
+	}
+
+	cfg, err := miniquet.LoadConfigWithOpt(ConfPath, &opt)
 	if err != nil {
-		die("cannot load a config: %s", err)
+		die("cannot load a config:\n%s", err)
+	}
+	for _, w := range cfg.Warnings() {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", w)
+	}
+
+	if (Daemon || Attach) && cfg.Api.Listen == "" {
+		die("-daemon and -attach need Listen of [Api] in %s.", ConfPath)
 	}
 
 	Conf = cfg
-	StoragePath  = r_path
 }
 
 func main() {
-	m2, err := NewMiniket2(Conf.ApiKey, Conf.SecretKey, StoragePath)
+	setup()
+
+	if Subcommand != "" {
+		if err := runSubcommand(Subcommand, flag.Args()[1:]); err != nil {
+			die("%s: %s", Subcommand, err)
+		}
+		return
+	}
+
+	if Attach {
+		if err := attach(); err != nil {
+			die("%s", err)
+		}
+		return
+	}
+
+	m2, err := NewMiniket2(Conf, StoragePath)
+	if err != nil {
+		die("%s", recoverHint(err))
+	}
+
+	//die exits without the deferred functions, so m2 is closed first.
+	err = runMiniket2(m2)
+	m2.Close()
 	if err != nil {
 		die("%s", err)
 	}
-	defer m2.Close()
+}
+
+//runMiniket2 runs m2 in the mode given by the flags until it stops.
+func runMiniket2(m2 *Miniket2) error {
+	if Daemon {
+		m2.Run()
+		return nil
+	}
+	if Headless {
+		runHeadless(m2)
+		return nil
+	}
+
+	go m2.Run()
+	defer m2.Stop()
+	return runTerm(m2.Context(), newLocalBackend(m2), MiniketName, m2.Warning())
+}
 
-	m2.Run()
+//runTerm shows the terminal of the backend until it is closed. The
+//warning is shown at the start.
+func runTerm(ctx context.Context, backend Backend, title string, warning string) error {
+	m, err := NewModel(ctx, backend, title)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	if warning != "" {
+		m.WriteErrLog("%s", warning)
+	}
+
+	m.Run()
+	return nil
+}
+
+//attach runs the terminal of the daemon listening Listen of [Api].
+//Closing it does not stop the daemon.
+func attach() error {
+	token, err := Conf.Api.LoadToken()
+	if err != nil {
+		return err
+	}
+
+	cl := miniquet.NewApiClient(Conf.Api.Listen, token)
+	if _, err := cl.Traders(); err != nil {
+		return fmt.Errorf("cannot attach to %s: %s", Conf.Api.Listen, err)
+	}
+	return runTerm(context.Background(), cl, MiniketName + " @ " + Conf.Api.Listen, "")
 }
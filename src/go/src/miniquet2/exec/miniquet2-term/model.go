@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"sync"
 	"time"
 	"context"
 	"strings"
@@ -9,11 +10,6 @@ import (
 
 import (
 	"github.com/nsf/termbox-go"
-	"github.com/vouquet/shop"
-)
-
-import (
-	"miniquet2/miniquet"
 )
 
 type Message struct {
@@ -25,6 +21,11 @@ type Model struct {
 	view  *View
 	ctlr  *Controller
 	msg_ch chan *Message
+	title string
+
+	backend Backend
+	err_msg string
+	rates_t time.Time
 
 	v_st  *StatusViewLayer
 	m_st  *StatusModel
@@ -35,16 +36,37 @@ type Model struct {
 	v_log *LogViewLayer
 	m_log *LogModel
 
-	com_buf         string
-	com_hdlr_add    func([]string)error
-	com_hdlr_stop   func([]string)error
-	com_hdlr_kill9  func([]string)error
+	v_hist *HistoryViewLayer
+	m_hist *HistoryModel
+
+	v_arch *ArchiveViewLayer
+	m_arch *ArchiveModel
+
+	v_dtl *DetailViewLayer
+	m_dtl *DetailModel
+
+	//layer is shown at the place of the progress view, nil for the
+	//progress view itself.
+	layer ViewLayer
+
+	line            *LineEditor
+	//search is the line of the word searched in the log pane.
+	search          *LineEditor
+	//log_focus sends the keys to the log pane.
+	log_focus       bool
+	//com_hint is the candidates of the completion shown.
+	com_hint        string
+	//confirm is the command waiting for 'y'.
+	confirm         string
 
 	ctx    context.Context
 	cancel context.CancelFunc
+	mtx    *sync.Mutex
 }
 
-func NewModel(b_ctx context.Context) (*Model, error) {
+//NewModel returns the terminal of the backend. The title tells where
+//the backend is.
+func NewModel(b_ctx context.Context, backend Backend, title string) (*Model, error) {
 	if b_ctx == nil {
 		b_ctx = context.Background()
 	}
@@ -52,28 +74,45 @@ func NewModel(b_ctx context.Context) (*Model, error) {
 
 	v, err := NewView(termbox.Output256, termbox.ColorDefault, termbox.ColorDefault)
 	if err != nil {
+		cancel()
 		return nil, fmt.Errorf("cannot create view interface: %s", err)
 	}
 
 	v_st := NewStatusViewLayer(1)
 	v_pg := NewProgressViewLayer(2)
 	v_log := NewLogViewLayer(1)
+	v_hist := NewHistoryViewLayer(2)
+	v_arch := NewArchiveViewLayer(2)
+	v_dtl := NewDetailViewLayer(2)
 	m_st := NewStatusModel()
 	m_pg := NewProgressModel()
 	m_log := NewLogModel()
+	m_hist := NewHistoryModel()
+	m_arch := NewArchiveModel()
+	m_dtl := NewDetailModel()
 
-	v.SetTitle(MiniketName)
+	v.SetTitle(title)
 	v.AddViewLayer(v_st)
 	m_st.ViewHandler(v_st.SetValues)
 	v.AddViewLayer(v_pg)
 	m_pg.ViewHandler(v_pg.SetValues)
 	v.AddViewLayer(v_log)
 	m_log.ViewHandler(v_log.SetValues)
+	m_hist.ViewHandler(v_hist.SetValues)
+	m_arch.ViewHandler(v_arch.SetValues)
+	m_dtl.ViewHandler(v_dtl.SetValues)
+
+	line := NewLineEditor(lineHistoryPath())
+	if err := line.LoadHistory(); err != nil {
+		v.SetOperandErr("cannot load the command history: %s", err)
+	}
 
 	pollevt_f := v.GetFuncPollEvent()
 	msg_ch := make(chan *Message)
-	c, err := NewController(ctx, pollevt_f, msg_ch, m_log.WriteErrLog)
+	c, err := NewController(ctx, pollevt_f, msg_ch, v.SetOperandErr)
 	if err != nil {
+		cancel()
+		v.Close()
 		return nil, fmt.Errorf("cannot create controller: %s", err)
 	}
 
@@ -81,6 +120,9 @@ func NewModel(b_ctx context.Context) (*Model, error) {
 		view: v,
 		ctlr: c,
 		msg_ch: msg_ch,
+		title: title,
+
+		backend: backend,
 
 		v_st: v_st,
 		m_st: m_st,
@@ -91,8 +133,21 @@ func NewModel(b_ctx context.Context) (*Model, error) {
 		v_log: v_log,
 		m_log: m_log,
 
+		v_hist: v_hist,
+		m_hist: m_hist,
+
+		v_arch: v_arch,
+		m_arch: m_arch,
+
+		v_dtl: v_dtl,
+		m_dtl: m_dtl,
+
+		line: line,
+		search: NewLineEditor(""),
+
 		ctx: ctx,
 		cancel: cancel,
+		mtx: new(sync.Mutex),
 	}
 
 	self.ctlr.SignalInterruptHandler(cancel)
@@ -101,11 +156,11 @@ func NewModel(b_ctx context.Context) (*Model, error) {
 	return self, nil
 }
 
-func (self *Model) ContextWithCancel() context.Context {
-	ctx, _ := context.WithCancel(self.ctx)
-	return ctx
+func (self *Model) Context() context.Context {
+	return self.ctx
 }
 
+//Run shows the terminal until it is closed by Ctrl-C or the context.
 func (self *Model) Run() {
 	self.ctlr.Run()
 	self.run_reflesher()
@@ -117,20 +172,87 @@ func (self *Model) run_reflesher() {
 	go func() {
 		defer t.Stop()
 
+		self.pull()
 		for {
 			select {
 			case <- self.ctx.Done():
 				return
 			case <- t.C:
+				self.pull()
 				self.refresh()
 			}
 		}
 	}()
 }
 
+//pull copies the state of the backend into the models.
+func (self *Model) pull() {
+	trs, err := self.backend.Traders()
+	if err != nil {
+		self.setBackendErr(err)
+		return
+	}
+	self.m_pg.Update(trs)
+	self.m_dtl.Update(trs)
+
+	rates, err := self.backend.Rates()
+	if err != nil {
+		self.setBackendErr(err)
+		return
+	}
+	if t := ratesTime(rates); t.After(self.rates_t) {
+		self.rates_t = t
+		self.m_st.UpdateStatus(rates)
+	}
+
+	logs, err := self.backend.Logs(self.m_log.PullSize())
+	if err != nil {
+		self.setBackendErr(err)
+		return
+	}
+	self.m_log.Update(logs)
+
+	if self.isLayer(self.v_hist) {
+		rs, err := self.backend.History(HistorySize)
+		if err != nil {
+			self.setBackendErr(err)
+			return
+		}
+		self.m_hist.Load(rs)
+	}
+	if self.isLayer(self.v_arch) {
+		infos, err := self.backend.Archive(ArchiveSize)
+		if err != nil {
+			self.setBackendErr(err)
+			return
+		}
+		self.m_arch.Load(infos)
+	}
+	self.setBackendErr(nil)
+}
+
+//setBackendErr shows an error of the backend once, and clears it when
+//the backend comes back.
+func (self *Model) setBackendErr(err error) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	if err == nil {
+		if self.err_msg != "" {
+			self.err_msg = ""
+			self.view.SetOperandMsg("backend is back.")
+		}
+		return
+	}
+	if err.Error() == self.err_msg {
+		return
+	}
+	self.err_msg = err.Error()
+	self.view.SetOperandErr("backend error: %s", err)
+}
+
 func (self *Model) run_keymanager() {
 	com_ch := make(chan string)
-	var command bool = false
 
 	go self.run_operator(com_ch)
 
@@ -144,79 +266,275 @@ func (self *Model) run_keymanager() {
 				return
 			}
 
-			if !command {
+			ed, _ := self.editor()
+			if ed == nil {
+				if c := self.takeConfirm(); c != "" {
+					if isYes(string(msg.Ch)) {
+						go self.execConfirmed(c)
+					} else {
+						self.view.SetOperandMsg("canceled: %s", c)
+					}
+					continue
+				}
 				if string(msg.Ch) == ":" {
-					self.com_buf = ""
-					command = true
-					self.view.SetOperand(":" + self.com_buf)
+					self.line.Start("")
+					self.showLine()
 					continue
 				}
-			}
-
-			switch msg.Key {
-			case termbox.KeyBackspace2:
-				if len(self.com_buf) < 1 {
+				if msg.Key == termbox.KeyTab {
+					self.setLogFocus(!self.isLogFocus())
 					continue
 				}
-
-				last := len(self.com_buf) - 1
-
-				if last < 1 {
-					self.com_buf = ""
-				} else {
-					self.com_buf = self.com_buf[:last]
+				if self.isLogFocus() {
+					self.logKey(msg)
+					continue
+				}
+				if msg.Key == termbox.KeyEsc && !self.isLayer(nil) {
+					self.showLayer(nil)
+					continue
 				}
-			case termbox.KeyBackspace:
-				if len(self.com_buf) < 1 {
+				if !self.isLayer(nil) {
 					continue
 				}
+				if buf := self.progressKey(msg, com_ch); buf != "" {
+					self.line.Start(buf)
+					self.showLine()
+				}
+				continue
+			}
 
-				last := len(self.com_buf) - 1
-				if last < 1 {
-					self.com_buf = ""
-				} else {
-					self.com_buf = self.com_buf[:last]
+			self.setHint("")
+			switch msg.Key {
+			case termbox.KeyBackspace, termbox.KeyBackspace2:
+				ed.Backspace()
+			case termbox.KeyDelete, termbox.KeyCtrlD:
+				ed.Delete()
+			case termbox.KeyCtrlW:
+				ed.DeleteWord()
+			case termbox.KeyCtrlU:
+				ed.DeleteHead()
+			case termbox.KeyCtrlK:
+				ed.DeleteTail()
+			case termbox.KeyArrowLeft, termbox.KeyCtrlB:
+				ed.Left()
+			case termbox.KeyArrowRight, termbox.KeyCtrlF:
+				ed.Right()
+			case termbox.KeyHome, termbox.KeyCtrlA:
+				ed.Home()
+			case termbox.KeyEnd, termbox.KeyCtrlE:
+				ed.End()
+			case termbox.KeyArrowUp, termbox.KeyCtrlP:
+				ed.Prev()
+			case termbox.KeyArrowDown, termbox.KeyCtrlN:
+				ed.Next()
+			case termbox.KeyTab:
+				if ed == self.line {
+					self.complete()
 				}
 			case termbox.KeyEnter:
-				if len(self.com_buf) < 1 {
+				if ed == self.search {
+					word, _ := ed.Commit()
+					self.view.SetOperand("")
+					if !self.m_log.SetSearch(word) {
+						self.WriteErrLog("not found: %s", word)
+					}
+					self.refresh()
 					continue
 				}
+				if l, _ := ed.Line(); strings.TrimSpace(l) == "" {
+					continue
+				}
+
+				command, err := ed.Commit()
+				self.view.SetOperand("")
+				if err != nil {
+					self.WriteErrLog("cannot save the command history: %s", err)
+				}
 
 				select {
 				case <- self.ctx.Done():
-				case com_ch <- self.com_buf:
+				case com_ch <- command:
 				}
-
-				self.view.SetOperand("")
-				self.com_buf = ""
-				command = false
 				continue
 
 			case termbox.KeyEsc:
+				ed.Stop()
 				self.view.SetOperand("")
-				self.com_buf = ""
-				command = false
 				continue
 
 			case termbox.KeySpace:
-				if !command {
-					continue
-				}
-				self.com_buf += string(" ")
+				ed.Insert(' ')
 			default:
-				if !command {
+				if msg.Ch == 0 {
 					continue
 				}
-				self.com_buf += string(msg.Ch)
+				ed.Insert(msg.Ch)
 			}
 
-			if !command {
-				continue
-			}
+			self.showLine()
+		}
+	}
+}
 
-			self.view.SetOperand(":" + self.com_buf)
+//complete completes the word at the cursor, and shows the candidates
+//when more than one matches.
+func (self *Model) complete() {
+	symbols := self.m_st.Symbols()
+	trs := self.m_pg.Traders()
+	matched := self.line.Complete(func(args []string) []string {
+		return completeArgs(args, trs, symbols)
+	})
+	if len(matched) > 1 {
+		self.setHint(strings.Join(matched, " "))
+	}
+}
+
+func (self *Model) setHint(hint string) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	self.com_hint = hint
+}
+
+//editor returns the line being edited and its prompt, or nil.
+func (self *Model) editor() (*LineEditor, string) {
+	if self.line.Editing() {
+		return self.line, ":"
+	}
+	if self.search.Editing() {
+		return self.search, "/"
+	}
+	return nil, ""
+}
+
+//showLine shows the line being edited with the cursor, and the
+//candidates of the completion after it.
+func (self *Model) showLine() {
+	ed, prompt := self.editor()
+	if ed == nil {
+		return
+	}
+
+	self.mtx.Lock()
+	hint := self.com_hint
+	self.mtx.Unlock()
+
+	line, cursor := ed.Line()
+	if hint != "" {
+		line += "    [" + hint + "]"
+	}
+	self.view.SetCommandLine(prompt + line, cursor + 1)
+}
+
+func (self *Model) isLogFocus() bool {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	return self.log_focus
+}
+
+func (self *Model) setLogFocus(focus bool) {
+	self.mtx.Lock()
+	self.log_focus = focus
+	self.mtx.Unlock()
+
+	self.refresh()
+}
+
+//logKey scrolls and searches the log pane while it is focused.
+func (self *Model) logKey(msg *Message) {
+	page := self.v_log.Rows() - 1
+	if page < 1 {
+		page = 1
+	}
+
+	switch {
+	case msg.Key == termbox.KeyEsc:
+		self.setLogFocus(false)
+		return
+	case msg.Key == termbox.KeyPgup:
+		self.m_log.Scroll(page)
+	case msg.Key == termbox.KeyPgdn:
+		self.m_log.Scroll(-page)
+	case msg.Key == termbox.KeyArrowUp || msg.Ch == 'k':
+		self.m_log.Scroll(1)
+	case msg.Key == termbox.KeyArrowDown || msg.Ch == 'j':
+		self.m_log.Scroll(-1)
+	case msg.Key == termbox.KeyHome || msg.Ch == 'g':
+		self.m_log.ScrollTop()
+	case msg.Key == termbox.KeyEnd || msg.Ch == 'G':
+		self.m_log.ScrollBottom()
+	case msg.Ch == '/':
+		self.search.Start("")
+		self.showLine()
+		return
+	case msg.Ch == 'n' || msg.Ch == 'N':
+		dir := -1
+		if msg.Ch == 'N' {
+			dir = 1
 		}
+		if !self.m_log.NextMatch(dir) {
+			self.WriteErrLog("no more: %s", self.m_log.Search())
+		}
+	case msg.Ch == 'e':
+		f := self.m_log.Filter()
+		f.Errors = !f.Errors
+		self.m_log.SetFilter(f)
+	case msg.Ch == 'c':
+		self.m_log.SetFilter(LogFilter{})
+		self.m_log.SetSearch("")
+	default:
+		return
+	}
+	self.refresh()
+}
+
+//logCommand sets the filter of the log pane.
+//  log errors | log trader <name> | log entry <id> | log clear
+func (self *Model) logCommand(command string) error {
+	usage := fmt.Errorf("USAGE: log errors | log trader <trader name> | log entry <id> | log clear")
+
+	args := strings.Fields(command)
+	if len(args) < 2 {
+		return usage
+	}
+
+	f := self.m_log.Filter()
+	switch {
+	case args[1] == "errors" && len(args) == 2:
+		f.Errors = !f.Errors
+	case args[1] == "trader" && len(args) == 3:
+		f.Trader = args[2]
+	case args[1] == "entry" && len(args) == 3:
+		f.Entry = args[2]
+	case args[1] == "clear" && len(args) == 2:
+		f = LogFilter{}
+		self.m_log.SetSearch("")
+	default:
+		return usage
+	}
+	self.m_log.SetFilter(f)
+	self.refresh()
+	return nil
+}
+
+//logTitle tells the filter, the search word and the lines scrolled back
+//of the log pane.
+func (self *Model) logTitle() string {
+	t := "log"
+	if f := self.m_log.Filter(); !f.Empty() {
+		t += " " + f.String()
+	}
+	if s := self.m_log.Search(); s != "" {
+		t += " /" + s
+	}
+	if n := self.m_log.Offset(); n > 0 {
+		t += fmt.Sprintf(" -%d", n)
+	}
+	if self.isLogFocus() {
+		t += " (PgUp/PgDn: scroll, /: search, e: errors, c: clear, Tab: back)"
 	}
+	return t
 }
 
 func (self *Model) run_operator(com_ch chan string) {
@@ -229,135 +547,176 @@ func (self *Model) run_operator(com_ch chan string) {
 				return
 			}
 
-			c_s := strings.SplitN(command, " ", 5)
-			switch c_s[0] {
+			switch strings.SplitN(command, " ", 2)[0] {
 			case "help":
-				self.view.SetOperandMsg("show https://github.com/vouquet/miniquet2")
-			case "add":
-				if len(c_s) < 2 {
-					self.WriteErrLog("add command error: not set parameter")
-				}
-				if err := self.run_commandHandlerAdd(c_s[1:]); err != nil {
-					self.WriteErrLog("add command error: %s", err)
+				self.view.SetOperandMsg("j/k: select, Enter: detail, s: stop, x: kill9, e: edit, r: revive, Tab: log pane. show https://github.com/vouquet/miniquet2")
+			case "history":
+				self.toggleLayer(self.v_hist)
+			case "archive":
+				self.toggleLayer(self.v_arch)
+			case "kill9":
+				prompt, confirmed, err := kill9Prompt(self.m_pg.Traders(), command)
+				if err != nil {
+					self.WriteErrLog("%s", err)
 					continue
 				}
-			case "stop":
-				if len(c_s) < 2 {
-					self.WriteErrLog("stop command error: not set parameter")
-				}
-				if err := self.run_commandHandlerStop(c_s[1:]); err != nil {
-					self.WriteErrLog("stop command error: %s", err)
+				self.setConfirm(confirmed)
+				self.view.SetOperandMsg("%s", prompt)
+			case "detail":
+				args := strings.Fields(command)
+				if len(args) != 2 {
+					self.WriteErrLog("USAGE: detail <id>")
 					continue
 				}
-			case "kill9":
-				if len(c_s) < 2 {
-					self.WriteErrLog("kill9 command error: not set parameter")
+				self.m_dtl.SetId(args[1])
+				self.m_dtl.Update(self.m_pg.Traders())
+				self.showLayer(self.v_dtl)
+			case "log":
+				if err := self.logCommand(command); err != nil {
+					self.WriteErrLog("%s", err)
 				}
-				if err := self.run_commandHandlerKill9(c_s[1:]); err != nil {
-					self.WriteErrLog("kill9 command error: %s", err)
+			case "stats":
+				stats, err := self.backend.Stats()
+				if err != nil {
+					self.WriteErrLog("%s", err)
 					continue
 				}
+				self.view.SetOperandMsg("%s", statsLine(stats))
 			default:
-				self.WriteErrLog("undefined operation: %s", command)
+				if _, err := self.backend.Exec(command); err != nil {
+					self.WriteErrLog("%s", err)
+				}
 			}
 		}
 	}
 }
 
-func (self *Model) AddTrader(tr *miniquet.Trader) error {
-	return self.m_pg.Add(tr)
-}
+//progressKey moves the cursor of the progress view, or runs a command on
+//the entry at the cursor. It returns a command to complete in the
+//command line, or "".
+func (self *Model) progressKey(msg *Message, com_ch chan string) string {
+	switch {
+	case msg.Key == termbox.KeyArrowUp || msg.Ch == 'k':
+		self.m_pg.MoveCursor(-1)
+		return ""
+	case msg.Key == termbox.KeyArrowDown || msg.Ch == 'j':
+		self.m_pg.MoveCursor(1)
+		return ""
+	}
 
-func (self *Model) RemoveTrader(tr *miniquet.Trader) error {
-	return self.m_pg.Remove(tr)
-}
+	row, ok := self.m_pg.Selected()
+	if !ok || row.Id() == "" {
+		return ""
+	}
 
-func (self *Model) CommandHandlerAdd(f func([]string)error) {
-	self.com_hdlr_add = f
+	var command string
+	switch {
+	case row.Killed != nil:
+		if msg.Ch != 'r' {
+			return ""
+		}
+		command = "revive " + row.Id()
+	case msg.Key == termbox.KeyEnter || msg.Ch == 'd':
+		command = "detail " + row.Id()
+	case msg.Ch == 's':
+		command = "stop " + row.Trader.Name + " " + row.Id()
+	case msg.Ch == 'x':
+		command = "kill9 " + row.Trader.Name + " " + row.Id()
+	case msg.Ch == 'e':
+		return "edit " + row.Trader.Name + " " + row.Id() + " "
+	default:
+		return ""
+	}
+
+	select {
+	case <- self.ctx.Done():
+	case com_ch <- command:
+	}
+	return ""
 }
 
-func (self *Model) CommandHandlerStop(f func([]string)error) {
-	self.com_hdlr_stop = f
+func (self *Model) setConfirm(command string) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	self.confirm = command
 }
 
-func (self *Model) CommandHandlerKill9(f func([]string)error) {
-	self.com_hdlr_kill9 = f
+//takeConfirm returns the command waiting for the answer, and clears it.
+func (self *Model) takeConfirm() string {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	c := self.confirm
+	self.confirm = ""
+	return c
 }
 
-func (self *Model) run_commandHandlerAdd(args []string) error {
-	if self.com_hdlr_add == nil {
-		return fmt.Errorf("run_commandHandlerAdd: function pointer is nil.")
+func (self *Model) execConfirmed(command string) {
+	if _, err := self.backend.Exec(command); err != nil {
+		self.WriteErrLog("%s", err)
+		return
 	}
+	self.view.SetOperandMsg("killed. ':undo' or ':revive <id>' brings it back.")
+}
 
-	if args == nil {
-		return fmt.Errorf("run_commandHandlerAdd: does not have args.")
-	}
-	if len(args) < 1 {
-		return fmt.Errorf("run_commandHandlerAdd: does not have args.")
-	}
+func (self *Model) isLayer(vl ViewLayer) bool {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
 
-	res := self.com_hdlr_add(args)
-	return res
+	return self.layer == vl
 }
 
-func (self *Model) run_commandHandlerStop(args []string) error {
-	if self.com_hdlr_stop == nil {
-		return fmt.Errorf("run_commandHandlerStop: function pointer is nil.")
-	}
-
-	if args == nil {
-		return fmt.Errorf("run_commandHandlerStop: does not have args.")
-	}
-	if len(args) < 1 {
-		return fmt.Errorf("run_commandHandlerStop: does not have args.")
+func (self *Model) toggleLayer(vl ViewLayer) {
+	if self.isLayer(vl) {
+		self.showLayer(nil)
+		return
 	}
-
-	res := self.com_hdlr_stop(args)
-	return res
+	self.showLayer(vl)
 }
 
-func (self *Model) run_commandHandlerKill9(args []string) error {
-	if self.com_hdlr_kill9 == nil {
-		return fmt.Errorf("run_commandHandlerKill9: function pointer is nil.")
+//showLayer shows the layer at the place of the progress view, or the
+//progress view for nil.
+func (self *Model) showLayer(vl ViewLayer) {
+	self.mtx.Lock()
+	if self.layer == vl {
+		self.mtx.Unlock()
+		return
 	}
 
-	if args == nil {
-		return fmt.Errorf("run_commandHandlerKill9: does not have args.")
+	cur := ViewLayer(self.v_pg)
+	if self.layer != nil {
+		cur = self.layer
 	}
-	if len(args) < 1 {
-		return fmt.Errorf("run_commandHandlerKill9: does not have args.")
+	next := ViewLayer(self.v_pg)
+	if vl != nil {
+		next = vl
 	}
+	self.view.SwapViewLayer(cur, next)
+	self.layer = vl
+	self.mtx.Unlock()
 
-	res := self.com_hdlr_kill9(args)
-	return res
+	self.refresh()
 }
 
 func (self *Model) refresh() {
+	self.v_log.SetTitle(self.logTitle())
 	self.view.Resize()
 	self.m_st.Publish()
 	self.m_pg.Publish()
 	self.m_log.Publish()
+	self.m_hist.Publish()
+	self.m_arch.Publish()
+	self.m_dtl.Publish()
 
-	self.view.SetTitle(MiniketName)
-	if len(self.com_buf) < 1 {
-		return
-	}
-	self.view.SetOperand(":" + self.com_buf)
+	self.view.SetTitle(self.title)
+	self.showLine()
 }
 
 func (self *Model) WriteErrLog(s string, msg ...interface{}) {
-	self.m_log.WriteErrLog(s, msg...)
 	self.view.SetOperandErr(s, msg...)
 }
 
-func (self *Model) WriteMsgLog(s string, msg ...interface{}) {
-	self.m_log.WriteMsgLog(s, msg...)
-}
-
-func (self *Model) UpdateStatus(rates map[string]shop.Rate) {
-	self.m_st.UpdateStatus(rates)
-}
-
 func (self *Model) Close() {
 	self.cancel()
 	self.ctlr.Close()
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"sync"
+)
+
+import (
+	"miniquet2/miniquet"
+)
+
+const (
+	ArchiveSize int = 100
+)
+
+//ArchiveModel keeps the last archived entries, the oldest first.
+type ArchiveModel struct {
+	infos        []*miniquet.ArchiveInfo
+
+	view_handler func([]*miniquet.ArchiveInfo)
+
+	mtx *sync.Mutex
+}
+
+func NewArchiveModel() *ArchiveModel {
+	return &ArchiveModel{infos:make([]*miniquet.ArchiveInfo, 0), mtx:new(sync.Mutex)}
+}
+
+func (self *ArchiveModel) ViewHandler(f func([]*miniquet.ArchiveInfo)) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	self.view_handler = f
+}
+
+func (self *ArchiveModel) Publish() {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	self.publish()
+}
+
+func (self *ArchiveModel) Load(infos []*miniquet.ArchiveInfo) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	self.infos = append([]*miniquet.ArchiveInfo{}, infos...)
+	self.publish()
+}
+
+func (self *ArchiveModel) publish() {
+	if self.view_handler == nil {
+		return
+	}
+	self.view_handler(self.infos)
+}
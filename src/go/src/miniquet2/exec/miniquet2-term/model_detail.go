@@ -0,0 +1,82 @@
+package main
+
+import (
+	"sync"
+	"strings"
+)
+
+import (
+	"miniquet2/miniquet"
+)
+
+//DetailModel follows one entry, given by its id or a unique prefix of it.
+type DetailModel struct {
+	id           string
+	entry        *miniquet.EntryInfo
+
+	view_handler func(string, *miniquet.EntryInfo)
+
+	mtx *sync.Mutex
+}
+
+func NewDetailModel() *DetailModel {
+	return &DetailModel{mtx:new(sync.Mutex)}
+}
+
+func (self *DetailModel) ViewHandler(f func(string, *miniquet.EntryInfo)) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	self.view_handler = f
+}
+
+func (self *DetailModel) SetId(id string) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	self.id = id
+	self.entry = nil
+}
+
+func (self *DetailModel) Publish() {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	self.publish()
+}
+
+//Update finds the entry in a snapshot of the backend.
+func (self *DetailModel) Update(trs []*miniquet.TraderInfo) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	self.entry = nil
+	if self.id == "" {
+		return
+	}
+
+	var found *miniquet.EntryInfo
+	for _, tr := range trs {
+		for _, en := range tr.Entries {
+			if en.Id == self.id {
+				self.entry = en
+				return
+			}
+			if !strings.HasPrefix(en.Id, self.id) {
+				continue
+			}
+			if found != nil {
+				return
+			}
+			found = en
+		}
+	}
+	self.entry = found
+}
+
+func (self *DetailModel) publish() {
+	if self.view_handler == nil {
+		return
+	}
+	self.view_handler(self.id, self.entry)
+}
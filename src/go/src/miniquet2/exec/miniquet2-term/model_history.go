@@ -0,0 +1,75 @@
+package main
+
+import (
+	"sync"
+)
+
+import (
+	"miniquet2/miniquet"
+)
+
+const (
+	HistorySize int = 100
+)
+
+type HistoryModel struct {
+	records      []*miniquet.AuditRecord
+
+	limit        int
+	view_handler func([]*miniquet.AuditRecord)
+
+	mtx *sync.Mutex
+}
+
+func NewHistoryModel() *HistoryModel {
+	return &HistoryModel{limit:HistorySize, records:make([]*miniquet.AuditRecord, 0),
+													mtx:new(sync.Mutex)}
+}
+
+func (self *HistoryModel) ViewHandler(f func([]*miniquet.AuditRecord)) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	self.view_handler = f
+}
+
+func (self *HistoryModel) Publish() {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	self.publish()
+}
+
+func (self *HistoryModel) Load(rs []*miniquet.AuditRecord) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	self.records = make([]*miniquet.AuditRecord, 0, len(rs))
+	for _, r := range rs {
+		self.append(r)
+	}
+	self.publish()
+}
+
+func (self *HistoryModel) Append(r *miniquet.AuditRecord) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	self.append(r)
+	self.publish()
+}
+
+func (self *HistoryModel) append(r *miniquet.AuditRecord) {
+	if len(self.records) >= self.limit {
+		sp := len(self.records) - self.limit + 1
+		self.records = self.records[sp:]
+	}
+	self.records = append(self.records, r)
+}
+
+func (self *HistoryModel) publish() {
+	if self.view_handler == nil {
+		return
+	}
+	self.view_handler(self.records)
+}
@@ -4,30 +4,87 @@ import (
 	"fmt"
 	"sync"
 	"time"
+	"strings"
+	"unicode"
+)
+
+import (
+	"miniquet2/miniquet"
 )
 
 const (
+	LogTypeWarn uint8 = 2
 	LogTypeErr uint8 = 1
 	LogTypeMsg uint8 = 0
-	CacheSize  int = 10
 	FmtTime string = "2006-01-02 15:04:05"
+
+	//LogCacheSize is the lines kept to scroll back, and LogPullSize is
+	//the lines pulled each time after the first.
+	LogCacheSize int = 5000
+	LogPullSize  int = 200
 )
 
+//LogFilter selects the lines shown. An empty value does not filter.
+type LogFilter struct {
+	Errors bool
+	Trader string
+	Entry  string
+}
+
+func (self LogFilter) Empty() bool {
+	return !self.Errors && self.Trader == "" && self.Entry == ""
+}
+
+func (self LogFilter) String() string {
+	fs := []string{}
+	if self.Errors {
+		fs = append(fs, "errors")
+	}
+	if self.Trader != "" {
+		fs = append(fs, "trader=" + self.Trader)
+	}
+	if self.Entry != "" {
+		fs = append(fs, "entry=" + self.Entry)
+	}
+	return strings.Join(fs, " ")
+}
+
+//match tells the line is shown. The trader and the entry are matched
+//with the fields in the text, the entry by the head of the id.
+func (self LogFilter) match(lv *LogValue) bool {
+	if self.Errors && lv.Type() != LogTypeErr {
+		return false
+	}
+	if self.Trader != "" && !lv.hasField("trader", self.Trader, false) {
+		return false
+	}
+	if self.Entry != "" && !lv.hasField("entry", self.Entry, true) {
+		return false
+	}
+	return true
+}
+
+//LogModel keeps the last LogCacheSize lines. offset is the lines scrolled
+//back from the last one shown, 0 follows new lines.
 type LogModel struct {
 	cache        []*LogValue
 
 	cache_limit  int
-	view_handler func([]*LogValue)
+	view_handler func([]*LogValue, int, string)
+
+	filter       LogFilter
+	search       string
+	offset       int
 
 	mtx *sync.Mutex
 }
 
 func NewLogModel() *LogModel {
-	return &LogModel{cache_limit:CacheSize, cache:make([]*LogValue, 0),
+	return &LogModel{cache_limit:LogCacheSize, cache:make([]*LogValue, 0),
 													mtx:new(sync.Mutex)}
 }
 
-func (self *LogModel) ViewHandler(f func([]*LogValue)) {
+func (self *LogModel) ViewHandler(f func([]*LogValue, int, string)) {
 	self.mtx.Lock()
 	defer self.mtx.Unlock()
 
@@ -41,54 +98,205 @@ func (self *LogModel) Publish() {
 	self.publish()
 }
 
-func (self *LogModel) call_view_handler(lv []*LogValue) {
+func (self *LogModel) call_view_handler(lv []*LogValue, offset int, search string) {
 	if self.view_handler == nil {
 		return
 	}
-	self.view_handler(lv)
+	self.view_handler(lv, offset, search)
 }
 
-func (self *LogModel) append(lv *LogValue) {
+func (self *LogModel) publish() {
+	self.call_view_handler(self.shown(), self.offset, self.search)
+}
+
+//shown returns the lines passing the filter.
+func (self *LogModel) shown() []*LogValue {
+	if self.filter.Empty() {
+		return self.cache
+	}
+
+	lvs := make([]*LogValue, 0)
+	for _, lv := range self.cache {
+		if self.filter.match(lv) {
+			lvs = append(lvs, lv)
+		}
+	}
+	return lvs
+}
+
+//PullSize returns the lines to pull from the backend. It pulls the whole
+//cache at first, and the new lines after it.
+func (self *LogModel) PullSize() int {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	if len(self.cache) < 1 {
+		return self.cache_limit
+	}
+	return LogPullSize
+}
+
+//Update appends the lines of the backend after the last line kept. The
+//lines scrolled back stay on the place.
+func (self *LogModel) Update(logs []*miniquet.LogInfo) {
 	self.mtx.Lock()
 	defer self.mtx.Unlock()
 
-	if len(self.cache) >= self.cache_limit {
-		sp := len(self.cache) - self.cache_limit
-		self.cache = self.cache[sp:]
+	start := 0
+	if n := len(self.cache); n > 0 {
+		last := self.cache[n - 1].info
+		for i := len(logs) - 1; i >= 0; i-- {
+			if sameLog(logs[i], last) {
+				start = i + 1
+				break
+			}
+		}
 	}
-	self.cache = append(self.cache, lv)
 
+	cache := self.cache
+	for _, l := range logs[start:] {
+		lv := newLogValue(l)
+		cache = append(cache, lv)
+		if self.offset > 0 && self.filter.match(lv) {
+			self.offset++
+		}
+	}
+	if len(cache) > self.cache_limit {
+		cache = cache[len(cache) - self.cache_limit:]
+	}
+	self.cache = cache
+	self.clampOffset(len(self.shown()))
+}
+
+func sameLog(a *miniquet.LogInfo, b *miniquet.LogInfo) bool {
+	return a.Time.Equal(b.Time) && a.Level == b.Level && a.Text == b.Text
+}
+
+//Scroll scrolls back n lines, or forward for a negative n.
+func (self *LogModel) Scroll(n int) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	self.offset += n
+	self.clampOffset(len(self.shown()))
 	self.publish()
 }
 
-func (self *LogModel) publish() {
-	self.call_view_handler(self.cache)
+//ScrollTop scrolls back to the oldest line kept.
+func (self *LogModel) ScrollTop() {
+	self.Scroll(self.cache_limit)
 }
 
-func (self *LogModel) WriteErrLog(s string, msg ...interface{}) {
-	lv := newErrLogValue(s, msg...)
-	self.append(lv)
+//ScrollBottom follows the new lines again.
+func (self *LogModel) ScrollBottom() {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	self.offset = 0
+	self.publish()
 }
 
-func (self *LogModel) WriteMsgLog(s string, msg ...interface{}) {
-	lv := newMsgLogValue(s, msg...)
-	self.append(lv)
+func (self *LogModel) clampOffset(n int) {
+	if self.offset > n - 1 {
+		self.offset = n - 1
+	}
+	if self.offset < 0 {
+		self.offset = 0
+	}
+}
+
+func (self *LogModel) Offset() int {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	return self.offset
+}
+
+func (self *LogModel) Filter() LogFilter {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	return self.filter
+}
+
+//SetFilter shows the lines passing f, from the last one.
+func (self *LogModel) SetFilter(f LogFilter) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	self.filter = f
+	self.offset = 0
+	self.publish()
+}
+
+func (self *LogModel) Search() string {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	return self.search
+}
+
+//SetSearch highlights s, and scrolls to the last line having it. It
+//returns false when no line has s.
+func (self *LogModel) SetSearch(s string) bool {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	self.search = s
+	self.offset = 0
+	if s == "" {
+		self.publish()
+		return true
+	}
+
+	found := self.findMatch(len(self.shown()) - 1, -1)
+	self.publish()
+	return found
+}
+
+//NextMatch scrolls to the line having the search word, older than the
+//last line shown for a negative dir, newer for a positive one.
+func (self *LogModel) NextMatch(dir int) bool {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	if self.search == "" {
+		return false
+	}
+	last := len(self.shown()) - 1 - self.offset
+	found := self.findMatch(last + dir, dir)
+	self.publish()
+	return found
+}
+
+func (self *LogModel) findMatch(from int, dir int) bool {
+	lvs := self.shown()
+	for i := from; i >= 0 && i < len(lvs); i += dir {
+		if len(lvs[i].Matches(self.search)) > 0 {
+			self.offset = len(lvs) - 1 - i
+			return true
+		}
+	}
+	return false
 }
 
 type LogValue struct {
 	t        time.Time
 	log_type uint8
 	log_msg  string
-}
 
-func newMsgLogValue(s string, msg ...interface{}) *LogValue {
-	log_msg := fmt.Sprintf(s, msg...)
-	return &LogValue{t:time.Now(), log_type:LogTypeMsg, log_msg:log_msg}
+	info     *miniquet.LogInfo
 }
 
-func newErrLogValue(s string, msg ...interface{}) *LogValue {
-	log_msg := fmt.Sprintf(s, msg...)
-	return &LogValue{t:time.Now(), log_type:LogTypeErr, log_msg:log_msg}
+func newLogValue(l *miniquet.LogInfo) *LogValue {
+	log_type := LogTypeMsg
+	switch l.Level {
+	case miniquet.LevelError.String():
+		log_type = LogTypeErr
+	case miniquet.LevelWarn.String():
+		log_type = LogTypeWarn
+	}
+	return &LogValue{t:l.Time, log_type:log_type, log_msg:l.Text, info:l}
 }
 
 func (self *LogValue) Type() uint8 {
@@ -99,3 +307,43 @@ func (self *LogValue) Runes() []rune {
 	msg := fmt.Sprintf("[%s] %s", self.t.Format(FmtTime), self.log_msg)
 	return []rune(msg)
 }
+
+//hasField tells the text has key=val, or key=<val...> for prefix.
+func (self *LogValue) hasField(key string, val string, prefix bool) bool {
+	for _, f := range strings.Fields(self.log_msg) {
+		v := strings.TrimPrefix(f, key + "=")
+		if v == f {
+			continue
+		}
+		if v == val || (prefix && strings.HasPrefix(v, val)) {
+			return true
+		}
+	}
+	return false
+}
+
+//Matches returns the indexes of the runes of s in Runes, ignoring the
+//case.
+func (self *LogValue) Matches(s string) []int {
+	if s == "" {
+		return nil
+	}
+	rs := foldRunes(self.Runes())
+	ss := foldRunes([]rune(s))
+
+	idxs := []int{}
+	for i := 0; i + len(ss) <= len(rs); i++ {
+		if string(rs[i:i + len(ss)]) == string(ss) {
+			idxs = append(idxs, i)
+		}
+	}
+	return idxs
+}
+
+func foldRunes(rs []rune) []rune {
+	folded := make([]rune, len(rs))
+	for i, r := range rs {
+		folded[i] = unicode.ToLower(r)
+	}
+	return folded
+}
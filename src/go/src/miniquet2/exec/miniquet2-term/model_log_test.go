@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"time"
+	"testing"
+)
+
+import (
+	"miniquet2/miniquet"
+)
+
+func testLogs() []*miniquet.LogInfo {
+	at := time.Date(2021, 1, 2, 3, 4, 5, 0, time.Local)
+	lines := []struct {
+		level miniquet.Level
+		text  string
+	}{
+		{miniquet.LevelInfo, "added entry trader=alice entry=abc123"},
+		{miniquet.LevelError, "order failed trader=alice entry=abc123"},
+		{miniquet.LevelInfo, "added entry trader=bob entry=def456"},
+		{miniquet.LevelError, "rate failed"},
+		{miniquet.LevelInfo, "Order filled trader=bob entry=def456"},
+	}
+
+	logs := []*miniquet.LogInfo{}
+	for i, l := range lines {
+		logs = append(logs, &miniquet.LogInfo{Time: at.Add(time.Duration(i) * time.Second),
+									Level: l.level.String(), Text: l.text})
+	}
+	return logs
+}
+
+//shownLogs returns the texts of the lines passed to the view, and the
+//offset.
+func shownLogs(m *LogModel) (string, int) {
+	var shown []*LogValue
+	offset := -1
+	m.ViewHandler(func(lvs []*LogValue, o int, _ string) {
+		shown, offset = lvs, o
+	})
+	m.Publish()
+
+	texts := []string{}
+	for _, lv := range shown {
+		texts = append(texts, lv.log_msg)
+	}
+	return fmt.Sprintf("%q", texts), offset
+}
+
+func expectShown(t *testing.T, m *LogModel, want ...string) {
+	t.Helper()
+	got, _ := shownLogs(m)
+	if w := fmt.Sprintf("%q", want); got != w {
+		t.Fatalf("want %s, got %s", w, got)
+	}
+}
+
+func expectOffset(t *testing.T, m *LogModel, want int) {
+	t.Helper()
+	if got := m.Offset(); got != want {
+		t.Fatalf("want offset %d, got %d", want, got)
+	}
+}
+
+func TestLogModelFilter(t *testing.T) {
+	logs := testLogs()
+	m := NewLogModel()
+	m.Update(logs)
+
+	m.SetFilter(LogFilter{Errors: true})
+	expectShown(t, m, logs[1].Text, logs[3].Text)
+
+	m.SetFilter(LogFilter{Trader: "alice"})
+	expectShown(t, m, logs[0].Text, logs[1].Text)
+
+	//a trader name is matched whole, an entry by the head of the id.
+	m.SetFilter(LogFilter{Trader: "al"})
+	expectShown(t, m)
+	m.SetFilter(LogFilter{Entry: "def"})
+	expectShown(t, m, logs[2].Text, logs[4].Text)
+
+	f := LogFilter{Errors: true, Trader: "alice"}
+	m.SetFilter(f)
+	expectShown(t, m, logs[1].Text)
+	if s := f.String(); s != "errors trader=alice" {
+		t.Fatalf("filter is '%s'", s)
+	}
+
+	m.SetFilter(LogFilter{})
+	expectShown(t, m, logs[0].Text, logs[1].Text, logs[2].Text, logs[3].Text, logs[4].Text)
+}
+
+//TestLogModelUpdate checks the lines pulled again are not doubled, and the
+//lines scrolled back stay on the place when new lines come.
+func TestLogModelUpdate(t *testing.T) {
+	logs := testLogs()
+	m := NewLogModel()
+	m.Update(logs[:3])
+	m.SetFilter(LogFilter{Trader: "bob"})
+	expectShown(t, m, logs[2].Text)
+
+	m.SetFilter(LogFilter{})
+	m.Scroll(1)
+	m.Update(logs[1:])
+	expectShown(t, m, logs[0].Text, logs[1].Text, logs[2].Text, logs[3].Text, logs[4].Text)
+	expectOffset(t, m, 3)
+
+	m.ScrollBottom()
+	expectOffset(t, m, 0)
+	m.Scroll(100)
+	expectOffset(t, m, 4)
+}
+
+func TestLogModelSearch(t *testing.T) {
+	logs := testLogs()
+	m := NewLogModel()
+	m.Update(logs)
+
+	//the search ignores the case, and starts from the last line.
+	if !m.SetSearch("ORDER") {
+		t.Fatal("search word is not found.")
+	}
+	expectOffset(t, m, 0)
+	if !m.NextMatch(-1) {
+		t.Fatal("older line is not found.")
+	}
+	expectOffset(t, m, 3)
+	if m.NextMatch(-1) {
+		t.Fatal("found a line older than the first match.")
+	}
+	expectOffset(t, m, 3)
+	if !m.NextMatch(1) {
+		t.Fatal("newer line is not found.")
+	}
+	expectOffset(t, m, 0)
+
+	lv := newLogValue(logs[1])
+	if idxs := lv.Matches("ORDER"); len(idxs) != 1 || idxs[0] != len("[" + FmtTime + "] ") {
+		t.Fatalf("matches are %v", idxs)
+	}
+
+	//the search runs in the filtered lines.
+	m.SetFilter(LogFilter{Errors: true})
+	if !m.SetSearch("order") {
+		t.Fatal("search word is not found in the errors.")
+	}
+	expectOffset(t, m, 1)
+	if m.NextMatch(-1) || m.NextMatch(1) {
+		t.Fatal("found a line out of the filter.")
+	}
+
+	if m.SetSearch("nothing") {
+		t.Fatal("found a missing word.")
+	}
+	if m.Search() != "nothing" {
+		t.Fatalf("search word is '%s'", m.Search())
+	}
+}
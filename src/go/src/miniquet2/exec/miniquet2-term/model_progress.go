@@ -1,7 +1,7 @@
 package main
 
 import (
-	"fmt"
+	"sort"
 	"sync"
 )
 
@@ -9,21 +9,51 @@ import (
 	"miniquet2/miniquet"
 )
 
+//ProgressRow is a line of the progress view. Entry and Killed are nil on
+//the line of the trader, and Killed is set for an entry stopped by kill9.
+type ProgressRow struct {
+	Trader *miniquet.TraderInfo
+	Entry  *miniquet.EntryInfo
+	Killed *miniquet.ArchiveInfo
+}
+
+//Id returns the id of the entry on the line, or "" for a trader.
+func (self *ProgressRow) Id() string {
+	if self.Entry != nil {
+		return self.Entry.Id
+	}
+	if self.Killed != nil {
+		return self.Killed.Id
+	}
+	return ""
+}
+
+func (self *ProgressRow) key() string {
+	return self.Trader.Name + "/" + self.Id()
+}
+
+//ProgressModel keeps the traders as lines, and the cursor on one of
+//them. The cursor follows its line when the lines change.
 type ProgressModel struct {
-	view_handler func(map[string]*miniquet.Trader)
-	traders      map[string]*miniquet.Trader
+	view_handler func([]*ProgressRow, int)
+	traders      []*miniquet.TraderInfo
+
+	rows         []*ProgressRow
+	cursor       int
+	cursor_key   string
 
 	mtx  *sync.Mutex
 }
 
 func NewProgressModel() *ProgressModel {
 	return &ProgressModel{
-		traders:make(map[string]*miniquet.Trader, 0),
+		traders:make([]*miniquet.TraderInfo, 0),
+		rows:make([]*ProgressRow, 0),
 		mtx:new(sync.Mutex),
 	}
 }
 
-func (self *ProgressModel) ViewHandler(f func(map[string]*miniquet.Trader)) {
+func (self *ProgressModel) ViewHandler(f func([]*ProgressRow, int)) {
 	self.mtx.Lock()
 	defer self.mtx.Unlock()
 
@@ -37,39 +67,95 @@ func (self *ProgressModel) Publish() {
 	self.publish()
 }
 
-func (self *ProgressModel) call_view_handler(ts map[string]*miniquet.Trader) {
+func (self *ProgressModel) call_view_handler(rows []*ProgressRow, cursor int) {
 	if self.view_handler == nil {
 		return
 	}
-	self.view_handler(ts)
+	self.view_handler(rows, cursor)
 }
 
 func (self *ProgressModel) publish() {
-	self.call_view_handler(self.traders)
+	self.call_view_handler(self.rows, self.cursor)
+}
+
+//Update replaces the traders shown with a snapshot of the backend.
+func (self *ProgressModel) Update(trs []*miniquet.TraderInfo) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	self.traders = trs
+	self.rows = progressRows(trs)
+
+	for i, row := range self.rows {
+		if row.key() == self.cursor_key {
+			self.cursor = i
+			return
+		}
+	}
+	self.setCursor(self.cursor)
+}
+
+func (self *ProgressModel) Traders() []*miniquet.TraderInfo {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	return self.traders
 }
 
-func (self *ProgressModel) Add(n_tr *miniquet.Trader) error {
+//MoveCursor moves the cursor by n lines, and stops at the first and the
+//last one.
+func (self *ProgressModel) MoveCursor(n int) {
 	self.mtx.Lock()
 	defer self.mtx.Unlock()
 
-	_, ok := self.traders[n_tr.Name()]
-	if ok {
-		return fmt.Errorf("trader(%s) is already exsit.", n_tr.Name())
+	self.setCursor(self.cursor + n)
+	self.publish()
+}
+
+func (self *ProgressModel) setCursor(i int) {
+	if i >= len(self.rows) {
+		i = len(self.rows) - 1
+	}
+	if i < 0 {
+		i = 0
 	}
+	self.cursor = i
 
-	self.traders[n_tr.Name()] = n_tr
-	return nil
+	self.cursor_key = ""
+	if i < len(self.rows) {
+		self.cursor_key = self.rows[i].key()
+	}
 }
 
-func (self *ProgressModel) Remove(r_tr *miniquet.Trader) error {
+//Selected returns the line at the cursor.
+func (self *ProgressModel) Selected() (*ProgressRow, bool) {
 	self.mtx.Lock()
 	defer self.mtx.Unlock()
 
-	_, ok := self.traders[r_tr.Name()]
-	if !ok {
-		return fmt.Errorf("trader(%s) does not exsit.", r_tr.Name())
+	if self.cursor >= len(self.rows) {
+		return nil, false
 	}
+	return self.rows[self.cursor], true
+}
 
-	delete(self.traders, r_tr.Name())
-	return nil
+//progressRows lists the traders by the name, each followed by its
+//entries and the killed ones.
+func progressRows(trs []*miniquet.TraderInfo) []*ProgressRow {
+	trs = append([]*miniquet.TraderInfo{}, trs...)
+	sort.SliceStable(trs, func(i, j int) bool { return trs[i].Name < trs[j].Name })
+
+	rows := []*ProgressRow{}
+	for _, tr := range trs {
+		rows = append(rows, &ProgressRow{Trader: tr})
+
+		ens := append([]*miniquet.EntryInfo{}, tr.Entries...)
+		sort.SliceStable(ens, func(i, j int) bool { return ens[i].Id < ens[j].Id })
+		for _, en := range ens {
+			rows = append(rows, &ProgressRow{Trader: tr, Entry: en})
+		}
+		for _, k := range tr.Killed {
+			rows = append(rows, &ProgressRow{Trader: tr, Killed: k})
+		}
+	}
+	return rows
 }
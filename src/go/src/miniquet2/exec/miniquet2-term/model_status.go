@@ -1,13 +1,14 @@
 package main
 
 import (
+	"sort"
 	"sync"
 	"time"
 	"strings"
 )
 
 import (
-	"github.com/vouquet/shop"
+	"miniquet2/miniquet"
 )
 
 type StatusModel struct {
@@ -47,7 +48,7 @@ func (self *StatusModel) Publish() {
 	self.call_view_handler(self.before)
 }
 
-func (self *StatusModel) UpdateStatus(rds map[string]shop.Rate) {
+func (self *StatusModel) UpdateStatus(rds []*miniquet.RateInfo) {
 	self.mtx.Lock()
 	defer self.mtx.Unlock()
 
@@ -61,24 +62,24 @@ func (self *StatusModel) UpdateStatus(rds map[string]shop.Rate) {
 
 	rates := make(map[string]*Rate)
 	for _, rd := range rds {
-		if strings.Contains(rd.Symbol(), "_JPY") {
+		if strings.Contains(rd.Symbol, "_JPY") {
 			continue
 		}
 
-		r, ok := b_rs[rd.Symbol()]
+		r, ok := b_rs[rd.Symbol]
 		if !ok {
 			rate, err := NewRate(rd, nil)
 			if err != nil {
 				continue
 			}
-			rates[rd.Symbol()] = rate
+			rates[rd.Symbol] = rate
 			continue
 		}
 		rate, err := NewRate(rd, r)
 		if err != nil {
 			continue
 		}
-		rates[rd.Symbol()] = rate
+		rates[rd.Symbol] = rate
 	}
 
 	n_sv := &StatusValue{
@@ -124,10 +125,10 @@ type Rate struct {
 	avg_month float64
 }
 
-func NewRate(r shop.Rate, before *Rate) (*Rate, error) {
+func NewRate(r *miniquet.RateInfo, before *Rate) (*Rate, error) {
 	ask_down := false
 	ask_up := false
-	ask := r.Ask()
+	ask := r.Ask
 	if before != nil {
 		if before.Ask() != ask {
 			if before.Ask() < ask {
@@ -140,7 +141,7 @@ func NewRate(r shop.Rate, before *Rate) (*Rate, error) {
 
 	bid_down := false
 	bid_up := false
-	bid := r.Bid()
+	bid := r.Bid
 	if before != nil {
 		if before.Bid() != bid {
 			if before.Bid() < bid {
@@ -152,7 +153,7 @@ func NewRate(r shop.Rate, before *Rate) (*Rate, error) {
 	}
 
 	return &Rate{
-		symbol: r.Symbol(),
+		symbol: r.Symbol,
 		ask: ask,
 		ask_up: ask_up,
 		ask_down: ask_down,
@@ -201,3 +202,19 @@ func (self *Rate) AvgWeek() float64 {
 func (self *Rate) AvgMonth() float64 {
 	return self.avg_month
 }
+
+//Symbols returns the symbols of the rates shown, in the order of the name.
+func (self *StatusModel) Symbols() []string {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	symbols := make([]string, 0)
+	if self.before == nil {
+		return symbols
+	}
+	for symbol, _ := range self.before.Rates() {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	return symbols
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+)
+
+import (
+	"miniquet2/miniquet"
+)
+
+//Reload re-reads the config file and applies what can change while
+//trading: trader definitions, their limits, notifier sinks, the tick
+//interval, the backup schedule and the kill grace period. Changes which
+//need a restart are rejected and logged, and the rest is still applied.
+//A rejected change is left out of the running config, so that the next
+//reload sees it again.
+func (self *Miniket2) Reload() error {
+	self.reload_mtx.Lock()
+	defer self.reload_mtx.Unlock()
+
+	opt := *miniquet.DefaultConfigOpt
+	opt.Passphrase = func(string) ([]byte, error) {
+		if Passphrase == nil {
+			return nil, fmt.Errorf("passphrase was not given at startup.")
+		}
+		return Passphrase, nil
+	}
+
+	conf, err := miniquet.LoadConfigWithOpt(ConfPath, &opt)
+	if err != nil {
+		return fmt.Errorf("reload rejected, config has errors: %s", err)
+	}
+	for _, w := range conf.Warnings() {
+		self.log.WriteErrLog("reload: %s", w)
+	}
+
+	cur := self.config()
+	//applied is the running config. Only the changes really applied are
+	//copied into it, so that a rejected change is seen again next time.
+	applied := *cur
+
+	rejected := 0
+	reject := func(s string, msg ...interface{}) {
+		rejected++
+		self.log.WriteErrLog("reload rejected: " + s, msg...)
+	}
+
+	if conf.ApiKey != cur.ApiKey || conf.SecretKey != cur.SecretKey ||
+										conf.SecretFile != cur.SecretFile {
+		reject("api keys cannot be changed live, restart to apply.")
+	}
+
+	n_log := conf.Log
+	n_log.Level = cur.Log.Level
+	if n_log != cur.Log {
+		reject("log file and its rotation cannot be changed live, restart to apply.")
+	}
+	if conf.Metrics.Listen != cur.Metrics.Listen {
+		reject("metrics listener cannot be changed live, restart to apply.")
+	}
+	if conf.Api != cur.Api {
+		reject("api cannot be changed live, restart to apply.")
+	}
+	level, _ := miniquet.ParseLevel(conf.Log.Level)
+	self.log.SetLevel(level)
+	applied.Log.Level = conf.Log.Level
+
+	applied.Trader = self.reloadTraders(cur.Trader, conf.Trader, reject)
+
+	if nt, err := self.buildNotifier(conf.Notifier); err != nil {
+		reject("notifier: %s", err)
+	} else {
+		old := self.setNotifier(nt)
+		old.Close()
+		applied.Notifier = conf.Notifier
+	}
+
+	if conf.Tick() != cur.Tick() {
+		select {
+		case self.tick_ch <- conf.Tick():
+			applied.TickInterval = conf.TickInterval
+		default:
+			reject("tick interval is being changed, try again.")
+		}
+	}
+
+	if conf.Backup != cur.Backup {
+		select {
+		case self.backup_ch <- conf.Backup:
+			applied.Backup = conf.Backup
+		default:
+			reject("backup schedule is being changed, try again.")
+		}
+	}
+
+	self.setKillGrace(conf.Grace())
+	applied.KillGrace = conf.KillGrace
+
+	self.setConfig(&applied)
+	if rejected > 0 {
+		return fmt.Errorf("reloaded %s with %d rejected changes.", ConfPath, rejected)
+	}
+	self.log.WriteMsgLog("reloaded %s", ConfPath)
+	return nil
+}
+
+//config returns the running config. Reload replaces it, so a caller
+//keeps the one returned rather than reading it twice.
+func (self *Miniket2) config() *miniquet.Config {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	return self.conf
+}
+
+func (self *Miniket2) setConfig(conf *miniquet.Config) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	self.conf = conf
+}
+
+//reloadTraders applies the trader definitions confs over olds, and returns
+//the definitions running after it. A rejected change keeps the old
+//definition of the trader.
+func (self *Miniket2) reloadTraders(olds []miniquet.TraderConfig, confs []miniquet.TraderConfig,
+						reject func(string, ...interface{})) []miniquet.TraderConfig {
+	if len(olds) < 1 {
+		olds = DefaultTraders
+	}
+	if len(confs) < 1 {
+		confs = DefaultTraders
+	}
+
+	old_by := make(map[string]miniquet.TraderConfig)
+	for _, c := range olds {
+		old_by[c.Name] = c
+	}
+	keep_old := func(name string) []miniquet.TraderConfig {
+		if c, ok := old_by[name]; ok {
+			return []miniquet.TraderConfig{c}
+		}
+		return nil
+	}
+
+	applied := []miniquet.TraderConfig{}
+	defined := make(map[string]bool)
+	for i := range confs {
+		c := &confs[i]
+		defined[c.Name] = true
+
+		tr, ok := self.trader(c.Name)
+		if ok {
+			if err := tr.Reconfigure(c); err != nil {
+				reject("trader '%s': %s", c.Name, err)
+				applied = append(applied, keep_old(c.Name)...)
+				continue
+			}
+			applied = append(applied, *c)
+			continue
+		}
+
+		tr, err := miniquet.NewTraderFromConfig(c, self.shop, self.st)
+		if err != nil {
+			reject("trader '%s': %s", c.Name, err)
+			continue
+		}
+		if err := self.addTrader(tr); err != nil {
+			reject("trader '%s': %s", c.Name, err)
+			continue
+		}
+		if err := self.loadArchive(tr); err != nil {
+			self.log.WriteErrLog("reload: trader '%s': %s", c.Name, err)
+		}
+		self.log.WriteMsgLog("reload: added trader '%s'", c.Name)
+		applied = append(applied, *c)
+	}
+
+	for _, tr := range self.traders() {
+		if defined[tr.Name()] {
+			continue
+		}
+		if n := len(tr.Entries()) + len(tr.Killed()); n > 0 {
+			reject("trader '%s' still has %d entries, stop them before removing it.", tr.Name(), n)
+			applied = append(applied, keep_old(tr.Name())...)
+			continue
+		}
+		if err := self.removeTrader(tr); err != nil {
+			reject("trader '%s': %s", tr.Name(), err)
+			applied = append(applied, keep_old(tr.Name())...)
+			continue
+		}
+		self.log.WriteMsgLog("reload: removed trader '%s'", tr.Name())
+	}
+	return applied
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"github.com/vouquet/brain"
+)
+
+import (
+	"miniquet2/miniquet"
+)
+
+var (
+	DefaultTraders []miniquet.TraderConfig = []miniquet.TraderConfig{
+		miniquet.TraderConfig{
+			Name: "alice",
+			Description: "Trade with a difference of 0.2 point.",
+			Strategy: "alice",
+		},
+		miniquet.TraderConfig{
+			Name: "john",
+			Description: "Trade with a difference of 1 point.",
+			Strategy: "john",
+		},
+	}
+)
+
+//registerStrategies must run before loading a config, which validates
+//the strategy of each trader.
+func registerStrategies() {
+	miniquet.RegisterStrategy("alice", brainStrategy(brain.Alice))
+	miniquet.RegisterStrategy("john", brainStrategy(brain.John))
+}
+
+//brainStrategy wraps a check function of vouquet/brain. They have fixed
+//thresholds, so Params is ignored.
+func brainStrategy(f func(*miniquet.Entry, float64, float64) bool) miniquet.StrategyFactory {
+	return func(map[string]float64) (miniquet.CheckFunc, error) {
+		return f, nil
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"flag"
+	"strings"
+)
+
+import (
+	"miniquet2/miniquet"
+)
+
+func runSubcommand(name string, args []string) error {
+	switch name {
+	case "encrypt-config":
+		return encryptConfig(args)
+	case "history":
+		return history(args)
+	case "list":
+		return listEntries(args)
+	case "show":
+		return showEntry(args)
+	case "export":
+		return exportEntries(args)
+	case "import":
+		return importEntries(args)
+	case "delete":
+		return deleteEntry(args)
+	case "edit":
+		return editEntry(args)
+	case "archive":
+		return archiveCommand(args)
+	case "ledger":
+		return ledgerCommand(args)
+	case "backup":
+		return backupCommand(args)
+	case "compact":
+		return compactCommand(args)
+	case "stats":
+		return statsCommand(args)
+	case "check":
+		return checkCommand(args)
+	case "recover":
+		return recoverCommand(args)
+	}
+	return fmt.Errorf("unknown subcommand.")
+}
+
+func encryptConfig(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("USAGE: miniquet2-term [-c <config path>] encrypt-config")
+	}
+
+	pass, err := miniquet.ReadNewPassphrase("new passphrase: ")
+	if err != nil {
+		return err
+	}
+
+	s_path, err := miniquet.EncryptConfig(ConfPath, pass)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("moved the secrets of %s into %s\n", ConfPath, s_path)
+	return nil
+}
+
+//history prints the audit log, or the records which changed the entry.
+func history(args []string) error {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	a_path := fs.String("f", DefaultAuditPath, "audit log path.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		return fmt.Errorf("USAGE: miniquet2-term history [-f <audit log path>] [<entry id>]")
+	}
+	id := fs.Arg(0)
+
+	rs, skipped, err := miniquet.ReadAuditLog(*a_path, 0)
+	if err != nil {
+		return err
+	}
+	for _, e := range skipped {
+		fmt.Fprintf(os.Stderr, "skipped %s\n", e)
+	}
+	for _, r := range rs {
+		if id != "" && !hasEntry(r, id) {
+			continue
+		}
+		fmt.Println(r)
+	}
+	return nil
+}
+
+func hasEntry(r *miniquet.AuditRecord, id string) bool {
+	for _, e := range r.Entries {
+		if strings.HasPrefix(e, id) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"flag"
+	"sort"
+	"text/tabwriter"
+)
+
+import (
+	"miniquet2/miniquet"
+)
+
+//archive [-trader <name>] [-reason closed|killed] [-n <count>]
+func archiveCommand(args []string) error {
+	fs := flag.NewFlagSet("archive", flag.ContinueOnError)
+	t_name := fs.String("trader", "", "show archived entries of the trader.")
+	reason := fs.String("reason", "", "show archived entries of the reason, closed or killed.")
+	n := fs.Int("n", 0, "show the last n archived entries. all if not set.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("USAGE: miniquet2-term [-r <record storage path>] archive [-trader <name>] [-reason closed|killed] [-n <count>]")
+	}
+
+	st, err := openStorage(true)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	rs, err := st.Archived(0)
+	if err != nil {
+		return err
+	}
+	selected := []*miniquet.ArchiveRecord{}
+	for _, r := range rs {
+		if *t_name != "" && r.Entry.Trader != *t_name {
+			continue
+		}
+		if *reason != "" && r.Reason != *reason {
+			continue
+		}
+		selected = append(selected, r)
+	}
+	if *n > 0 && len(selected) > *n {
+		selected = selected[len(selected) - *n:]
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CLOSED AT\tREASON\tID\tTRADER\tSYMBOL\tSIZE\tWIN")
+	for _, r := range selected {
+		e := r.Entry
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%.5f\t%.3f\n", r.ClosedAt.Format(FmtTime), r.Reason,
+							e.Id(), e.Trader, e.Symbol, e.Size, r.Win)
+	}
+	return w.Flush()
+}
+
+//ledgerRow is the win of a trader, of the open entries and the archived
+//ones.
+type ledgerRow struct {
+	trader   string
+	open     int
+	open_win float64
+	closed   int
+	killed   int
+	arch_win float64
+}
+
+func (self *ledgerRow) win() float64 {
+	return self.open_win + self.arch_win
+}
+
+//ledger [-trader <name>]
+func ledgerCommand(args []string) error {
+	fs := flag.NewFlagSet("ledger", flag.ContinueOnError)
+	t_name := fs.String("trader", "", "show the ledger of the trader.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("USAGE: miniquet2-term [-r <record storage path>] ledger [-trader <name>]")
+	}
+
+	st, err := openStorage(true)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	rows := make(map[string]*ledgerRow)
+	row := func(trader string) *ledgerRow {
+		r, ok := rows[trader]
+		if !ok {
+			r = &ledgerRow{trader: trader}
+			rows[trader] = r
+		}
+		return r
+	}
+
+	err = st.ForEach(func(e *miniquet.Entry) error {
+		if *t_name != "" && e.Trader != *t_name {
+			return nil
+		}
+		r := row(e.Trader)
+		r.open++
+		r.open_win += e.Win
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	//the killed entries which can still be revived are counted as well.
+	archived := func(a *miniquet.ArchiveRecord) error {
+		if *t_name != "" && a.Entry.Trader != *t_name {
+			return nil
+		}
+		r := row(a.Entry.Trader)
+		if a.Reason == miniquet.ARCHIVE_KILLED {
+			r.killed++
+		} else {
+			r.closed++
+		}
+		r.arch_win += a.Win
+		return nil
+	}
+	if err := st.ForEachArchive(archived); err != nil {
+		return err
+	}
+	if err := st.ForEachKilled(archived); err != nil {
+		return err
+	}
+
+	names := []string{}
+	for name, _ := range rows {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	total := &ledgerRow{trader: "total"}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TRADER\tOPEN\tOPEN WIN\tCLOSED\tKILLED\tARCHIVED WIN\tWIN")
+	for _, name := range names {
+		r := rows[name]
+		printLedgerRow(w, r)
+
+		total.open += r.open
+		total.open_win += r.open_win
+		total.closed += r.closed
+		total.killed += r.killed
+		total.arch_win += r.arch_win
+	}
+	printLedgerRow(w, total)
+	return w.Flush()
+}
+
+func printLedgerRow(w *tabwriter.Writer, r *ledgerRow) {
+	fmt.Fprintf(w, "%s\t%d\t%.3f\t%d\t%d\t%.3f\t%.3f\n", r.trader, r.open, r.open_win,
+								r.closed, r.killed, r.arch_win, r.win())
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"flag"
+	"sort"
+	"time"
+	"strings"
+	"path/filepath"
+	"text/tabwriter"
+)
+
+import (
+	"miniquet2/miniquet"
+)
+
+//openStorage opens StoragePath for a subcommand. It fails while a running
+//miniquet2-term holds the storage.
+func openStorage(read_only bool) (*miniquet.Storage, error) {
+	st, err := miniquet.OpenStorageWith(StorageType, StoragePath,
+				&miniquet.StorageOpt{ReadOnly: read_only, ErrorIfMissing: true})
+	return st, recoverHint(err)
+}
+
+//isNewStorage is true when import should create StoragePath.
+func isNewStorage() bool {
+	_, err := os.Stat(StoragePath)
+	return os.IsNotExist(err)
+}
+
+//backupStorage copies the storage next to it before it is changed.
+func backupStorage(st *miniquet.Storage) (string, error) {
+	path := miniquet.BackupPath(filepath.Dir(filepath.Clean(StoragePath)), StoragePath,
+									miniquet.BACKUP_MANUAL, time.Now())
+	if err := st.Backup(path); err != nil {
+		return "", fmt.Errorf("cannot backup the storage: %s", err)
+	}
+	return path, nil
+}
+
+//list [-trader <name>] [-symbol <symbol>] [-position BUY|SELL] [-stopping]
+func listEntries(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	t_name := fs.String("trader", "", "show entries of the trader.")
+	symbol := fs.String("symbol", "", "show entries of the symbol.")
+	position := fs.String("position", "", "show entries of the position, BUY or SELL.")
+	stopping := fs.Bool("stopping", false, "show entries which will stop after the next order.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("USAGE: miniquet2-term [-r <record storage path>] list [-trader <name>] [-symbol <symbol>] [-position BUY|SELL] [-stopping]")
+	}
+
+	st, err := openStorage(true)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	es := []*miniquet.Entry{}
+	collect := func(e *miniquet.Entry) error {
+		es = append(es, e)
+		return nil
+	}
+	switch {
+	case *t_name != "":
+		err = st.ForEachByTrader(*t_name, collect)
+	case *symbol != "":
+		err = st.ForEachBySymbol(*symbol, collect)
+	default:
+		err = st.ForEach(collect)
+	}
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(es, func(i, j int) bool {
+		if es[i].Trader != es[j].Trader {
+			return es[i].Trader < es[j].Trader
+		}
+		return es[i].Id() < es[j].Id()
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTRADER\tSYMBOL\tPOSITION\tSIZE\tWIN\tLAST RATE\tLAST DATE\tSTOPPING")
+	for _, e := range es {
+		if *t_name != "" && e.Trader != *t_name {
+			continue
+		}
+		if *symbol != "" && e.Symbol != *symbol {
+			continue
+		}
+		if *position != "" && !strings.EqualFold(e.Position, *position) {
+			continue
+		}
+		if *stopping && !e.IsLastone() {
+			continue
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%.5f\t%.3f\t%.3f\t%s\t%v\n", e.Id(), e.Trader, e.Symbol,
+			e.Position, e.Size, e.Win, e.LastRate(), e.LastDate().Format(FmtTime), e.IsLastone())
+	}
+	return w.Flush()
+}
+
+//show <id>
+func showEntry(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("USAGE: miniquet2-term [-r <record storage path>] show <id>")
+	}
+
+	st, err := openStorage(true)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	e, err := getEntry(st, args[0])
+	if err != nil {
+		return err
+	}
+	printEntry(e)
+	return nil
+}
+
+//export [-format json|csv] [-o <path>]
+func exportEntries(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	o_path := fs.String("o", "", "output path. stdout if not set.")
+	f_name := fs.String("format", "", "json or csv. guessed from the output path if not set.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("USAGE: miniquet2-term [-r <record storage path>] export [-format json|csv] [-o <path>]")
+	}
+	format, err := miniquet.ExportFormat(*f_name, *o_path)
+	if err != nil {
+		return err
+	}
+
+	st, err := openStorage(true)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	es, err := st.Walk()
+	if err != nil {
+		return err
+	}
+
+	w := os.Stdout
+	if *o_path != "" {
+		f, err := os.OpenFile(*o_path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	return miniquet.WriteEntries(w, format, es)
+}
+
+//import [-format json|csv] [-dry-run] <path>
+func importEntries(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	f_name := fs.String("format", "", "json or csv. guessed from the path if not set.")
+	dry_run := fs.Bool("dry-run", false, "show what would be imported.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("USAGE: miniquet2-term [-r <record storage path>] import [-format json|csv] [-dry-run] <path>")
+	}
+	format, err := miniquet.ExportFormat(*f_name, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	es, err := miniquet.ReadEntries(f, format)
+	if err != nil {
+		return fmt.Errorf("%s: %s", fs.Arg(0), err)
+	}
+
+	for _, e := range es {
+		fmt.Printf("import %s %s %s %s %.5f\n", e.Id(), e.Trader, e.Symbol, e.Position, e.Size)
+	}
+	if isNewStorage() {
+		if *dry_run {
+			fmt.Printf("dry run, %s will be created with %d entries.\n", StoragePath, len(es))
+			return nil
+		}
+		st, err := miniquet.OpenStorageWith(StorageType, StoragePath, &miniquet.StorageOpt{ErrorIfExist: true})
+		if err != nil {
+			return err
+		}
+		defer st.Close()
+		return putEntries(st, es)
+	}
+
+	st, err := openStorage(*dry_run)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	for _, e := range es {
+		if _, err := st.Get(e.Id()); err == nil {
+			return fmt.Errorf("entry '%s' already exists.", e.Id())
+		} else if err != miniquet.ErrNotFound {
+			return err
+		}
+	}
+
+	if *dry_run {
+		fmt.Printf("dry run, %d entries are not imported.\n", len(es))
+		return nil
+	}
+
+	b_path, err := backupStorage(st)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("backup: %s\n", b_path)
+
+	return putEntries(st, es)
+}
+
+func putEntries(st *miniquet.Storage, es []*miniquet.Entry) error {
+	for _, e := range es {
+		if err := st.Put(e); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("imported %d entries.\n", len(es))
+	return nil
+}
+
+//delete [-dry-run] <id>
+func deleteEntry(args []string) error {
+	fs := flag.NewFlagSet("delete", flag.ContinueOnError)
+	dry_run := fs.Bool("dry-run", false, "show the entry which would be deleted.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("USAGE: miniquet2-term [-r <record storage path>] delete [-dry-run] <id>")
+	}
+
+	st, err := openStorage(*dry_run)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	e, err := getEntry(st, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	printEntry(e)
+	if *dry_run {
+		fmt.Println("dry run, the entry is not deleted.")
+		return nil
+	}
+
+	b_path, err := backupStorage(st)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("backup: %s\n", b_path)
+
+	if err := st.Delete(e); err != nil {
+		return err
+	}
+	fmt.Printf("deleted %s\n", e.Id())
+	return nil
+}
+
+//edit [-size <size>] [-position BUY|SELL] [-rate <last rate>] [-dry-run] <id>
+func editEntry(args []string) error {
+	fs := flag.NewFlagSet("edit", flag.ContinueOnError)
+	size := fs.String("size", "", "new size.")
+	position := fs.String("position", "", "new position, BUY or SELL.")
+	rate := fs.String("rate", "", "new last rate.")
+	dry_run := fs.Bool("dry-run", false, "show the entry after the edit without saving it.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || (*size == "" && *position == "" && *rate == "") {
+		return fmt.Errorf("USAGE: miniquet2-term [-r <record storage path>] edit [-size <size>] [-position BUY|SELL] [-rate <last rate>] [-dry-run] <id>")
+	}
+
+	st, err := openStorage(*dry_run)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	e, err := getEntry(st, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	before := *e
+
+	ed := &entryEdit{size: *size, position: *position, rate: *rate}
+	if err := ed.apply(e); err != nil {
+		return err
+	}
+
+	fmt.Println("before:")
+	printEntry(&before)
+	fmt.Println("after:")
+	printEntry(e)
+	if *dry_run {
+		fmt.Println("dry run, the entry is not saved.")
+		return nil
+	}
+
+	b_path, err := backupStorage(st)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("backup: %s\n", b_path)
+
+	return st.Put(e)
+}
+
+//getEntry returns the entry whose id is or starts with id.
+func getEntry(st *miniquet.Storage, id string) (*miniquet.Entry, error) {
+	full, err := st.FindId(id)
+	if err != nil {
+		return nil, fmt.Errorf("entry '%s': %s", id, err)
+	}
+	e, err := st.Get(full)
+	if err == miniquet.ErrNotFound {
+		return nil, fmt.Errorf("entry '%s' is not found.", id)
+	}
+	return e, err
+}
+
+func printEntry(e *miniquet.Entry) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "  Id\t%s\n", e.Id())
+	fmt.Fprintf(w, "  Trader\t%s\n", e.Trader)
+	fmt.Fprintf(w, "  Symbol\t%s\n", e.Symbol)
+	fmt.Fprintf(w, "  Position\t%s\n", e.Position)
+	fmt.Fprintf(w, "  Size\t%.5f\n", e.Size)
+	fmt.Fprintf(w, "  Win\t%.3f\n", e.Win)
+	fmt.Fprintf(w, "  LastRate\t%.3f\n", e.LastRate())
+	fmt.Fprintf(w, "  LastDate\t%s\n", e.LastDate().Format(FmtTime))
+	fmt.Fprintf(w, "  Stopping\t%v\n", e.IsLastone())
+	for _, st := range miniquet.NewStateInfos(e.State) {
+		keep := ""
+		if st.Keep {
+			keep = " (keep)"
+		}
+		fmt.Fprintf(w, "  State %s\t%s %s%s\n", st.Key, st.Type, st.Value, keep)
+	}
+	w.Flush()
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"io"
+	"os"
+	"fmt"
+	"flag"
+	"time"
+	"path/filepath"
+	"text/tabwriter"
+)
+
+import (
+	"miniquet2/miniquet"
+)
+
+//daemonClient returns a client of the running miniquet2-term which holds
+//the storage. It needs [Api] of the config.
+func daemonClient(cause error) (*miniquet.ApiClient, error) {
+	opt := *miniquet.DefaultConfigOpt
+	opt.SkipSecret = true
+	opt.SkipStrategy = true
+	cfg, err := miniquet.LoadConfigWithOpt(ConfPath, &opt)
+	if err != nil {
+		return nil, fmt.Errorf("%s\ncannot load a config to ask the running one: %s", cause, err)
+	}
+	if cfg.Api.Listen == "" {
+		return nil, fmt.Errorf("%s\nset [Api] in %s to run it while trading.", cause, ConfPath)
+	}
+
+	token, err := cfg.Api.LoadToken()
+	if err != nil {
+		return nil, err
+	}
+	return miniquet.NewApiClient(cfg.Api.Listen, token), nil
+}
+
+func isLocked(err error) bool {
+	_, ok := err.(*miniquet.StorageLockedError)
+	return ok
+}
+
+//backup [<path>]
+//While trading, the running miniquet2-term takes the backup through the
+//control API.
+func backupCommand(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("USAGE: miniquet2-term [-r <record storage path>] backup [<path>]")
+	}
+
+	var path string
+	if len(args) == 1 {
+		abs, err := filepath.Abs(args[0])
+		if err != nil {
+			return err
+		}
+		path = abs
+	}
+
+	st, err := openStorage(true)
+	if isLocked(err) {
+		cl, c_err := daemonClient(err)
+		if c_err != nil {
+			return c_err
+		}
+		command := "backup"
+		if path != "" {
+			command += " " + path
+		}
+		if _, err := cl.Exec(command); err != nil {
+			return err
+		}
+		fmt.Printf("backed up by the running miniquet2-term at %s, see its log.\n", cl.Addr())
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	if path == "" {
+		path = miniquet.BackupPath(filepath.Dir(filepath.Clean(StoragePath)), StoragePath,
+									miniquet.BACKUP_MANUAL, time.Now())
+	}
+	if err := st.Backup(path); err != nil {
+		return err
+	}
+	fmt.Printf("backup: %s\n", path)
+	return nil
+}
+
+//compact
+func compactCommand(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("USAGE: miniquet2-term [-r <record storage path>] compact")
+	}
+
+	st, err := openStorage(false)
+	if isLocked(err) {
+		cl, c_err := daemonClient(err)
+		if c_err != nil {
+			return c_err
+		}
+		if _, err := cl.Exec("compact"); err != nil {
+			return err
+		}
+		fmt.Printf("compacted by the running miniquet2-term at %s, see its log.\n", cl.Addr())
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	before, err := st.Stats()
+	if err != nil {
+		return err
+	}
+	if err := st.Compact(); err != nil {
+		return err
+	}
+	after, err := st.Stats()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("compacted %s: %s -> %s\n", StoragePath,
+				miniquet.FormatBytes(before.Size), miniquet.FormatBytes(after.Size))
+	return nil
+}
+
+//stats
+func statsCommand(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("USAGE: miniquet2-term [-r <record storage path>] stats")
+	}
+
+	var stats *miniquet.StorageStats
+	st, err := openStorage(true)
+	if isLocked(err) {
+		cl, c_err := daemonClient(err)
+		if c_err != nil {
+			return c_err
+		}
+		stats, err = cl.Stats()
+	} else if err == nil {
+		stats, err = st.Stats()
+		st.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tKEYS\tBYTES")
+	for _, ns := range stats.Namespaces {
+		fmt.Fprintf(w, "%s\t%d\t%s\n", ns.Name, ns.Keys, miniquet.FormatBytes(ns.Bytes))
+	}
+	fmt.Fprintf(w, "total\t%d\t\n", stats.Keys())
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("%s on disk\n", miniquet.FormatBytes(stats.Size))
+	return nil
+}
+
+//recoverHint tells how to repair a corrupted storage.
+func recoverHint(err error) error {
+	if _, ok := err.(*miniquet.StorageCorruptedError); ok {
+		return fmt.Errorf("%s\nrun 'miniquet2-term -storage %s -r %s recover' to repair it.",
+									err, StorageType, StoragePath)
+	}
+	return err
+}
+
+//check [-fix]
+func checkCommand(args []string) error {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	fix := fs.Bool("fix", false, "quarantine the broken entries and repair the indexes, after a backup.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("USAGE: miniquet2-term [-r <record storage path>] check [-fix]")
+	}
+
+	st, err := openStorage(!*fix)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	report, err := st.Check(false)
+	if err != nil {
+		return err
+	}
+	if err := printCheckReport(st, report); err != nil {
+		return err
+	}
+	if report.OK() {
+		return nil
+	}
+	if !*fix {
+		return fmt.Errorf("the storage needs a repair, run 'check -fix'.")
+	}
+
+	b_path, err := backupStorage(st)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("backup: %s\n", b_path)
+	if _, err := st.Check(true); err != nil {
+		return err
+	}
+	fmt.Println("repaired.")
+	return nil
+}
+
+func printCheckReport(st *miniquet.Storage, report *miniquet.CheckReport) error {
+	fmt.Println(report)
+	for _, r := range report.Broken {
+		fmt.Printf("broken %s: %s\n", r.Key, r.Reason)
+	}
+	return st.ForEachQuarantine(func(r *miniquet.QuarantineRecord) error {
+		fmt.Printf("quarantined %s at %s: %s\n", r.Key, r.Time.Format(FmtTime), r.Reason)
+		return nil
+	})
+}
+
+//recover
+//The files are copied to '<record storage path>.broken-<time>' before they
+//are repaired.
+func recoverCommand(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("USAGE: miniquet2-term [-r <record storage path>] recover")
+	}
+	if StorageType == miniquet.STORAGE_MEMORY {
+		return fmt.Errorf("memory storage has nothing to recover.")
+	}
+	if isNewStorage() {
+		return fmt.Errorf("%s does not exist.", StoragePath)
+	}
+
+	b_path := miniquet.BackupPath(filepath.Dir(filepath.Clean(StoragePath)), StoragePath,
+									miniquet.BACKUP_BROKEN, time.Now())
+	if err := copyFiles(filepath.Clean(StoragePath), b_path); err != nil {
+		return fmt.Errorf("cannot copy the storage: %s", err)
+	}
+	fmt.Printf("copied the broken storage to %s\n", b_path)
+
+	aside, err := miniquet.RecoverStorage(StorageType, StoragePath)
+	if err != nil {
+		return err
+	}
+	if aside != "" {
+		fmt.Printf("moved the unreadable records to %s\n", aside)
+	}
+
+	st, err := openStorage(false)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	report, err := st.Check(true)
+	if err != nil {
+		return err
+	}
+	if err := printCheckReport(st, report); err != nil {
+		return err
+	}
+	fmt.Println("recovered.")
+	return nil
+}
+
+//copyFiles copies a file, or the files in a directory, as they are.
+func copyFiles(src string, dst string) error {
+	return filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if fi.IsDir() {
+			return os.MkdirAll(target, 0700)
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		return copyFile(path, target)
+	})
+}
+
+func copyFile(src string, dst string) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	w, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Sync(); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
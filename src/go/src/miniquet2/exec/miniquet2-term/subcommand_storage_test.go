@@ -0,0 +1,151 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"io/ioutil"
+	"path/filepath"
+)
+
+import (
+	"miniquet2/miniquet"
+)
+
+//useStorage points the subcommands to a log storage in a new directory,
+//and returns the function restoring them.
+func useStorage(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "miniquet2-subcommand-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s_type, s_path := StorageType, StoragePath
+	StorageType = miniquet.STORAGE_LOG
+	StoragePath = filepath.Join(dir, "log")
+	return dir, func() {
+		StorageType, StoragePath = s_type, s_path
+		os.RemoveAll(dir)
+	}
+}
+
+//writeEntries writes the entries one by one, and returns the size of the
+//storage before and after each of them.
+func writeEntries(t *testing.T, es ...*miniquet.Entry) []int64 {
+	st, err := miniquet.OpenStorageWith(StorageType, StoragePath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	sizes := []int64{}
+	for i := 0; i <= len(es); i++ {
+		if i > 0 {
+			if err := st.Put(es[i - 1]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		fi, err := os.Stat(StoragePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes = append(sizes, fi.Size())
+	}
+	return sizes
+}
+
+func expectEntries(t *testing.T, ids ...string) *miniquet.Storage {
+	st, err := openStorage(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		if _, err := st.Get(id); err != nil {
+			st.Close()
+			t.Fatalf("%s: %s", id, err)
+		}
+	}
+	return st
+}
+
+//TestCheckCommandFix quarantines an undecodable entry after a backup, and
+//keeps the healthy ones.
+func TestCheckCommandFix(t *testing.T) {
+	dir, done := useStorage(t)
+	defer done()
+
+	a := miniquet.NewEntry("alice", "BTC", 0.1, 100)
+	b := miniquet.NewEntry("alice", "ETH", 1, 200)
+	writeEntries(t, a, b)
+
+	bk, err := miniquet.OpenBackend(StorageType, StoragePath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := miniquet.NewBatch()
+	batch.Put([]byte(miniquet.NS_ENTRIES + "broken"), []byte{0xc1})
+	if err := bk.Write(batch); err != nil {
+		t.Fatal(err)
+	}
+	bk.Close()
+
+	if err := checkCommand(nil); err == nil {
+		t.Fatal("broken entry is not reported.")
+	}
+	if err := checkCommand([]string{"-fix"}); err != nil {
+		t.Fatal(err)
+	}
+	backups, _ := filepath.Glob(filepath.Join(dir, "*" + miniquet.BACKUP_MANUAL + "*"))
+	if len(backups) != 1 {
+		t.Fatalf("backups are %v", backups)
+	}
+
+	st := expectEntries(t, a.Id(), b.Id())
+	defer st.Close()
+	report, err := st.Check(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || report.Entries != 2 || report.Quarantined != 1 {
+		t.Fatalf("unexpected report: %s", report)
+	}
+}
+
+//TestRecoverCommand breaks the first record of a log storage, and
+//recovers the entries after it.
+func TestRecoverCommand(t *testing.T) {
+	dir, done := useStorage(t)
+	defer done()
+
+	a := miniquet.NewEntry("alice", "BTC", 0.1, 100)
+	b := miniquet.NewEntry("alice", "ETH", 1, 200)
+	sizes := writeEntries(t, a, b)
+
+	data, err := ioutil.ReadFile(StoragePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[sizes[1] - 1] ^= 0xff
+	if err := ioutil.WriteFile(StoragePath, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openStorage(true); err == nil {
+		t.Fatal("broken storage is opened.")
+	}
+
+	if err := recoverCommand(nil); err != nil {
+		t.Fatal(err)
+	}
+	broken, _ := filepath.Glob(filepath.Join(dir, "*" + miniquet.BACKUP_BROKEN + "*"))
+	if len(broken) != 1 {
+		t.Fatalf("copies of the broken storage are %v", broken)
+	}
+	if copied, err := ioutil.ReadFile(broken[0]); err != nil || string(copied) != string(data) {
+		t.Fatalf("broken storage is not copied as it was: %v", err)
+	}
+
+	st := expectEntries(t, b.Id())
+	defer st.Close()
+	if _, err := st.Get(a.Id()); err == nil {
+		t.Fatal("entry of the broken record is read.")
+	}
+}
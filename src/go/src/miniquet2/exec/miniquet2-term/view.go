@@ -69,6 +69,7 @@ func (self *View) SetOperandMsg(s string, msg ...interface{}) {
 	var space rune
 	lstr := fmt.Sprintf(s, msg...)
 	self.setLine(lstr, space, self.tail, termbox.ColorBlack, termbox.ColorWhite)
+	termbox.HideCursor()
 }
 
 func (self *View) SetOperandErr(s string, msg ...interface{}) {
@@ -79,6 +80,7 @@ func (self *View) SetOperandErr(s string, msg ...interface{}) {
 	var space rune
 	lstr := fmt.Sprintf(s, msg...)
 	self.setLine(lstr, space, self.tail, termbox.ColorWhite, termbox.ColorRed)
+	termbox.HideCursor()
 }
 
 func (self *View) SetOperand(s string, msg ...interface{}) {
@@ -90,6 +92,28 @@ func (self *View) SetOperand(s string, msg ...interface{}) {
 	var fg termbox.Attribute = termbox.ColorDefault
 	lstr := fmt.Sprintf(s, msg...)
 	self.setLine(lstr, space, self.tail, fg, termbox.ColorDefault)
+	termbox.HideCursor()
+}
+
+//SetCommandLine shows the line being edited with the cursor at the rune
+//of the index. The line is shifted left when the cursor is over the width.
+func (self *View) SetCommandLine(line string, cursor int) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+	defer self.flush()
+
+	rs := []rune(line)
+	if cursor > len(rs) {
+		cursor = len(rs)
+	}
+	shift := 0
+	if self.width > 0 && cursor >= self.width {
+		shift = cursor - self.width + 1
+	}
+
+	var space rune
+	self.setLine(string(rs[shift:]), space, self.tail, termbox.ColorDefault, termbox.ColorDefault)
+	termbox.SetCursor(cursor - shift, self.tail)
 }
 
 func (self *View) Resize() {
@@ -191,6 +215,25 @@ func (self *View) AddViewLayer(vl ViewLayer) {
 	self.resize()
 }
 
+//SwapViewLayer shows the new layer at the place of the old one.
+func (self *View) SwapViewLayer(old ViewLayer, new ViewLayer) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	for i, vl := range self.vls {
+		if vl != old {
+			continue
+		}
+
+		new.SetFlusher(self.Flush)
+		new.SetCellWriter(termbox.SetCell)
+		old.SetCellWriter(nil)
+		self.vls[i] = new
+		self.resize()
+		return
+	}
+}
+
 func (self *View) Close() {
 	termbox.Close()
 }
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+import (
+	"github.com/nsf/termbox-go"
+)
+
+import (
+	"miniquet2/miniquet"
+)
+
+type ArchiveViewLayer struct {
+	ViewLayerBase
+}
+
+func NewArchiveViewLayer(strach_factor int) *ArchiveViewLayer {
+	return &ArchiveViewLayer{
+		ViewLayerBase{strach_factor:strach_factor, title:"archive", mtx:new(sync.Mutex)},
+	}
+}
+
+//SetValues shows the archived entries, the last closed first.
+func (self *ArchiveViewLayer) SetValues(infos []*miniquet.ArchiveInfo) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+	defer self.call_flusher()
+
+	y := self.head
+	for i := len(infos) - 1; i >= 0; i-- {
+		if y > self.tail {
+			return
+		}
+		self.setLine(infos[i], y)
+		y++
+	}
+	for ; y <= self.tail; y++ {
+		self.setSpace(y)
+	}
+}
+
+func (self *ArchiveViewLayer) setLine(info *miniquet.ArchiveInfo, y int) {
+	var fg termbox.Attribute = termbox.ColorDefault
+	if float64(0) < info.Win {
+		fg = termbox.ColorGreen
+	}
+	if float64(0) > info.Win {
+		fg = termbox.ColorRed
+	}
+
+	line := fmt.Sprintf("%s %-6s %s %s [%s(%.5f)] Win: %.3f", info.ClosedAt.Format(miniquet.FmtLogTime),
+					info.Reason, info.Id, info.Trader, info.Symbol, info.Size, info.Win)
+	runes := []rune(line)
+	for i, c := range runes {
+		if i > self.width {
+			return
+		}
+
+		self.call_setSell(i, y, c, fg, termbox.ColorDefault)
+	}
+
+	var space rune
+	for i := len(runes); i < self.width; i++ {
+		self.call_setSell(i, y, space, fg, termbox.ColorDefault)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+import (
+	"github.com/nsf/termbox-go"
+)
+
+import (
+	"miniquet2/miniquet"
+)
+
+type DetailViewLayer struct {
+	ViewLayerBase
+}
+
+func NewDetailViewLayer(strach_factor int) *DetailViewLayer {
+	return &DetailViewLayer{
+		ViewLayerBase{strach_factor:strach_factor, title:"detail", mtx:new(sync.Mutex)},
+	}
+}
+
+//SetValues shows the fields and the strategy state of the entry.
+func (self *DetailViewLayer) SetValues(id string, en *miniquet.EntryInfo) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+	defer self.call_flusher()
+
+	lines := []string{}
+	if en == nil {
+		lines = append(lines, fmt.Sprintf("entry '%s' is not found.", id))
+	} else {
+		lines = append(lines,
+			fmt.Sprintf("Id       : %s", en.Id),
+			fmt.Sprintf("Trader   : %s", en.Trader),
+			fmt.Sprintf("Symbol   : %s", en.Symbol),
+			fmt.Sprintf("Position : %s", en.Position),
+			fmt.Sprintf("Size     : %.5f", en.Size),
+			fmt.Sprintf("Win      : %.3f", en.Win),
+			fmt.Sprintf("Point    : %.10f", en.Point),
+			fmt.Sprintf("LastRate : %.3f", en.LastRate),
+			fmt.Sprintf("LastDate : %s", en.LastDate.Format(FmtTime)),
+			fmt.Sprintf("Stopping : %v", en.Stopping),
+			"State    :",
+		)
+		if len(en.State) < 1 {
+			lines = append(lines, "  (empty)")
+		}
+		for _, st := range en.State {
+			keep := ""
+			if st.Keep {
+				keep = " (keep)"
+			}
+			lines = append(lines, fmt.Sprintf("  %-24s %-6s %s%s", st.Key, st.Type, st.Value, keep))
+		}
+	}
+
+	y := self.head
+	for _, l := range lines {
+		if y > self.tail {
+			return
+		}
+		self.setLine(l, y)
+		y++
+	}
+	for ; y <= self.tail; y++ {
+		self.setSpace(y)
+	}
+}
+
+func (self *DetailViewLayer) setLine(l string, y int) {
+	runes := []rune(l)
+	for i, c := range runes {
+		if i > self.width {
+			return
+		}
+
+		self.call_setSell(i, y, c, termbox.ColorDefault, termbox.ColorDefault)
+	}
+
+	var space rune
+	for i := len(runes); i < self.width; i++ {
+		self.call_setSell(i, y, space, termbox.ColorDefault, termbox.ColorDefault)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"sync"
+)
+
+import (
+	"github.com/nsf/termbox-go"
+)
+
+import (
+	"miniquet2/miniquet"
+)
+
+type HistoryViewLayer struct {
+	ViewLayerBase
+}
+
+func NewHistoryViewLayer(strach_factor int) *HistoryViewLayer {
+	return &HistoryViewLayer{
+		ViewLayerBase{strach_factor:strach_factor, title:"history", mtx:new(sync.Mutex)},
+	}
+}
+
+//SetValues shows the records newest first.
+func (self *HistoryViewLayer) SetValues(rs []*miniquet.AuditRecord) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+	defer self.call_flusher()
+
+	y := self.head
+	for i := len(rs) - 1; i >= 0; i-- {
+		if y > self.tail {
+			return
+		}
+		self.setLine(rs[i], y)
+		y++
+	}
+	for ; y <= self.tail; y++ {
+		self.setSpace(y)
+	}
+}
+
+func (self *HistoryViewLayer) setLine(r *miniquet.AuditRecord, y int) {
+	var fg termbox.Attribute = termbox.ColorDefault
+	if r.Result == miniquet.AuditError {
+		fg = termbox.ColorRed
+	}
+
+	runes := []rune(r.String())
+	for i, c := range runes {
+		if i > self.width {
+			return
+		}
+
+		self.call_setSell(i, y, c, fg, termbox.ColorDefault)
+	}
+
+	var space rune
+	for i := len(runes); i < self.width; i++ {
+		self.call_setSell(i, y, space, fg, termbox.ColorDefault)
+	}
+}
@@ -18,11 +18,22 @@ func NewLogViewLayer(strach_factor int) *LogViewLayer {
 	}
 }
 
-func (self *LogViewLayer) SetValues(logs []*LogValue) {
+//SetValues shows the lines ending offset lines before the last one, and
+//highlights search in them.
+func (self *LogViewLayer) SetValues(logs []*LogValue, offset int, search string) {
 	self.mtx.Lock()
 	defer self.mtx.Unlock()
 	defer self.call_flusher()
 
+	end := len(logs) - offset
+	if end > len(logs) {
+		end = len(logs)
+	}
+	if end < 0 {
+		end = 0
+	}
+	logs = logs[:end]
+
 	r_size := self.tail - self.head
 	if len(logs) > r_size {
 		for i := 0; i <= r_size; i++ {
@@ -32,14 +43,14 @@ func (self *LogViewLayer) SetValues(logs []*LogValue) {
 			if y < self.head {
 				break
 			}
-			self.setLine(log, y)
+			self.setLine(log, y, search)
 		}
 		return
 	}
 
 	for i, log := range logs {
 		y := self.head + i
-		self.setLine(log, y)
+		self.setLine(log, y, search)
 	}
 	sp_head := len(logs) + self.head
 	for y := sp_head; y <= self.tail; y++ {
@@ -47,23 +58,47 @@ func (self *LogViewLayer) SetValues(logs []*LogValue) {
 	}
 }
 
-func (self *LogViewLayer) setLine(log *LogValue, y int) {
+//Rows returns the lines shown at once.
+func (self *LogViewLayer) Rows() int {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	return self.tail - self.head + 1
+}
+
+func (self *LogViewLayer) setLine(log *LogValue, y int, search string) {
 	var fg termbox.Attribute = termbox.ColorDefault
 
-	if log.Type() == LogTypeErr {
+	switch log.Type() {
+	case LogTypeErr:
 		fg = termbox.ColorRed
+	case LogTypeWarn:
+		fg = termbox.ColorYellow
+	}
+
+	hl := make(map[int]bool)
+	s_len := len([]rune(search))
+	for _, idx := range log.Matches(search) {
+		for i := idx; i < idx + s_len; i++ {
+			hl[i] = true
+		}
 	}
 
-	for i, r := range log.Runes() {
+	rs := log.Runes()
+	for i, r := range rs {
 		if i > self.width {
 			return
 		}
 
-		self.call_setSell(i, y, r, fg, termbox.ColorDefault)
+		attr := fg
+		if hl[i] {
+			attr |= termbox.AttrReverse
+		}
+		self.call_setSell(i, y, r, attr, termbox.ColorDefault)
 	}
 
 	var space rune
-	for i := len(log.Runes()); i < self.width; i++ {
+	for i := len(rs); i < self.width; i++ {
 		self.call_setSell(i, y, space, fg, termbox.ColorDefault)
 	}
 }
@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"sort"
 	"sync"
 )
 
@@ -16,100 +15,124 @@ import (
 
 type ProgressViewLayer struct {
 	ViewLayerBase
+
+	//top is the first line shown, moved to keep the cursor in the view.
+	top  int
+	//attr is added to the colors of the line being drawn.
+	attr termbox.Attribute
 }
 
 func NewProgressViewLayer(strach_factor int) *ProgressViewLayer {
 	return &ProgressViewLayer{
-		ViewLayerBase{strach_factor:strach_factor, mtx:new(sync.Mutex)},
+		ViewLayerBase:ViewLayerBase{strach_factor:strach_factor, mtx:new(sync.Mutex)},
 	}
 }
 
-func (self *ProgressViewLayer) SetValues(trs map[string]*miniquet.Trader) {
+//SetValues shows the lines from top, with the line at the cursor in
+//reverse.
+func (self *ProgressViewLayer) SetValues(rows []*ProgressRow, cursor int) {
 	self.mtx.Lock()
 	defer self.mtx.Unlock()
 	defer self.call_flusher()
 
-	limit_size := self.tail - self.head
-
-	index := []string{}
-	for k, _ := range trs {
-		index = append(index, k)
+	lines := self.tail - self.head + 1
+	if cursor < self.top {
+		self.top = cursor
+	}
+	if cursor >= self.top + lines {
+		self.top = cursor - lines + 1
+	}
+	if self.top > len(rows) - lines {
+		self.top = len(rows) - lines
+	}
+	if self.top < 0 {
+		self.top = 0
 	}
-	sort.SliceStable(index, func(i, j int) bool { return index[i] < index[j] })
 
-	size := 0
-	for i, k := range index {
-		if i != 0{
-			size++
+	y := self.head
+	for i := self.top; i < len(rows) && y <= self.tail; i++ {
+		self.attr = 0
+		if i == cursor {
+			self.attr = termbox.AttrReverse
 		}
-		if size > limit_size {
-			return
+
+		row := rows[i]
+		switch {
+		case row.Entry != nil:
+			self.setEntry(row.Entry, y)
+		case row.Killed != nil:
+			self.setKilled(row.Killed, y)
+		default:
+			self.setTrader(row.Trader, y)
 		}
+		y++
+	}
+	self.attr = 0
+	for ; y <= self.tail; y++ {
+		self.setSpace(y)
+	}
+}
 
-		tr := trs[k]
+func (self *ProgressViewLayer) setTrader(tr *miniquet.TraderInfo, y int) {
+	np := 0
+	np = self.setBlock(np, 2, y, "* ", termbox.ColorDefault)
+	np = self.setBlock(np, 5, y, tr.Name, termbox.ColorDefault)
+	d_runes := []rune(tr.Description)
+	if len(d_runes) > 97 {
+		d_runes = append(d_runes[:96], []rune("...")...)
+	}
+	d_str := fmt.Sprintf(" (%s), ", string(d_runes))
+	np = self.setBlock(np, 100, y, d_str, termbox.ColorDefault)
+	w_str := fmt.Sprintf("WIN : %.3f (closed %d)", tr.Win, tr.Closed)
+	np = self.setBlock(np, 32, y, w_str, termbox.ColorDefault)
+	self.setRest(np, y)
+}
 
-		y := self.head + size
-		np := 0
-		np = self.setBlock(np, 2, y, "* ", termbox.ColorDefault)
-		np = self.setBlock(np, 5, y, tr.Name(), termbox.ColorDefault)
-		d_runes := []rune(tr.Description())
-		if len(d_runes) > 97 {
-			d_runes = append(d_runes[:96], []rune("...")...)
-		}
-		d_str := fmt.Sprintf(" (%s), ", string(d_runes))
-		np = self.setBlock(np, 100, y, d_str, termbox.ColorDefault)
-		//w_str := fmt.Sprintf("WIN : %.3f", tr.Win()) //TODO: add function
-		//self.setBlock(np, 10, y, w_str, termbox.ColorDefault)
-
-		en_index := []string{}
-		for k, _ := range tr.Entries() {
-			en_index = append(en_index, k)
-		}
-		sort.SliceStable(en_index, func(i, j int) bool { return en_index[i] < en_index[j] })
-
-		for _, id := range en_index {
-			size++
-			if size > limit_size {
-				return
-			}
-
-			y := self.head + size
-			np := 0
-			np = self.setBlock(np, 5, y, "  ┠- ", termbox.ColorDefault)
-
-			en, ok := tr.GetEntriy(id)
-			if !ok {
-				self.setBlock(np, 37, y, k + "(data not found)", termbox.ColorDefault)
-				continue
-			}
-
-			np = self.setBlock(np, 37, y, en.Id(), termbox.ColorDefault)
-
-			size_str := fmt.Sprintf("%.5f", en.Size)
-			np = self.setBlock(np, 6, y, " [" + en.Position, termbox.ColorDefault)
-			np = self.setBlock(np, 16, y, ":" + en.Symbol + "(" + size_str + ")] ", termbox.ColorDefault)
-
-			point_str := fmt.Sprintf("%.10f", en.Point())
-			np = self.setBlock(np, 18, y, "Point: " + point_str, termbox.ColorDefault)
-
-			win_str := fmt.Sprintf("%.3f ", en.Win)
-			var win_color termbox.Attribute = termbox.ColorDefault
-			if float64(0) < en.Win {
-				win_color = termbox.ColorGreen
-			}
-			if float64(0) > en.Win {
-				win_color = termbox.ColorRed
-			}
-			np = self.setBlock(np, 6, y, " Win: ", termbox.ColorDefault)
-			np = self.setBlock(np, 9, y, win_str, win_color)
-
-			lt_s := en.LastDate().Format("2006-01-02 15:04:05")
-			n_str := fmt.Sprintf("LastOrder{Rate: %.3f, Date: %s}", en.LastRate(), lt_s)
-			np = self.setBlock(np, 100, y, n_str, termbox.ColorDefault)
-		}
+func (self *ProgressViewLayer) setEntry(en *miniquet.EntryInfo, y int) {
+	np := 0
+	np = self.setBlock(np, 5, y, "  ┠- ", termbox.ColorDefault)
+	np = self.setBlock(np, 37, y, en.Id, termbox.ColorDefault)
+
+	size_str := fmt.Sprintf("%.5f", en.Size)
+	np = self.setBlock(np, 6, y, " [" + en.Position, termbox.ColorDefault)
+	np = self.setBlock(np, 16, y, ":" + en.Symbol + "(" + size_str + ")] ", termbox.ColorDefault)
+
+	point_str := fmt.Sprintf("%.10f", en.Point)
+	np = self.setBlock(np, 18, y, "Point: " + point_str, termbox.ColorDefault)
+
+	win_str := fmt.Sprintf("%.3f ", en.Win)
+	var win_color termbox.Attribute = termbox.ColorDefault
+	if float64(0) < en.Win {
+		win_color = termbox.ColorGreen
+	}
+	if float64(0) > en.Win {
+		win_color = termbox.ColorRed
 	}
+	np = self.setBlock(np, 6, y, " Win: ", termbox.ColorDefault)
+	np = self.setBlock(np, 9, y, win_str, win_color)
 
-	return
+	lt_s := en.LastDate.Format("2006-01-02 15:04:05")
+	n_str := fmt.Sprintf("LastOrder{Rate: %.3f, Date: %s}", en.LastRate, lt_s)
+	np = self.setBlock(np, 100, y, n_str, termbox.ColorDefault)
+	self.setRest(np, y)
+}
+
+func (self *ProgressViewLayer) setKilled(k *miniquet.ArchiveInfo, y int) {
+	np := 0
+	np = self.setBlock(np, 5, y, "  ┠- ", termbox.ColorDefault)
+	np = self.setBlock(np, 37, y, k.Id, termbox.ColorRed)
+
+	k_str := fmt.Sprintf(" [KILLED at %s] r or :undo to revive", k.ClosedAt.Format("15:04:05"))
+	np = self.setBlock(np, 100, y, k_str, termbox.ColorRed)
+	self.setRest(np, y)
+}
+
+//setRest clears the line after sp.
+func (self *ProgressViewLayer) setRest(sp int, y int) {
+	var space rune
+	for i := sp; i < self.width; i++ {
+		self.call_setSell(i, y, space, termbox.ColorDefault | self.attr, termbox.ColorDefault)
+	}
 }
 
 func (self *ProgressViewLayer) setLine(l string, y int, fg termbox.Attribute, bg termbox.Attribute) {
@@ -136,12 +159,12 @@ func (self *ProgressViewLayer) setBlock(sp int, max int, y int, v string, fg ter
 			return size
 		}
 
-		self.call_setSell(sp + i, y, r, fg, termbox.ColorDefault)
+		self.call_setSell(sp + i, y, r, fg | self.attr, termbox.ColorDefault)
 	}
 
 	var space rune
 	for i := len(rs); i < max; i++ {
-		self.call_setSell(sp + i, y, space, fg, termbox.ColorDefault)
+		self.call_setSell(sp + i, y, space, fg | self.attr, termbox.ColorDefault)
 	}
 	return size
 }
@@ -23,12 +23,60 @@ const (
 )
 
 var (
+	ConfPath     string
 	Conf         *miniquet.Config
+	Subcommand   string
 )
 
 func miniquet2() error {
 	c, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
+	log, closer, err := newLogger(&Conf.Log)
+	if err != nil {
+		return err
+	}
+	defer closer()
+
+	nt, err := miniquet.NewNotifyHub(Conf.Notifier)
+	if err != nil {
+		return err
+	}
+	defer nt.Close()
+	nt.ErrorHandler(log.WriteErrLog)
+
+	log.Info("started", "name", MiniketName)
+	if err := run(c, cancel); err != nil {
+		log.Error("stopped", "name", MiniketName, "err", err)
+		nt.Notify(miniquet.NewNotice(miniquet.NoticeError, "%s stopped: %s", MiniketName, err))
+		return err
+	}
+	log.Info("stopped", "name", MiniketName)
+	return nil
+}
+
+//newLogger returns a logger writing to stderr, and to the log file when
+//it is set in the config.
+func newLogger(c *miniquet.LogConfig) (*miniquet.LevelLogger, func(), error) {
+	level, err := miniquet.ParseLevel(c.Level)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	log := miniquet.NewLevelLogger(level, miniquet.NewWriterSink(os.Stderr))
+	if c.File == "" {
+		return log, func() {}, nil
+	}
+
+	f, err := miniquet.OpenRotateFile(c.File, c.RotateOpt())
+	if err != nil {
+		return nil, nil, err
+	}
+	log.AddSink(f)
+	return log, func() { f.Close() }, nil
+}
+
+func run(c context.Context, cancel context.CancelFunc) error {
 	gmocoin, err := gomocoin.NewGoMOcoin(Conf.ApiKey, Conf.SecretKey, c)
 	if err != nil {
 		return err
@@ -54,7 +102,7 @@ func init() {
 	flag.Parse()
 
 	if flag.NArg() < 0 {
-		die("usage : miniquet2 -c <config path>")
+		die("usage : miniquet2 [-c <config path>] [encrypt-config]")
 	}
 
 	if c_path == "" {
@@ -65,15 +113,53 @@ func init() {
 		c_path = usr.HomeDir + "/.miniquet2"
 	}
 
-	cfg, err := miniquet.LoadConfig(filepath.Clean(c_path))
+	ConfPath = filepath.Clean(c_path)
+	Subcommand = flag.Arg(0)
+	if Subcommand != "" {
+		return
+	}
+
+	//the traders of the config are run by miniquet2-term, which registers
+	//their strategies.
+	opt := *miniquet.DefaultConfigOpt
+	opt.SkipStrategy = true
+	cfg, err := miniquet.LoadConfigWithOpt(ConfPath, &opt)
 	if err != nil {
-		die("cannot load a config: %s", err)
+		die("cannot load a config:\n%s", err)
+	}
+	for _, w := range cfg.Warnings() {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", w)
 	}
 
 	Conf = cfg
 }
 
+func encryptConfig() error {
+	pass, err := miniquet.ReadNewPassphrase("new passphrase: ")
+	if err != nil {
+		return err
+	}
+
+	s_path, err := miniquet.EncryptConfig(ConfPath, pass)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("moved the secrets of %s into %s\n", ConfPath, s_path)
+	return nil
+}
+
 func main() {
+	switch Subcommand {
+	case "":
+	case "encrypt-config":
+		if err := encryptConfig(); err != nil {
+			die("%s: %s", Subcommand, err)
+		}
+		return
+	default:
+		die("unknown subcommand: %s", Subcommand)
+	}
+
 	if err := miniquet2(); err != nil {
 		die("%s", err)
 	}
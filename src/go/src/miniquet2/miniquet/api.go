@@ -0,0 +1,593 @@
+package miniquet
+
+import (
+	"os"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+	"strings"
+	"strconv"
+	"net/http"
+	"io/ioutil"
+	"crypto/subtle"
+	"encoding/json"
+)
+
+import (
+	"github.com/vouquet/shop"
+)
+
+const (
+	ApiVersionPath string = "/v1"
+	ApiSource      string = "api"
+	UnixPrefix     string = "unix:"
+
+	SIZE_API_BODY  int64 = 64 * 1024
+	DefaultApiLogs int = 100
+)
+
+//ApiConfig enables the control API. Listen is 'host:port' on the loopback
+//or 'unix:<path>' of a socket.
+type ApiConfig struct {
+	Listen    string
+	Token     string
+	TokenFile string
+}
+
+//LoadToken returns Token, or the first line of TokenFile.
+func (self *ApiConfig) LoadToken() (string, error) {
+	if self.TokenFile == "" {
+		return self.Token, nil
+	}
+
+	b, err := ioutil.ReadFile(self.TokenFile)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(strings.SplitN(string(b), "\n", 2)[0])
+	if token == "" {
+		return "", fmt.Errorf("%s is empty.", self.TokenFile)
+	}
+	return token, nil
+}
+
+//ApiBackend is what a running miniquet provides to the control API.
+//Exec runs a command as typed in the terminal, and returns the ids of
+//the entries it changed. The user is reported by the client, and empty
+//for the OS user of the backend.
+type ApiBackend interface {
+	Traders() []*Trader
+	Rates() (map[string]shop.Rate, time.Time)
+	Logs(n int) []*LogRecord
+	History(n int) ([]*AuditRecord, error)
+	Archive(n int) ([]*ArchiveRecord, error)
+	Exec(source string, user string, command string) ([]string, error)
+	Stats() (*StorageStats, error)
+}
+
+type TraderInfo struct {
+	Name        string       `json:"name"`
+	Description string       `json:"description"`
+	Win         float64      `json:"win"`
+	Closed      int          `json:"closed"`
+	Entries     []*EntryInfo `json:"entries"`
+	//Killed are the entries stopped by kill9 which can be revived.
+	Killed      []*ArchiveInfo `json:"killed,omitempty"`
+}
+
+type EntryInfo struct {
+	Id       string    `json:"id"`
+	Trader   string    `json:"trader"`
+	Symbol   string    `json:"symbol"`
+	Size     float64   `json:"size"`
+	Position string    `json:"position"`
+	Win      float64   `json:"win"`
+	Point    float64   `json:"point"`
+	LastRate float64   `json:"last_rate"`
+	LastDate time.Time `json:"last_date"`
+	Stopping bool      `json:"stopping"`
+	State    []*StateInfo `json:"state,omitempty"`
+}
+
+//ArchiveInfo is an archived entry. Win is its final win.
+type ArchiveInfo struct {
+	EntryInfo
+	Reason   string    `json:"reason"`
+	ClosedAt time.Time `json:"closed_at"`
+}
+
+type StateInfo struct {
+	Key   string `json:"key"`
+	Type  string `json:"type"`
+	Value string `json:"value"`
+	Keep  bool   `json:"keep"`
+}
+
+type RateInfo struct {
+	Symbol string    `json:"symbol"`
+	Ask    float64   `json:"ask"`
+	Bid    float64   `json:"bid"`
+	Time   time.Time `json:"time"`
+}
+
+func NewRateInfos(rates map[string]shop.Rate, t time.Time) []*RateInfo {
+	infos := []*RateInfo{}
+	for _, rate := range rates {
+		infos = append(infos, &RateInfo{Symbol: rate.Symbol(), Ask: rate.Ask(), Bid: rate.Bid(), Time: t})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Symbol < infos[j].Symbol })
+	return infos
+}
+
+//LogInfo is a LogRecord in JSON. Text is the message with the fields
+//in their order.
+type LogInfo struct {
+	Time   time.Time              `json:"time"`
+	Level  string                 `json:"level"`
+	Msg    string                 `json:"msg"`
+	Text   string                 `json:"text"`
+	Fields map[string]interface{} `json:"fields,omitempty"`
+}
+
+type AddRequest struct {
+	Trader string  `json:"trader"`
+	Symbol string  `json:"symbol"`
+	Size   float64 `json:"size"`
+	Rate   float64 `json:"rate"`
+}
+
+//CommandRequest runs a command as typed in the terminal.
+type CommandRequest struct {
+	Command string `json:"command"`
+	User    string `json:"user,omitempty"`
+}
+
+type ApiError struct {
+	Error string `json:"error"`
+}
+
+type ExecResult struct {
+	Entries []string `json:"entries"`
+	Error   string   `json:"error,omitempty"`
+}
+
+func NewTraderInfo(tr *Trader) *TraderInfo {
+	closed, _ := tr.ClosedWin()
+	info := &TraderInfo{
+		Name: tr.Name(),
+		Description: tr.Description(),
+		Win: tr.Win(),
+		Closed: closed,
+		Entries: tr.EntryInfos(),
+	}
+	sort.Slice(info.Entries, func(i, j int) bool { return info.Entries[i].Id < info.Entries[j].Id })
+	for _, r := range tr.Killed() {
+		info.Killed = append(info.Killed, NewArchiveInfo(r))
+	}
+	return info
+}
+
+func NewEntryInfo(e *Entry) *EntryInfo {
+	return &EntryInfo{
+		Id: e.Id(),
+		Trader: e.Trader,
+		Symbol: e.Symbol,
+		Size: e.Size,
+		Position: e.Position,
+		Win: e.Win,
+		Point: e.Point(),
+		LastRate: e.LastRate(),
+		LastDate: e.LastDate(),
+		Stopping: e.IsLastone(),
+		State: NewStateInfos(e.State),
+	}
+}
+
+func NewArchiveInfo(r *ArchiveRecord) *ArchiveInfo {
+	info := &ArchiveInfo{EntryInfo: *NewEntryInfo(r.Entry), Reason: r.Reason, ClosedAt: r.ClosedAt}
+	info.Win = r.Win
+	return info
+}
+
+func NewStateInfos(st State) []*StateInfo {
+	infos := []*StateInfo{}
+	for _, v := range st {
+		infos = append(infos, &StateInfo{Key: v.Key, Type: v.Type, Value: v.Text(), Keep: v.Keep})
+	}
+	return infos
+}
+
+func NewLogInfo(r *LogRecord) *LogInfo {
+	info := &LogInfo{Time: r.Time, Level: r.Level.String(), Msg: r.Msg, Text: r.Text()}
+	if len(r.Fields) < 2 {
+		return info
+	}
+
+	info.Fields = make(map[string]interface{})
+	for i := 0; i + 1 < len(r.Fields); i += 2 {
+		val := r.Fields[i + 1]
+		if err, ok := val.(error); ok {
+			val = err.Error()
+		}
+		info.Fields[fmt.Sprint(r.Fields[i])] = val
+	}
+	return info
+}
+
+//ApiServer serves the control API. Every request needs the header
+//'Authorization: Bearer <token>'.
+type ApiServer struct {
+	backend ApiBackend
+	token   string
+
+	srv  *http.Server
+	path string
+}
+
+func NewApiServer(backend ApiBackend, token string) (*ApiServer, error) {
+	if token == "" {
+		return nil, fmt.Errorf("empty api token.")
+	}
+	return &ApiServer{backend: backend, token: token}, nil
+}
+
+//Serve starts to listen addr in background.
+func (self *ApiServer) Serve(addr string) error {
+	l, err := ListenLocal(addr)
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(addr, UnixPrefix) {
+		self.path = strings.TrimPrefix(addr, UnixPrefix)
+	}
+
+	self.srv = &http.Server{Handler: self.Handler()}
+	go self.srv.Serve(l)
+	return nil
+}
+
+func (self *ApiServer) Close() error {
+	if self.srv == nil {
+		return nil
+	}
+	err := self.srv.Close()
+	if self.path != "" {
+		os.Remove(self.path)
+	}
+	return err
+}
+
+func (self *ApiServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(ApiVersionPath + "/traders", self.handleTraders)
+	mux.HandleFunc(ApiVersionPath + "/entries", self.handleEntries)
+	mux.HandleFunc(ApiVersionPath + "/entries/", self.handleEntry)
+	mux.HandleFunc(ApiVersionPath + "/rates", self.handleRates)
+	mux.HandleFunc(ApiVersionPath + "/logs", self.handleLogs)
+	mux.HandleFunc(ApiVersionPath + "/history", self.handleHistory)
+	mux.HandleFunc(ApiVersionPath + "/archive", self.handleArchive)
+	mux.HandleFunc(ApiVersionPath + "/commands", self.handleCommands)
+	mux.HandleFunc(ApiVersionPath + "/storage/stats", self.handleStats)
+	return self.auth(mux)
+}
+
+func (self *ApiServer) auth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(self.token)) != 1 {
+			writeApiError(w, http.StatusUnauthorized, fmt.Errorf("invalid token."))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+//GET /v1/traders
+func (self *ApiServer) handleTraders(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeApiError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s is not allowed.", r.Method))
+		return
+	}
+
+	infos := []*TraderInfo{}
+	for _, tr := range self.backend.Traders() {
+		infos = append(infos, NewTraderInfo(tr))
+	}
+	writeApiJSON(w, http.StatusOK, infos)
+}
+
+//GET /v1/entries[?trader=<name>], POST /v1/entries with AddRequest
+func (self *ApiServer) handleEntries(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		t_name := r.URL.Query().Get("trader")
+
+		infos := []*EntryInfo{}
+		for _, tr := range self.backend.Traders() {
+			if t_name != "" && tr.Name() != t_name {
+				continue
+			}
+			infos = append(infos, NewTraderInfo(tr).Entries...)
+		}
+		writeApiJSON(w, http.StatusOK, infos)
+	case http.MethodPost:
+		var req AddRequest
+		if err := readApiJSON(w, r, &req); err != nil {
+			writeApiError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := checkWords(req.Trader, req.Symbol); err != nil {
+			writeApiError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		command := fmt.Sprintf("add %s %s %s %s", req.Trader, req.Symbol,
+				strconv.FormatFloat(req.Size, 'f', -1, 64),
+				strconv.FormatFloat(req.Rate, 'f', -1, 64))
+		self.exec(w, command)
+	default:
+		writeApiError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s is not allowed.", r.Method))
+	}
+}
+
+//GET /v1/entries/<id>, POST /v1/entries/<id>/stop, POST /v1/entries/<id>/kill9,
+//POST /v1/entries/<id>/revive
+func (self *ApiServer) handleEntry(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, ApiVersionPath + "/entries/")
+	ps := strings.Split(path, "/")
+
+	//a killed entry is not found in the traders.
+	if len(ps) == 2 && ps[1] == "revive" {
+		if r.Method != http.MethodPost {
+			writeApiError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s is not allowed.", r.Method))
+			return
+		}
+		if err := checkWords(ps[0]); err != nil {
+			writeApiError(w, http.StatusBadRequest, err)
+			return
+		}
+		self.exec(w, "revive " + ps[0])
+		return
+	}
+
+	entry, err := self.findEntry(ps[0])
+	if err != nil {
+		if _, ok := err.(*AmbiguousIdError); ok {
+			writeApiError(w, http.StatusConflict, err)
+			return
+		}
+		writeApiError(w, http.StatusNotFound, fmt.Errorf("entry not found: '%s'", ps[0]))
+		return
+	}
+
+	if len(ps) == 1 {
+		if r.Method != http.MethodGet {
+			writeApiError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s is not allowed.", r.Method))
+			return
+		}
+		writeApiJSON(w, http.StatusOK, entry)
+		return
+	}
+
+	if len(ps) != 2 || (ps[1] != "stop" && ps[1] != "kill9") {
+		writeApiError(w, http.StatusNotFound, fmt.Errorf("unknown path: '%s'", r.URL.Path))
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeApiError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s is not allowed.", r.Method))
+		return
+	}
+	self.exec(w, fmt.Sprintf("%s %s %s", ps[1], entry.Trader, entry.Id))
+}
+
+//GET /v1/rates
+func (self *ApiServer) handleRates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeApiError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s is not allowed.", r.Method))
+		return
+	}
+
+	writeApiJSON(w, http.StatusOK, NewRateInfos(self.backend.Rates()))
+}
+
+//GET /v1/logs[?n=<count>]
+func (self *ApiServer) handleLogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeApiError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s is not allowed.", r.Method))
+		return
+	}
+
+	n, err := queryCount(r, DefaultApiLogs)
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	infos := []*LogInfo{}
+	for _, rec := range self.backend.Logs(n) {
+		infos = append(infos, NewLogInfo(rec))
+	}
+	writeApiJSON(w, http.StatusOK, infos)
+}
+
+//GET /v1/history[?n=<count>]
+func (self *ApiServer) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeApiError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s is not allowed.", r.Method))
+		return
+	}
+
+	n, err := queryCount(r, DefaultApiLogs)
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, err)
+		return
+	}
+	rs, err := self.backend.History(n)
+	if err != nil {
+		writeApiError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeApiJSON(w, http.StatusOK, rs)
+}
+
+//GET /v1/archive[?n=<count>]
+func (self *ApiServer) handleArchive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeApiError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s is not allowed.", r.Method))
+		return
+	}
+
+	n, err := queryCount(r, DefaultApiLogs)
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, err)
+		return
+	}
+	rs, err := self.backend.Archive(n)
+	if err != nil {
+		writeApiError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	infos := []*ArchiveInfo{}
+	for _, r := range rs {
+		infos = append(infos, NewArchiveInfo(r))
+	}
+	writeApiJSON(w, http.StatusOK, infos)
+}
+
+//GET /v1/storage/stats
+func (self *ApiServer) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeApiError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s is not allowed.", r.Method))
+		return
+	}
+
+	stats, err := self.backend.Stats()
+	if err != nil {
+		writeApiError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeApiJSON(w, http.StatusOK, stats)
+}
+
+//POST /v1/commands with CommandRequest
+func (self *ApiServer) handleCommands(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeApiError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s is not allowed.", r.Method))
+		return
+	}
+
+	var req CommandRequest
+	if err := readApiJSON(w, r, &req); err != nil {
+		writeApiError(w, http.StatusBadRequest, err)
+		return
+	}
+	self.execAs(w, req.User, req.Command)
+}
+
+func (self *ApiServer) exec(w http.ResponseWriter, command string) {
+	self.execAs(w, "", command)
+}
+
+func (self *ApiServer) execAs(w http.ResponseWriter, user string, command string) {
+	ids, err := self.backend.Exec(ApiSource, user, command)
+	if err != nil {
+		writeApiJSON(w, http.StatusUnprocessableEntity, &ExecResult{Entries: ids, Error: err.Error()})
+		return
+	}
+	writeApiJSON(w, http.StatusOK, &ExecResult{Entries: ids})
+}
+
+//findEntry returns the entry whose id is or starts with id. The id is
+//matched over the entries of all the traders.
+func (self *ApiServer) findEntry(id string) (*EntryInfo, error) {
+	infos := make(map[string]*EntryInfo)
+	ids := []string{}
+	for _, tr := range self.backend.Traders() {
+		for _, info := range tr.EntryInfos() {
+			infos[info.Id] = info
+			ids = append(ids, info.Id)
+		}
+	}
+
+	match, err := MatchId(id, ids)
+	if err != nil {
+		return nil, err
+	}
+	return infos[match], nil
+}
+
+//ListenLocal listens 'unix:<path>', or 'host:port' only on the loopback.
+//A socket is made readable only by the owner.
+func ListenLocal(addr string) (net.Listener, error) {
+	if strings.HasPrefix(addr, UnixPrefix) {
+		path := strings.TrimPrefix(addr, UnixPrefix)
+		if fi, err := os.Stat(path); err == nil && fi.Mode() & os.ModeSocket != 0 {
+			os.Remove(path)
+		}
+
+		l, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Chmod(path, 0600); err != nil {
+			l.Close()
+			return nil, err
+		}
+		return l, nil
+	}
+
+	if err := CheckLoopback(addr); err != nil {
+		return nil, err
+	}
+	return net.Listen("tcp", addr)
+}
+
+func CheckLoopback(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host == "localhost" {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("'%s' is not a loopback address.", host)
+	}
+	return nil
+}
+
+func queryCount(r *http.Request, def int) (int, error) {
+	s := r.URL.Query().Get("n")
+	if s == "" {
+		return def, nil
+	}
+	return strconv.Atoi(s)
+}
+
+func checkWords(ws ...string) error {
+	for _, w := range ws {
+		if w == "" || strings.ContainsAny(w, " \t\n") {
+			return fmt.Errorf("invalid value: '%s'", w)
+		}
+	}
+	return nil
+}
+
+func readApiJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, SIZE_API_BODY))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+func writeApiJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeApiError(w http.ResponseWriter, code int, err error) {
+	writeApiJSON(w, code, &ApiError{Error: err.Error()})
+}
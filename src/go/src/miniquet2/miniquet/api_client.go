@@ -0,0 +1,143 @@
+package miniquet
+
+import (
+	"io"
+	"fmt"
+	"net"
+	"time"
+	"bytes"
+	"strings"
+	"context"
+	"net/http"
+	"io/ioutil"
+	"encoding/json"
+)
+
+const (
+	ApiClientTimeout time.Duration = 10 * time.Second
+)
+
+//ApiClient talks to the control API of a running miniquet.
+type ApiClient struct {
+	addr   string
+	base   string
+	token  string
+	client *http.Client
+}
+
+//NewApiClient returns a client of the API listening addr, given in the
+//same form as ApiConfig.Listen.
+func NewApiClient(addr string, token string) *ApiClient {
+	tr := &http.Transport{}
+	base := "http://" + addr
+	if strings.HasPrefix(addr, UnixPrefix) {
+		path := strings.TrimPrefix(addr, UnixPrefix)
+		tr.DialContext = func(ctx context.Context, _ string, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		}
+		base = "http://localhost"
+	}
+
+	return &ApiClient{
+		addr: addr,
+		base: base + ApiVersionPath,
+		token: token,
+		client: &http.Client{Transport: tr, Timeout: ApiClientTimeout},
+	}
+}
+
+func (self *ApiClient) Addr() string {
+	return self.addr
+}
+
+func (self *ApiClient) Traders() ([]*TraderInfo, error) {
+	var infos []*TraderInfo
+	err := self.do(http.MethodGet, "/traders", nil, &infos)
+	return infos, err
+}
+
+func (self *ApiClient) Rates() ([]*RateInfo, error) {
+	var infos []*RateInfo
+	err := self.do(http.MethodGet, "/rates", nil, &infos)
+	return infos, err
+}
+
+func (self *ApiClient) Logs(n int) ([]*LogInfo, error) {
+	var infos []*LogInfo
+	err := self.do(http.MethodGet, fmt.Sprintf("/logs?n=%d", n), nil, &infos)
+	return infos, err
+}
+
+func (self *ApiClient) History(n int) ([]*AuditRecord, error) {
+	var rs []*AuditRecord
+	err := self.do(http.MethodGet, fmt.Sprintf("/history?n=%d", n), nil, &rs)
+	return rs, err
+}
+
+func (self *ApiClient) Archive(n int) ([]*ArchiveInfo, error) {
+	var infos []*ArchiveInfo
+	err := self.do(http.MethodGet, fmt.Sprintf("/archive?n=%d", n), nil, &infos)
+	return infos, err
+}
+
+func (self *ApiClient) Stats() (*StorageStats, error) {
+	var stats StorageStats
+	if err := self.do(http.MethodGet, "/storage/stats", nil, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+//Exec runs a command as typed in the terminal, as the current OS user.
+func (self *ApiClient) Exec(command string) ([]string, error) {
+	var res ExecResult
+	req := &CommandRequest{Command: command, User: CurrentUser()}
+	if err := self.do(http.MethodPost, "/commands", req, &res); err != nil {
+		return res.Entries, err
+	}
+	return res.Entries, nil
+}
+
+func (self *ApiClient) do(method string, path string, in interface{}, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, self.base + path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer " + self.token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := self.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, 16 * 1024 * 1024))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusOK {
+		return json.Unmarshal(b, out)
+	}
+
+	var a_err ApiError
+	if err := json.Unmarshal(b, &a_err); err != nil || a_err.Error == "" {
+		return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	if resp.StatusCode == http.StatusUnprocessableEntity {
+		json.Unmarshal(b, out)
+	}
+	return fmt.Errorf("%s", a_err.Error)
+}
@@ -0,0 +1,83 @@
+package miniquet
+
+import (
+	"time"
+	"testing"
+	"net/http"
+	"net/http/httptest"
+)
+
+import (
+	"github.com/google/uuid"
+	"github.com/vouquet/shop"
+)
+
+type testApiBackend struct {
+	traders []*Trader
+}
+
+func (self *testApiBackend) Traders() []*Trader {
+	return self.traders
+}
+
+func (self *testApiBackend) Rates() (map[string]shop.Rate, time.Time) {
+	return nil, time.Time{}
+}
+
+func (self *testApiBackend) Logs(int) []*LogRecord {
+	return nil
+}
+
+func (self *testApiBackend) History(int) ([]*AuditRecord, error) {
+	return nil, nil
+}
+
+func (self *testApiBackend) Archive(int) ([]*ArchiveRecord, error) {
+	return nil, nil
+}
+
+func (self *testApiBackend) Exec(string, string, string) ([]string, error) {
+	return nil, nil
+}
+
+func (self *testApiBackend) Stats() (*StorageStats, error) {
+	return nil, nil
+}
+
+//TestApiEntryPrefix checks a prefix is matched over all the traders.
+func TestApiEntryPrefix(t *testing.T) {
+	b := &testApiBackend{}
+	for name, id := range map[string]string{
+		"alice": "aaaaaaaa-0000-4000-8000-000000000000",
+		"bob": "aaaaaaaa-1111-4000-8000-000000000000",
+	} {
+		tr := NewTrader(name, "", nil, NewMemStorage())
+		e := NewEntry(name, "BTC", 0.1, 100)
+		e.Uuid = uuid.MustParse(id)
+		if err := tr.RequestAppend(e); err != nil {
+			t.Fatal(err)
+		}
+		b.traders = append(b.traders, tr)
+	}
+
+	srv, err := NewApiServer(b, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := srv.Handler()
+
+	for prefix, want := range map[string]int{
+		"aaaa": http.StatusConflict,
+		"aaaaaaaa-1": http.StatusOK,
+		"aaaaaaaa-0000-4000-8000-000000000000": http.StatusOK,
+		"ffff": http.StatusNotFound,
+	} {
+		r := httptest.NewRequest(http.MethodGet, ApiVersionPath + "/entries/" + prefix, nil)
+		r.Header.Set("Authorization", "Bearer token")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != want {
+			t.Errorf("%s: want %d, got %d: %s", prefix, want, w.Code, w.Body)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package miniquet
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+import (
+	"github.com/ugorji/go/codec"
+)
+
+//The reasons an entry is archived.
+const (
+	//ARCHIVE_CLOSED is an entry which finished its last run.
+	ARCHIVE_CLOSED string = "closed"
+	//ARCHIVE_KILLED is an entry stopped by kill9.
+	ARCHIVE_KILLED string = "killed"
+)
+
+//ArchiveRecord is an entry which is not traded anymore. Win is the final
+//win of the entry.
+type ArchiveRecord struct {
+	Entry    *Entry
+	Reason   string
+	Win      float64
+	ClosedAt time.Time
+}
+
+//archiveRecord keeps the entry encoded, so that it is upgraded by decode
+//as the other entries.
+type archiveRecord struct {
+	Entry    []byte
+	Reason   string
+	Win      float64
+	ClosedAt time.Time
+}
+
+func archiveKey(id string) []byte {
+	return []byte(NS_ARCHIVE + id)
+}
+
+func killedKey(id string) []byte {
+	return []byte(NS_KILLED + id)
+}
+
+func encodeArchive(r *ArchiveRecord) ([]byte, error) {
+	e, err := encode(r.Entry)
+	if err != nil {
+		return nil, err
+	}
+
+	var b []byte
+	rec := &archiveRecord{Entry: e, Reason: r.Reason, Win: r.Win, ClosedAt: r.ClosedAt}
+	if err := codec.NewEncoderBytes(&b, MsgpckHndl).Encode(rec); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func decodeArchive(b []byte) (*ArchiveRecord, error) {
+	var rec archiveRecord
+	if err := codec.NewDecoderBytes(b, MsgpckHndl).Decode(&rec); err != nil {
+		return nil, err
+	}
+	e, err := decode(rec.Entry)
+	if err != nil {
+		return nil, err
+	}
+	return &ArchiveRecord{Entry: e, Reason: rec.Reason, Win: rec.Win, ClosedAt: rec.ClosedAt}, nil
+}
+
+//Archive moves the entry and its indexes to NS_ARCHIVE with the reason,
+//in one batch.
+func (self *Storage) Archive(entry *Entry, reason string) error {
+	self.lock()
+	defer self.unlock()
+	defer self.observed("archive", time.Now())
+
+	_, err := self.moveEntry(entry, reason, NS_ARCHIVE)
+	return err
+}
+
+//Kill moves the entry to NS_KILLED. It can be revived until it is moved
+//to NS_ARCHIVE by ArchiveKilled.
+func (self *Storage) Kill(entry *Entry) (*ArchiveRecord, error) {
+	self.lock()
+	defer self.unlock()
+	defer self.observed("archive", time.Now())
+
+	return self.moveEntry(entry, ARCHIVE_KILLED, NS_KILLED)
+}
+
+func (self *Storage) moveEntry(entry *Entry, reason string, ns string) (*ArchiveRecord, error) {
+	if self.b == nil {
+		return nil, fmt.Errorf("target database is nil pointer.")
+	}
+
+	r := &ArchiveRecord{Entry: entry, Reason: reason, Win: entry.Win, ClosedAt: time.Now()}
+	b, err := encodeArchive(r)
+	if err != nil {
+		return nil, err
+	}
+
+	batch := NewBatch()
+	if err := self.deleteIndex(batch, entry.Id()); err != nil {
+		return nil, err
+	}
+	batch.Delete(entryKey(entry.Id()))
+	batch.Put([]byte(ns + entry.Id()), b)
+	if err := self.b.Write(batch); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+//Revive moves the killed entry back with its indexes, and returns it.
+func (self *Storage) Revive(id string) (*Entry, error) {
+	self.lock()
+	defer self.unlock()
+	defer self.observed("put", time.Now())
+
+	if self.b == nil {
+		return nil, fmt.Errorf("target database is nil pointer.")
+	}
+
+	val, err := self.b.Get(killedKey(id))
+	if err != nil {
+		return nil, err
+	}
+	r, err := decodeArchive(val)
+	if err != nil {
+		return nil, err
+	}
+	b, err := encode(r.Entry)
+	if err != nil {
+		return nil, err
+	}
+
+	batch := NewBatch()
+	batch.Delete(killedKey(id))
+	batch.Put(entryKey(id), b)
+	for _, k := range indexKeys(r.Entry) {
+		batch.Put(k, []byte{})
+	}
+	if err := self.b.Write(batch); err != nil {
+		return nil, err
+	}
+	return r.Entry, nil
+}
+
+//ArchiveKilled moves the killed entry to NS_ARCHIVE. It cannot be revived
+//after it.
+func (self *Storage) ArchiveKilled(id string) error {
+	self.lock()
+	defer self.unlock()
+	defer self.observed("archive", time.Now())
+
+	if self.b == nil {
+		return fmt.Errorf("target database is nil pointer.")
+	}
+
+	val, err := self.b.Get(killedKey(id))
+	if err != nil {
+		return err
+	}
+
+	batch := NewBatch()
+	batch.Delete(killedKey(id))
+	batch.Put(archiveKey(id), val)
+	return self.b.Write(batch)
+}
+
+//ForEachKilled calls f with the killed entries in the order of the id.
+//f must not use the storage.
+func (self *Storage) ForEachKilled(f func(*ArchiveRecord) error) error {
+	self.lock()
+	defer self.unlock()
+	defer self.observed("walk", time.Now())
+
+	return self.forEachRecord(NS_KILLED, f)
+}
+
+func (self *Storage) GetArchive(id string) (*ArchiveRecord, error) {
+	self.lock()
+	defer self.unlock()
+	defer self.observed("get", time.Now())
+
+	if self.b == nil {
+		return nil, fmt.Errorf("target database is nil pointer.")
+	}
+
+	b, err := self.b.Get(archiveKey(id))
+	if err != nil {
+		return nil, err
+	}
+	return decodeArchive(b)
+}
+
+//ForEachArchive calls f with the archived entries in the order of the id.
+//f must not use the storage.
+func (self *Storage) ForEachArchive(f func(*ArchiveRecord) error) error {
+	self.lock()
+	defer self.unlock()
+	defer self.observed("walk", time.Now())
+
+	return self.forEachRecord(NS_ARCHIVE, f)
+}
+
+func (self *Storage) forEachRecord(ns string, f func(*ArchiveRecord) error) error {
+	if self.b == nil {
+		return fmt.Errorf("target database is nil pointer.")
+	}
+
+	return self.b.Range([]byte(ns), func(key []byte, val []byte) error {
+		r, err := decodeArchive(val)
+		if err != nil {
+			return fmt.Errorf("record '%s': %s", string(key), err)
+		}
+		return f(r)
+	})
+}
+
+//Archived returns the last n archived entries, the oldest first. All are
+//returned for n < 1.
+func (self *Storage) Archived(n int) ([]*ArchiveRecord, error) {
+	rs := []*ArchiveRecord{}
+	err := self.ForEachArchive(func(r *ArchiveRecord) error {
+		rs = append(rs, r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(rs, func(i, j int) bool { return rs[i].ClosedAt.Before(rs[j].ClosedAt) })
+	if n > 0 && len(rs) > n {
+		rs = rs[len(rs) - n:]
+	}
+	return rs, nil
+}
+
+func (self *ArchiveRecord) String() string {
+	e := self.Entry
+	return fmt.Sprintf("%s %-6s %s %s %s %.5f win %.3f", self.ClosedAt.Format(FmtLogTime), self.Reason,
+						e.Id(), e.Trader, e.Symbol, e.Size, self.Win)
+}
@@ -0,0 +1,213 @@
+package miniquet
+
+import (
+	"os"
+	"fmt"
+	"sync"
+	"time"
+	"bufio"
+	"os/user"
+	"encoding/json"
+	"path/filepath"
+)
+
+const (
+	AuditOk    string = "ok"
+	AuditError string = "error"
+
+	//SIZE_AUDIT_TAIL is the number of the last records kept in memory.
+	SIZE_AUDIT_TAIL int = 1000
+)
+
+//AuditRecord is one operator command in the audit log.
+type AuditRecord struct {
+	Time    time.Time `json:"time"`
+	User    string    `json:"user"`
+	Source  string    `json:"source,omitempty"`
+	Input   string    `json:"input"`
+	Command string    `json:"command"`
+	Args    []string  `json:"args"`
+	Result  string    `json:"result"`
+	Error   string    `json:"error,omitempty"`
+	Entries []string  `json:"entries,omitempty"`
+}
+
+//NewAuditRecord returns a record of the input by the current OS user.
+//Set the result with Done.
+func NewAuditRecord(input string, command string, args []string) *AuditRecord {
+	return &AuditRecord{
+		Time: time.Now(),
+		User: CurrentUser(),
+		Input: input,
+		Command: command,
+		Args: args,
+	}
+}
+
+func (self *AuditRecord) Done(entries []string, err error) {
+	self.Entries = entries
+	if err != nil {
+		self.Result = AuditError
+		self.Error = err.Error()
+		return
+	}
+	self.Result = AuditOk
+}
+
+func (self *AuditRecord) String() string {
+	user := self.User
+	if self.Source != "" {
+		user += "(" + self.Source + ")"
+	}
+	s := fmt.Sprintf("%s %s %s: %s", self.Time.Format(FmtLogTime), user, self.Result, self.Input)
+	if len(self.Entries) > 0 {
+		s += fmt.Sprintf(" %v", self.Entries)
+	}
+	if self.Error != "" {
+		s += ": " + self.Error
+	}
+	return s
+}
+
+func CurrentUser() string {
+	usr, err := user.Current()
+	if err != nil {
+		return fmt.Sprintf("uid:%d", os.Getuid())
+	}
+	return usr.Username
+}
+
+//AuditLineError is a line of an audit log which cannot be read, such as
+//a line torn by a crash. The line is skipped and the others are read.
+type AuditLineError struct {
+	Path string
+	Line int
+	Err  error
+}
+
+func (self *AuditLineError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", self.Path, self.Line, self.Err)
+}
+
+//AuditLog is an append-only file of AuditRecord, one JSON per line.
+//Records are synced to the disk before Append returns. The last
+//SIZE_AUDIT_TAIL records are kept in memory for Records.
+type AuditLog struct {
+	path    string
+	f       *os.File
+	tail    []*AuditRecord
+	skipped []*AuditLineError
+
+	mtx *sync.Mutex
+}
+
+func OpenAuditLog(path string) (*AuditLog, error) {
+	c_path := filepath.Clean(path)
+	tail, skipped, err := ReadAuditLog(c_path, SIZE_AUDIT_TAIL)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(c_path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLog{path: c_path, f: f, tail: tail, skipped: skipped, mtx: new(sync.Mutex)}, nil
+}
+
+func (self *AuditLog) Path() string {
+	return self.path
+}
+
+//Skipped returns the lines which could not be read at OpenAuditLog.
+func (self *AuditLog) Skipped() []*AuditLineError {
+	return self.skipped
+}
+
+func (self *AuditLog) Append(r *AuditRecord) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	if self.f == nil {
+		return fmt.Errorf("audit log is closed.")
+	}
+	if _, err := self.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	if err := self.f.Sync(); err != nil {
+		return err
+	}
+
+	self.tail = append(self.tail, r)
+	if len(self.tail) > SIZE_AUDIT_TAIL {
+		self.tail = self.tail[len(self.tail) - SIZE_AUDIT_TAIL:]
+	}
+	return nil
+}
+
+//Records returns the last n records in memory, oldest first. n < 1
+//returns all of them. Use ReadAuditLog for the older records.
+func (self *AuditLog) Records(n int) ([]*AuditRecord, error) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	rs := self.tail
+	if n > 0 && len(rs) > n {
+		rs = rs[len(rs) - n:]
+	}
+	return append([]*AuditRecord{}, rs...), nil
+}
+
+func (self *AuditLog) Close() error {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	if self.f == nil {
+		return nil
+	}
+	err := self.f.Close()
+	self.f = nil
+	return err
+}
+
+//ReadAuditLog reads the last n records of an audit log file. n < 1 reads
+//all. A line which cannot be read is skipped, and returned as an
+//AuditLineError.
+func ReadAuditLog(path string, n int) ([]*AuditRecord, []*AuditLineError, error) {
+	f, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	rs := []*AuditRecord{}
+	skipped := []*AuditLineError{}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64 * 1024), 1024 * 1024)
+	line := 0
+	for sc.Scan() {
+		line++
+		if len(sc.Bytes()) < 1 {
+			continue
+		}
+
+		var r AuditRecord
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			skipped = append(skipped, &AuditLineError{Path: path, Line: line, Err: err})
+			continue
+		}
+		rs = append(rs, &r)
+		if n > 0 && len(rs) > n {
+			rs = rs[1:]
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, nil, err
+	}
+	return rs, skipped, nil
+}
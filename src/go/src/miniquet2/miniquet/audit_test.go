@@ -0,0 +1,106 @@
+package miniquet
+
+import (
+	"os"
+	"fmt"
+	"bytes"
+	"testing"
+	"io/ioutil"
+	"path/filepath"
+	"encoding/json"
+)
+
+func writeAudit(t *testing.T, lines ...string) string {
+	dir, err := ioutil.TempDir("", "miniquet2-audit-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "audit")
+
+	buf := new(bytes.Buffer)
+	for _, l := range lines {
+		buf.WriteString(l + "\n")
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func auditLine(t *testing.T, input string) string {
+	b, err := json.Marshal(&AuditRecord{User: "alice", Input: input, Result: AuditOk})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+//TestReadAuditLogSkips checks a torn line is skipped with its line number,
+//and the records around it are read.
+func TestReadAuditLogSkips(t *testing.T) {
+	path := writeAudit(t, auditLine(t, "list"), `{"time":"2021-`, "", auditLine(t, "stop a"))
+	defer os.RemoveAll(filepath.Dir(path))
+
+	rs, skipped, err := ReadAuditLog(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rs) != 2 || rs[0].Input != "list" || rs[1].Input != "stop a" {
+		t.Fatalf("records are %v", rs)
+	}
+	if len(skipped) != 1 || skipped[0].Line != 2 {
+		t.Fatalf("skipped %v", skipped)
+	}
+
+	rs, _, err = ReadAuditLog(path, 1)
+	if err != nil || len(rs) != 1 || rs[0].Input != "stop a" {
+		t.Fatalf("last record is %v, %v", rs, err)
+	}
+}
+
+//TestAuditLogTail checks Records is served from the last records in
+//memory, seeded from the file.
+func TestAuditLogTail(t *testing.T) {
+	lines := []string{"broken"}
+	for i := 0; i < SIZE_AUDIT_TAIL + 5; i++ {
+		lines = append(lines, auditLine(t, fmt.Sprintf("stop %d", i)))
+	}
+	path := writeAudit(t, lines...)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	a, err := OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	if s := a.Skipped(); len(s) != 1 || s[0].Line != 1 {
+		t.Fatalf("skipped %v", s)
+	}
+
+	rs, err := a.Records(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rs) != SIZE_AUDIT_TAIL || rs[0].Input != "stop 5" {
+		t.Fatalf("%d records from '%s'", len(rs), rs[0].Input)
+	}
+
+	if err := a.Append(NewAuditRecord("add x", "add", []string{"x"})); err != nil {
+		t.Fatal(err)
+	}
+	rs, err = a.Records(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rs) != 2 || rs[0].Input != fmt.Sprintf("stop %d", SIZE_AUDIT_TAIL + 4) || rs[1].Input != "add x" {
+		t.Fatalf("records are %v", rs)
+	}
+	if rs, _ := a.Records(0); len(rs) != SIZE_AUDIT_TAIL {
+		t.Fatalf("tail grows to %d", len(rs))
+	}
+
+	rs, _, err = ReadAuditLog(path, 1)
+	if err != nil || len(rs) != 1 || rs[0].Input != "add x" {
+		t.Fatalf("appended record is not in the file: %v, %v", rs, err)
+	}
+}
@@ -0,0 +1,211 @@
+package miniquet
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"strings"
+)
+
+const (
+	STORAGE_LEVELDB string = "leveldb"
+	STORAGE_MEMORY  string = "memory"
+	STORAGE_LOG     string = "log"
+)
+
+var (
+	StorageTypes []string = []string{STORAGE_LEVELDB, STORAGE_MEMORY, STORAGE_LOG}
+)
+
+//Backend is the key value store under a Storage. Keys are ordered by their
+//bytes, and Get returns ErrNotFound for a missing key. Every Backend must
+//pass TestBackends.
+type Backend interface {
+	Get(key []byte) ([]byte, error)
+	//Write applies all the operations of the batch, or none of them.
+	Write(b *Batch) error
+	//Range calls f with the keys which start with prefix, in order, on a
+	//consistent view of the backend. An error of f stops the iteration and
+	//is returned.
+	Range(prefix []byte, f func(key []byte, val []byte) error) error
+	//Backup writes a consistent copy to a new backend at path, while the
+	//backend is still used.
+	Backup(path string) error
+	//Compact reclaims the space of the deleted and overwritten values.
+	Compact() error
+	//Size returns the bytes used on disk.
+	Size() (int64, error)
+	Close() error
+}
+
+//OpenBackend opens a backend of the type. The path is not used by the
+//memory backend.
+func OpenBackend(s_type string, path string, s_opt *StorageOpt) (Backend, error) {
+	if s_opt == nil {
+		s_opt = DefaultStorageOpt
+	}
+
+	switch s_type {
+	case STORAGE_LEVELDB, "":
+		return openLevelBackend(path, s_opt)
+	case STORAGE_MEMORY:
+		return NewMemBackend(), nil
+	case STORAGE_LOG:
+		return OpenLogBackend(path, s_opt)
+	}
+	return nil, fmt.Errorf("unknown storage type '%s', use one of %s.", s_type, strings.Join(StorageTypes, ", "))
+}
+
+type batchOp struct {
+	Del bool
+	Key []byte
+	Val []byte
+}
+
+//Batch is a list of writes applied at once by Backend.Write.
+type Batch struct {
+	ops []*batchOp
+}
+
+func NewBatch() *Batch {
+	return &Batch{ops: []*batchOp{}}
+}
+
+func (self *Batch) Put(key []byte, val []byte) {
+	self.ops = append(self.ops, &batchOp{Key: copyBytes(key), Val: copyBytes(val)})
+}
+
+func (self *Batch) Delete(key []byte) {
+	self.ops = append(self.ops, &batchOp{Del: true, Key: copyBytes(key)})
+}
+
+func (self *Batch) Len() int {
+	return len(self.ops)
+}
+
+func (self *Batch) Reset() {
+	self.ops = self.ops[:0]
+}
+
+func copyBytes(b []byte) []byte {
+	return append([]byte{}, b...)
+}
+
+//MemBackend keeps everything in memory. It is for the tests, backtests and
+//dry runs, and it is lost at Close.
+type MemBackend struct {
+	kv  map[string][]byte
+
+	mtx *sync.RWMutex
+}
+
+func NewMemBackend() *MemBackend {
+	return &MemBackend{kv: make(map[string][]byte), mtx: new(sync.RWMutex)}
+}
+
+//NewMemStorage returns an empty Storage on a MemBackend.
+func NewMemStorage() *Storage {
+	st, _ := NewStorage(NewMemBackend(), STORAGE_MEMORY, false)
+	return st
+}
+
+func (self *MemBackend) Get(key []byte) ([]byte, error) {
+	self.mtx.RLock()
+	defer self.mtx.RUnlock()
+
+	if self.kv == nil {
+		return nil, fmt.Errorf("memory storage is closed.")
+	}
+	v, ok := self.kv[string(key)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyBytes(v), nil
+}
+
+func (self *MemBackend) Write(b *Batch) error {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	if self.kv == nil {
+		return fmt.Errorf("memory storage is closed.")
+	}
+	self.apply(b)
+	return nil
+}
+
+func (self *MemBackend) apply(b *Batch) {
+	for _, op := range b.ops {
+		if op.Del {
+			delete(self.kv, string(op.Key))
+			continue
+		}
+		self.kv[string(op.Key)] = copyBytes(op.Val)
+	}
+}
+
+//Range iterates a copy of the matched keys, so f may use the backend.
+func (self *MemBackend) Range(prefix []byte, f func([]byte, []byte) error) error {
+	keys, vals, err := self.view(prefix)
+	if err != nil {
+		return err
+	}
+
+	for i, k := range keys {
+		if err := f([]byte(k), vals[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (self *MemBackend) view(prefix []byte) ([]string, [][]byte, error) {
+	self.mtx.RLock()
+	defer self.mtx.RUnlock()
+
+	if self.kv == nil {
+		return nil, nil, fmt.Errorf("memory storage is closed.")
+	}
+
+	p := string(prefix)
+	keys := []string{}
+	for k, _ := range self.kv {
+		if strings.HasPrefix(k, p) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	vals := make([][]byte, len(keys))
+	for i, k := range keys {
+		vals[i] = self.kv[k]
+	}
+	return keys, vals, nil
+}
+
+func (self *MemBackend) Backup(path string) error {
+	return fmt.Errorf("memory storage cannot be backed up.")
+}
+
+func (self *MemBackend) Compact() error {
+	self.mtx.RLock()
+	defer self.mtx.RUnlock()
+
+	if self.kv == nil {
+		return fmt.Errorf("memory storage is closed.")
+	}
+	return nil
+}
+
+//Size is always 0, nothing is on disk.
+func (self *MemBackend) Size() (int64, error) {
+	return 0, nil
+}
+
+func (self *MemBackend) Close() error {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	self.kv = nil
+	return nil
+}
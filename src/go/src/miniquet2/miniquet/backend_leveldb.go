@@ -0,0 +1,176 @@
+package miniquet
+
+import (
+	"os"
+	"fmt"
+	"path/filepath"
+)
+
+import (
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const (
+	SIZE_BACKUP_BATCH int = 1000
+)
+
+//LevelBackend is the default Backend, a LevelDB directory.
+type LevelBackend struct {
+	db   *leveldb.DB
+	path string
+}
+
+func openLevelBackend(path string, s_opt *StorageOpt) (*LevelBackend, error) {
+	c_path := filepath.Clean(path)
+	if s_opt.ErrorIfMissing || s_opt.ReadOnly {
+		if _, err := os.Stat(c_path); err != nil {
+			return nil, err
+		}
+	}
+
+	db, err := leveldb.OpenFile(c_path, &opt.Options{
+		ErrorIfExist: s_opt.ErrorIfExist,
+		ReadOnly: s_opt.ReadOnly,
+	})
+	if err != nil {
+		if isLocked(err) {
+			return nil, &StorageLockedError{Path: c_path}
+		}
+		if errors.IsCorrupted(err) {
+			return nil, &StorageCorruptedError{Path: c_path, Err: err}
+		}
+		return nil, err
+	}
+	return &LevelBackend{db: db, path: c_path}, nil
+}
+
+//recoverLevel rebuilds the manifest of the LevelDB from its tables, with
+//the repair of LevelDB. The broken blocks are dropped.
+func recoverLevel(path string) error {
+	db, err := leveldb.RecoverFile(filepath.Clean(path), nil)
+	if err != nil {
+		if isLocked(err) {
+			return &StorageLockedError{Path: filepath.Clean(path)}
+		}
+		return err
+	}
+	return db.Close()
+}
+
+func (self *LevelBackend) Get(key []byte) ([]byte, error) {
+	if self.db == nil {
+		return nil, fmt.Errorf("target database is nil pointer.")
+	}
+	return self.db.Get(key, nil)
+}
+
+func (self *LevelBackend) Write(b *Batch) error {
+	if self.db == nil {
+		return fmt.Errorf("target database is nil pointer.")
+	}
+
+	batch := new(leveldb.Batch)
+	for _, op := range b.ops {
+		if op.Del {
+			batch.Delete(op.Key)
+			continue
+		}
+		batch.Put(op.Key, op.Val)
+	}
+	return self.db.Write(batch, nil)
+}
+
+func (self *LevelBackend) Range(prefix []byte, f func([]byte, []byte) error) error {
+	if self.db == nil {
+		return fmt.Errorf("target database is nil pointer.")
+	}
+
+	var r *util.Range
+	if len(prefix) > 0 {
+		r = util.BytesPrefix(prefix)
+	}
+	iter := self.db.NewIterator(r, nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		if err := f(iter.Key(), iter.Value()); err != nil {
+			return err
+		}
+	}
+	return iter.Error()
+}
+
+//Backup copies a snapshot into a new LevelDB at path in batches.
+func (self *LevelBackend) Backup(path string) error {
+	if self.db == nil {
+		return fmt.Errorf("target database is nil pointer.")
+	}
+	snap, err := self.db.GetSnapshot()
+	if err != nil {
+		return err
+	}
+	defer snap.Release()
+
+	dst, err := leveldb.OpenFile(filepath.Clean(path), &opt.Options{ErrorIfExist: true})
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	iter := snap.NewIterator(nil, nil)
+	defer iter.Release()
+
+	batch := new(leveldb.Batch)
+	for iter.Next() {
+		batch.Put(iter.Key(), iter.Value())
+		if batch.Len() >= SIZE_BACKUP_BATCH {
+			if err := dst.Write(batch, nil); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	return dst.Write(batch, nil)
+}
+
+func (self *LevelBackend) Compact() error {
+	if self.db == nil {
+		return fmt.Errorf("target database is nil pointer.")
+	}
+	return self.db.CompactRange(util.Range{})
+}
+
+//Size returns the total size of the files in the directory.
+func (self *LevelBackend) Size() (int64, error) {
+	return dirSize(self.path)
+}
+
+func (self *LevelBackend) Close() error {
+	if self.db == nil {
+		return nil
+	}
+	err := self.db.Close()
+	self.db = nil
+	return err
+}
+
+func dirSize(path string) (int64, error) {
+	var size int64
+	err := filepath.Walk(path, func(_ string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.Mode().IsRegular() {
+			size += fi.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
@@ -0,0 +1,399 @@
+package miniquet
+
+import (
+	"io"
+	"os"
+	"fmt"
+	"bytes"
+	"sort"
+	"time"
+	"bufio"
+	"hash/crc32"
+	"io/ioutil"
+	"encoding/binary"
+	"path/filepath"
+)
+
+import (
+	"github.com/ugorji/go/codec"
+)
+
+const (
+	SIZE_LOG_HEADER int = 8
+	//SIZE_LOG_RECORD_MAX stops the replay of a broken length.
+	SIZE_LOG_RECORD_MAX int = 64 * 1024 * 1024
+)
+
+//LogBackend is an append-only file for very small deployments. Every
+//Write is appended as one record and synced, and the whole store is kept
+//in memory. A record is '<length:4><crc32:4><msgpack of the batch>'.
+type LogBackend struct {
+	*MemBackend
+
+	path      string
+	f         *os.File
+	read_only bool
+}
+
+//OpenLogBackend replays the file at path. A torn record at the end, left
+//by a crash while writing, is cut off. A broken record before the end is
+//an error.
+func OpenLogBackend(path string, s_opt *StorageOpt) (*LogBackend, error) {
+	if s_opt == nil {
+		s_opt = DefaultStorageOpt
+	}
+	c_path := filepath.Clean(path)
+
+	flag := os.O_RDWR|os.O_CREATE
+	if s_opt.ReadOnly {
+		flag = os.O_RDONLY
+	} else if s_opt.ErrorIfMissing {
+		flag = os.O_RDWR
+	}
+	if s_opt.ErrorIfExist {
+		flag |= os.O_EXCL
+	}
+	f, err := os.OpenFile(c_path, flag, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := lockFile(f, s_opt.ReadOnly); err != nil {
+		f.Close()
+		if isLocked(err) {
+			return nil, &StorageLockedError{Path: c_path}
+		}
+		return nil, err
+	}
+
+	self := &LogBackend{
+		MemBackend: NewMemBackend(),
+		path: c_path,
+		f: f,
+		read_only: s_opt.ReadOnly,
+	}
+	if err := self.replay(); err != nil {
+		f.Close()
+		if _, ok := err.(*StorageCorruptedError); ok {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%s: %s", c_path, err)
+	}
+	return self, nil
+}
+
+func (self *LogBackend) replay() error {
+	fi, err := self.f.Stat()
+	if err != nil {
+		return err
+	}
+	size := fi.Size()
+
+	r := bufio.NewReader(self.f)
+	var off int64
+	for {
+		b, n, err := readLogRecord(r, size - off)
+		if err == io.EOF {
+			break
+		}
+		if err == errTornRecord {
+			if self.read_only {
+				break
+			}
+			if err := self.f.Truncate(off); err != nil {
+				return err
+			}
+			break
+		}
+		if err != nil {
+			return &StorageCorruptedError{Path: self.path, Err: fmt.Errorf("record at %d: %s", off, err)}
+		}
+
+		self.MemBackend.apply(b)
+		off += n
+	}
+
+	_, err = self.f.Seek(off, io.SeekStart)
+	return err
+}
+
+//recoverLog drops the broken records and keeps the others. A record
+//with a broken length ends the log. The dropped bytes are moved to
+//'<path>.corrupt-<time>', which is returned.
+func recoverLog(path string) (string, error) {
+	c_path := filepath.Clean(path)
+	f, err := os.OpenFile(c_path, os.O_RDWR, 0600)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if err := lockFile(f, false); err != nil {
+		if isLocked(err) {
+			return "", &StorageLockedError{Path: c_path}
+		}
+		return "", err
+	}
+
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return "", err
+	}
+	good := []byte{}
+	bad := []byte{}
+	var off int64
+	size := int64(len(data))
+	for off < size {
+		_, n, err := readLogRecord(bytes.NewReader(data[off:]), size - off)
+		if err == nil {
+			good = append(good, data[off:off + n]...)
+			off += n
+			continue
+		}
+		if n > 0 {
+			bad = append(bad, data[off:off + n]...)
+			off += n
+			continue
+		}
+		bad = append(bad, data[off:]...)
+		break
+	}
+	if len(bad) == 0 {
+		return "", nil
+	}
+
+	aside := c_path + ".corrupt-" + time.Now().Format(FmtBackupTime)
+	if err := writeFileSync(aside, bad, os.O_EXCL); err != nil {
+		return "", err
+	}
+	tmp := c_path + ".recover"
+	if err := writeFileSync(tmp, good, os.O_TRUNC); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, c_path); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return aside, syncDir(filepath.Dir(c_path))
+}
+
+func writeFileSync(path string, b []byte, flag int) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|flag, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+var errTornRecord error = fmt.Errorf("torn record.")
+
+//readLogRecord reads a record of the rest bytes of the file.
+func readLogRecord(r io.Reader, rest int64) (*Batch, int64, error) {
+	if rest == 0 {
+		return nil, 0, io.EOF
+	}
+	if rest < int64(SIZE_LOG_HEADER) {
+		return nil, 0, errTornRecord
+	}
+
+	head := make([]byte, SIZE_LOG_HEADER)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, 0, err
+	}
+	size := int64(binary.BigEndian.Uint32(head[:4]))
+	sum := binary.BigEndian.Uint32(head[4:])
+	if size > int64(SIZE_LOG_RECORD_MAX) {
+		return nil, 0, fmt.Errorf("record length %d is too large.", size)
+	}
+	if int64(SIZE_LOG_HEADER) + size > rest {
+		return nil, 0, errTornRecord
+	}
+
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, 0, err
+	}
+	//a broken body returns its length, so that the next record can be read.
+	if crc32.ChecksumIEEE(body) != sum {
+		if int64(SIZE_LOG_HEADER) + size == rest {
+			return nil, 0, errTornRecord
+		}
+		return nil, int64(SIZE_LOG_HEADER) + size, fmt.Errorf("checksum mismatch.")
+	}
+
+	ops := []*batchOp{}
+	if err := codec.NewDecoderBytes(body, MsgpckHndl).Decode(&ops); err != nil {
+		return nil, int64(SIZE_LOG_HEADER) + size, err
+	}
+	return &Batch{ops: ops}, int64(SIZE_LOG_HEADER) + size, nil
+}
+
+func writeLogRecord(w io.Writer, b *Batch) error {
+	var body []byte
+	if err := codec.NewEncoderBytes(&body, MsgpckHndl).Encode(b.ops); err != nil {
+		return err
+	}
+
+	buf := make([]byte, SIZE_LOG_HEADER, SIZE_LOG_HEADER + len(body))
+	binary.BigEndian.PutUint32(buf[:4], uint32(len(body)))
+	binary.BigEndian.PutUint32(buf[4:], crc32.ChecksumIEEE(body))
+	_, err := w.Write(append(buf, body...))
+	return err
+}
+
+func (self *LogBackend) Write(b *Batch) error {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	if self.f == nil {
+		return fmt.Errorf("log storage is closed.")
+	}
+	if self.read_only {
+		return fmt.Errorf("log storage is opened read only.")
+	}
+
+	off, err := self.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if err := writeLogRecord(self.f, b); err != nil {
+		return self.cutBack(off, err)
+	}
+	if err := self.f.Sync(); err != nil {
+		return self.cutBack(off, err)
+	}
+	self.MemBackend.apply(b)
+	return nil
+}
+
+//cutBack drops the part of a record written after off by a failed Write,
+//so that the next record follows the last good one.
+func (self *LogBackend) cutBack(off int64, err error) error {
+	if t_err := self.f.Truncate(off); t_err != nil {
+		return fmt.Errorf("%s, and cannot cut the record back: %s", err, t_err)
+	}
+	if _, s_err := self.f.Seek(off, io.SeekStart); s_err != nil {
+		return fmt.Errorf("%s, and cannot cut the record back: %s", err, s_err)
+	}
+	return err
+}
+
+//Backup writes the current values as a new log at path, one record.
+func (self *LogBackend) Backup(path string) error {
+	b := NewBatch()
+	err := self.Range(nil, func(k []byte, v []byte) error {
+		b.Put(k, v)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(filepath.Clean(path), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := writeLogRecord(f, b); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+//Compact rewrites the log as one record of the current values, and
+//replaces the file with it. The lock moves to the new file.
+func (self *LogBackend) Compact() error {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	if self.f == nil {
+		return fmt.Errorf("log storage is closed.")
+	}
+	if self.read_only {
+		return fmt.Errorf("log storage is opened read only.")
+	}
+
+	keys := []string{}
+	for k, _ := range self.kv {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	b := NewBatch()
+	for _, k := range keys {
+		b.Put([]byte(k), self.kv[k])
+	}
+
+	tmp := self.path + ".compact"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err := lockFile(f, false); err != nil {
+		f.Close()
+		return err
+	}
+	if err := writeLogRecord(f, b); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, self.path); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	syncDir(filepath.Dir(self.path))
+
+	self.f.Close()
+	self.f = f
+	return nil
+}
+
+func (self *LogBackend) Size() (int64, error) {
+	self.mtx.RLock()
+	defer self.mtx.RUnlock()
+
+	if self.f == nil {
+		return 0, fmt.Errorf("log storage is closed.")
+	}
+	fi, err := self.f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+func syncDir(path string) error {
+	d, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func (self *LogBackend) Close() error {
+	self.mtx.Lock()
+	f := self.f
+	self.f = nil
+	self.mtx.Unlock()
+
+	self.MemBackend.Close()
+	if f == nil {
+		return nil
+	}
+	return f.Close()
+}
@@ -0,0 +1,454 @@
+package miniquet
+
+import (
+	"io"
+	"fmt"
+	"bytes"
+	"os"
+	"time"
+	"testing"
+	"io/ioutil"
+	"path/filepath"
+)
+
+//TestBackends is the conformance suite of the backends. A persistent
+//backend is checked again after reopening.
+func TestBackends(t *testing.T) {
+	dir, err := ioutil.TempDir("", "miniquet2-check-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, s_type := range StorageTypes {
+		path := filepath.Join(dir, s_type)
+		mem := NewMemBackend()
+		open := func() (Backend, error) {
+			if s_type == STORAGE_MEMORY {
+				return mem, nil
+			}
+			return OpenBackend(s_type, path, nil)
+		}
+
+		t.Run(s_type, func(t *testing.T) {
+			if err := checkBackend(open, s_type != STORAGE_MEMORY); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+//checkBackend runs the checks on the backend. open must return the same,
+//initially empty backend every time it is called.
+func checkBackend(open func() (Backend, error), persistent bool) error {
+	b, err := open()
+	if err != nil {
+		return fmt.Errorf("open: %s", err)
+	}
+	for _, c := range backendChecks {
+		if err := c.f(b); err != nil {
+			b.Close()
+			return fmt.Errorf("%s: %s", c.name, err)
+		}
+	}
+	if err := checkStorage(b); err != nil {
+		b.Close()
+		return fmt.Errorf("storage: %s", err)
+	}
+	if !persistent {
+		return nil
+	}
+
+	b, err = open()
+	if err != nil {
+		return fmt.Errorf("reopen: %s", err)
+	}
+	defer b.Close()
+	return checkReopen(b)
+}
+
+type backendCheck struct {
+	name string
+	f    func(Backend) error
+}
+
+var backendChecks []*backendCheck = []*backendCheck{
+	&backendCheck{"get missing key", func(b Backend) error {
+		if _, err := b.Get([]byte("x/missing")); err != ErrNotFound {
+			return fmt.Errorf("want ErrNotFound, got %v", err)
+		}
+		return nil
+	}},
+	&backendCheck{"put and get", func(b Backend) error {
+		batch := NewBatch()
+		batch.Put([]byte("x/a"), []byte("1"))
+		batch.Put([]byte("x/empty"), []byte{})
+		if err := b.Write(batch); err != nil {
+			return err
+		}
+		if err := expectValue(b, "x/a", "1"); err != nil {
+			return err
+		}
+		return expectValue(b, "x/empty", "")
+	}},
+	&backendCheck{"overwrite", func(b Backend) error {
+		batch := NewBatch()
+		batch.Put([]byte("x/a"), []byte("2"))
+		if err := b.Write(batch); err != nil {
+			return err
+		}
+		return expectValue(b, "x/a", "2")
+	}},
+	&backendCheck{"batch is copied", func(b Backend) error {
+		val := []byte("3")
+		batch := NewBatch()
+		batch.Put([]byte("x/b"), val)
+		val[0] = '9'
+		if err := b.Write(batch); err != nil {
+			return err
+		}
+		got, _ := b.Get([]byte("x/b"))
+		got[0] = '8'
+		return expectValue(b, "x/b", "3")
+	}},
+	&backendCheck{"batch applies in order", func(b Backend) error {
+		batch := NewBatch()
+		batch.Put([]byte("x/c"), []byte("1"))
+		batch.Delete([]byte("x/c"))
+		batch.Put([]byte("x/d"), []byte("1"))
+		batch.Delete([]byte("x/d"))
+		batch.Put([]byte("x/d"), []byte("2"))
+		if err := b.Write(batch); err != nil {
+			return err
+		}
+		if _, err := b.Get([]byte("x/c")); err != ErrNotFound {
+			return fmt.Errorf("x/c: want ErrNotFound, got %v", err)
+		}
+		return expectValue(b, "x/d", "2")
+	}},
+	&backendCheck{"delete", func(b Backend) error {
+		batch := NewBatch()
+		batch.Delete([]byte("x/b"))
+		batch.Delete([]byte("x/never"))
+		if err := b.Write(batch); err != nil {
+			return err
+		}
+		if _, err := b.Get([]byte("x/b")); err != ErrNotFound {
+			return fmt.Errorf("want ErrNotFound, got %v", err)
+		}
+		return nil
+	}},
+	&backendCheck{"range by prefix in order", func(b Backend) error {
+		batch := NewBatch()
+		for _, k := range []string{"y/3", "y/1", "y/2", "y0/1", "yy/1", "z/1"} {
+			batch.Put([]byte(k), []byte(k))
+		}
+		if err := b.Write(batch); err != nil {
+			return err
+		}
+
+		got := []string{}
+		err := b.Range([]byte("y/"), func(k []byte, v []byte) error {
+			if !bytes.Equal(k, v) {
+				return fmt.Errorf("key '%s' has value '%s'", k, v)
+			}
+			got = append(got, string(k))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if fmt.Sprint(got) != "[y/1 y/2 y/3]" {
+			return fmt.Errorf("got %v", got)
+		}
+		return nil
+	}},
+	&backendCheck{"range stops at error", func(b Backend) error {
+		stop := fmt.Errorf("stop")
+		n := 0
+		err := b.Range([]byte("y/"), func([]byte, []byte) error {
+			n++
+			return stop
+		})
+		if err != stop || n != 1 {
+			return fmt.Errorf("got %v after %d keys", err, n)
+		}
+		return nil
+	}},
+	&backendCheck{"range all", func(b Backend) error {
+		n := 0
+		err := b.Range(nil, func([]byte, []byte) error {
+			n++
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if n != 9 {
+			return fmt.Errorf("want 9 keys, got %d", n)
+		}
+		return nil
+	}},
+	&backendCheck{"compact keeps the values", func(b Backend) error {
+		if err := b.Compact(); err != nil {
+			return err
+		}
+		if _, err := b.Size(); err != nil {
+			return err
+		}
+		if _, err := b.Get([]byte("x/b")); err != ErrNotFound {
+			return fmt.Errorf("deleted key is back: %v", err)
+		}
+		if err := expectValue(b, "x/empty", ""); err != nil {
+			return err
+		}
+		return expectValue(b, "x/a", "2")
+	}},
+}
+
+//checkStorage runs a Storage on the backend, and leaves one entry.
+func checkStorage(b Backend) error {
+	st, err := NewStorage(b, "conformance", false)
+	if err != nil {
+		return err
+	}
+	if v, err := st.SchemaVersion(); err != nil || v != SCHEMA_VERSION {
+		return fmt.Errorf("schema version %d, %v", v, err)
+	}
+
+	e := NewEntry("alice", "BTC", 0.1, 100)
+	e.StateOf("check").SetInt("n", 1)
+	if err := st.Put(e); err != nil {
+		return err
+	}
+	e.Trader = "bob"
+	if err := st.Put(e); err != nil {
+		return err
+	}
+	if n, err := countEntries(st.ForEachByTrader, "alice"); err != nil || n != 0 {
+		return fmt.Errorf("index of the old trader: %d entries, %v", n, err)
+	}
+	if n, err := countEntries(st.ForEachByTrader, "bob"); err != nil || n != 1 {
+		return fmt.Errorf("index of the trader: %d entries, %v", n, err)
+	}
+	if n, err := countEntries(st.ForEachBySymbol, "BTC"); err != nil || n != 1 {
+		return fmt.Errorf("index of the symbol: %d entries, %v", n, err)
+	}
+
+	gone := NewEntry("carol", "ETH", 1, 10)
+	if err := st.Put(gone); err != nil {
+		return err
+	}
+	if err := st.Delete(gone); err != nil {
+		return err
+	}
+	if n, err := countEntries(st.ForEachBySymbol, "ETH"); err != nil || n != 0 {
+		return fmt.Errorf("index of a deleted entry: %d entries, %v", n, err)
+	}
+	if _, err := st.Get(gone.Id()); err != ErrNotFound {
+		return fmt.Errorf("deleted entry: want ErrNotFound, got %v", err)
+	}
+
+	closed := NewEntry("dave", "XRP", 10, 50)
+	closed.Win = 1.5
+	if err := st.Put(closed); err != nil {
+		return err
+	}
+	if err := st.Archive(closed, ARCHIVE_CLOSED); err != nil {
+		return err
+	}
+	if n, err := countEntries(st.ForEachBySymbol, "XRP"); err != nil || n != 0 {
+		return fmt.Errorf("index of an archived entry: %d entries, %v", n, err)
+	}
+	if r, err := st.GetArchive(closed.Id()); err != nil || r.Reason != ARCHIVE_CLOSED || r.Win != 1.5 {
+		return fmt.Errorf("archived entry: %v, %v", r, err)
+	}
+
+	killed := NewEntry("dave", "LTC", 1, 20)
+	if err := st.Put(killed); err != nil {
+		return err
+	}
+	if _, err := st.Kill(killed); err != nil {
+		return err
+	}
+	if n, err := countEntries(st.ForEachBySymbol, "LTC"); err != nil || n != 0 {
+		return fmt.Errorf("index of a killed entry: %d entries, %v", n, err)
+	}
+	if e, err := st.Revive(killed.Id()); err != nil || e.Id() != killed.Id() {
+		return fmt.Errorf("revived entry: %v", err)
+	}
+	if n, err := countEntries(st.ForEachBySymbol, "LTC"); err != nil || n != 1 {
+		return fmt.Errorf("index of a revived entry: %d entries, %v", n, err)
+	}
+	if _, err := st.Kill(killed); err != nil {
+		return err
+	}
+	if err := st.ArchiveKilled(killed.Id()); err != nil {
+		return err
+	}
+	if _, err := st.Revive(killed.Id()); err != ErrNotFound {
+		return fmt.Errorf("archived killed entry: want ErrNotFound, got %v", err)
+	}
+
+	if err := st.PutTrader(&TraderRecord{Name: "bob"}); err != nil {
+		return err
+	}
+	es, err := st.Walk()
+	if err != nil {
+		return err
+	}
+	if len(es) != 1 || es[0].Id() != e.Id() {
+		return fmt.Errorf("walk returned %d entries", len(es))
+	}
+	if n, ok := es[0].StateOf("check").Int("n"); !ok || n != 1 {
+		return fmt.Errorf("state was not kept")
+	}
+	return st.Close()
+}
+
+//checkReopen checks what the checks before left.
+func checkReopen(b Backend) error {
+	if err := expectValue(b, "x/a", "2"); err != nil {
+		return err
+	}
+	if _, err := b.Get([]byte("x/b")); err != ErrNotFound {
+		return fmt.Errorf("deleted key is back: %v", err)
+	}
+
+	st, err := NewStorage(b, "conformance", false)
+	if err != nil {
+		return err
+	}
+	if n, err := countEntries(st.ForEachByTrader, "bob"); err != nil || n != 1 {
+		return fmt.Errorf("entry after reopen: %d entries, %v", n, err)
+	}
+	if _, err := st.GetTrader("bob"); err != nil {
+		return fmt.Errorf("trader after reopen: %s", err)
+	}
+	return nil
+}
+
+func countEntries(each func(string, func(*Entry) error) error, v string) (int, error) {
+	n := 0
+	err := each(v, func(*Entry) error {
+		n++
+		return nil
+	})
+	return n, err
+}
+
+func expectValue(b Backend, key string, want string) error {
+	got, err := b.Get([]byte(key))
+	if err != nil {
+		return fmt.Errorf("%s: %s", key, err)
+	}
+	if got == nil || string(got) != want {
+		return fmt.Errorf("%s: want '%s', got '%s'", key, want, got)
+	}
+	return nil
+}
+
+//TestLogBackendCutBack checks a record torn by a failed Write is cut off,
+//so that the next records are replayed after reopening.
+func TestLogBackendCutBack(t *testing.T) {
+	dir, err := ioutil.TempDir("", "miniquet2-log-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "log")
+
+	lb, err := OpenLogBackend(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := NewBatch()
+	batch.Put([]byte("x/a"), []byte("1"))
+	if err := lb.Write(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	off, err := lb.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lb.f.Write([]byte{0, 0, 1, 0, 0xde, 0xad}); err != nil {
+		t.Fatal(err)
+	}
+	if err := lb.cutBack(off, fmt.Errorf("disk full")); err == nil || err.Error() != "disk full" {
+		t.Fatalf("cutBack returned %v", err)
+	}
+
+	batch.Reset()
+	batch.Put([]byte("x/b"), []byte("2"))
+	if err := lb.Write(batch); err != nil {
+		t.Fatal(err)
+	}
+	lb.Close()
+
+	lb, err = OpenLogBackend(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lb.Close()
+	for k, want := range map[string]string{"x/a": "1", "x/b": "2"} {
+		if err := expectValue(lb, k, want); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+//slowBackupBackend blocks Backup until release is closed.
+type slowBackupBackend struct {
+	*MemBackend
+	started chan struct{}
+	release chan struct{}
+	closed  bool
+}
+
+func (self *slowBackupBackend) Backup(path string) error {
+	close(self.started)
+	<-self.release
+	if self.closed {
+		return fmt.Errorf("backend is closed while backing up.")
+	}
+	return nil
+}
+
+func (self *slowBackupBackend) Close() error {
+	self.closed = true
+	return self.MemBackend.Close()
+}
+
+//TestBackupClose checks Close waits for a running backup.
+func TestBackupClose(t *testing.T) {
+	b := &slowBackupBackend{MemBackend: NewMemBackend(),
+			started: make(chan struct{}), release: make(chan struct{})}
+	st, err := NewStorage(b, "slow", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- st.Backup("unused")
+	}()
+	<-b.started
+
+	closed := make(chan struct{})
+	go func() {
+		st.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("Close did not wait for the backup.")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(b.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	<-closed
+}
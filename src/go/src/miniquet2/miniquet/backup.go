@@ -0,0 +1,177 @@
+package miniquet
+
+import (
+	"os"
+	"fmt"
+	"sort"
+	"time"
+	"strings"
+	"io/ioutil"
+	"path/filepath"
+)
+
+const (
+	FmtBackupTime string = "20060102-150405"
+
+	//BACKUP_MANUAL is taken by a command, BACKUP_AUTO by the schedule.
+	//Only BACKUP_AUTO is pruned. BACKUP_BROKEN is a copy of the files
+	//before a recovery.
+	BACKUP_MANUAL string = "backup"
+	BACKUP_AUTO   string = "auto"
+	BACKUP_BROKEN string = "broken"
+
+	DefaultBackupKeep int = 7
+	MinBackupInterval time.Duration = 1 * time.Minute
+)
+
+//BackupConfig schedules the backups of the storage. No backup is taken
+//when Interval is empty.
+type BackupConfig struct {
+	Dir      string
+	Interval string
+	Keep     int
+}
+
+//Every returns Interval, or 0 when backups are not scheduled.
+func (self *BackupConfig) Every() time.Duration {
+	d, err := time.ParseDuration(self.Interval)
+	if err != nil || d < MinBackupInterval {
+		return 0
+	}
+	return d
+}
+
+//KeepCount returns Keep, or DefaultBackupKeep when it is not set.
+func (self *BackupConfig) KeepCount() int {
+	if self.Keep < 1 {
+		return DefaultBackupKeep
+	}
+	return self.Keep
+}
+
+//BackupDir returns Dir, or the directory of the storage.
+func (self *BackupConfig) BackupDir(s_path string) string {
+	if self.Dir == "" {
+		return filepath.Dir(filepath.Clean(s_path))
+	}
+	return filepath.Clean(self.Dir)
+}
+
+func backupPrefix(s_path string, kind string) string {
+	return filepath.Base(filepath.Clean(s_path)) + "." + kind + "-"
+}
+
+//BackupPath returns a path for a new backup of the storage at s_path,
+//'<dir>/<storage name>.<kind>-<time>'. A number is appended when the
+//path exists.
+func BackupPath(dir string, s_path string, kind string, t time.Time) string {
+	base := filepath.Join(dir, backupPrefix(s_path, kind) + t.Format(FmtBackupTime))
+	path := base
+	for i := 1; ; i++ {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return path
+		}
+		path = fmt.Sprintf("%s.%d", base, i)
+	}
+}
+
+//ListBackups returns the backups of the kind in dir, the oldest first.
+func ListBackups(dir string, s_path string, kind string) ([]string, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := backupPrefix(s_path, kind)
+	paths := []string{}
+	for _, fi := range fis {
+		if strings.HasPrefix(fi.Name(), prefix) {
+			paths = append(paths, filepath.Join(dir, fi.Name()))
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+//PruneBackups removes the oldest backups of the kind over keep, and
+//returns the removed paths.
+func PruneBackups(dir string, s_path string, kind string, keep int) ([]string, error) {
+	paths, err := ListBackups(dir, s_path, kind)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) <= keep {
+		return nil, nil
+	}
+
+	removed := []string{}
+	for _, path := range paths[:len(paths) - keep] {
+		if err := os.RemoveAll(path); err != nil {
+			return removed, err
+		}
+		removed = append(removed, path)
+	}
+	return removed, nil
+}
+
+//StorageStats is the usage of a storage. Size is the bytes on disk, and
+//Bytes of a namespace is the bytes of its keys and values.
+type StorageStats struct {
+	Name       string            `json:"name"`
+	Size       int64             `json:"size"`
+	Namespaces []*NamespaceStats `json:"namespaces"`
+}
+
+type NamespaceStats struct {
+	Name  string `json:"name"`
+	Keys  int    `json:"keys"`
+	Bytes int64  `json:"bytes"`
+}
+
+//NS_OTHER counts the keys out of the namespaces.
+const NS_OTHER string = "other"
+
+func newStorageStats(name string) *StorageStats {
+	stats := &StorageStats{Name: name, Namespaces: []*NamespaceStats{}}
+	for _, ns := range Namespaces {
+		stats.Namespaces = append(stats.Namespaces, &NamespaceStats{Name: ns})
+	}
+	stats.Namespaces = append(stats.Namespaces, &NamespaceStats{Name: NS_OTHER})
+	return stats
+}
+
+func (self *StorageStats) add(key string, n int) {
+	var ns_stats *NamespaceStats
+	for _, ns := range self.Namespaces {
+		if ns.Name == NS_OTHER || strings.HasPrefix(key, ns.Name) {
+			ns_stats = ns
+			break
+		}
+	}
+	ns_stats.Keys++
+	ns_stats.Bytes += int64(n)
+}
+
+//Keys returns the number of all the keys.
+func (self *StorageStats) Keys() int {
+	n := 0
+	for _, ns := range self.Namespaces {
+		n += ns.Keys
+	}
+	return n
+}
+
+//FormatBytes formats n in B, KiB, MiB or GiB.
+func FormatBytes(n int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB"}
+	f := float64(n)
+	i := 0
+	for f >= 1024 && i < len(units) - 1 {
+		f /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d %s", n, units[i])
+	}
+	return fmt.Sprintf("%.1f %s", f, units[i])
+}
@@ -0,0 +1,172 @@
+package miniquet
+
+import (
+	"os"
+	"time"
+	"testing"
+	"io/ioutil"
+	"path/filepath"
+)
+
+func nsStats(stats *StorageStats, name string) *NamespaceStats {
+	for _, ns := range stats.Namespaces {
+		if ns.Name == name {
+			return ns
+		}
+	}
+	return nil
+}
+
+//TestBackupCompactStats takes a backup of a log storage with overwritten
+//and deleted entries, compacts it, and checks the stats before and after.
+func TestBackupCompactStats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "miniquet2-backup-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "log")
+
+	st, err := OpenStorageWith(STORAGE_LOG, path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	es := testEntries()
+	for i := 0; i < 5; i++ {
+		es[0].Win = float64(i)
+		if err := st.Put(es[0]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := st.Put(es[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Delete(es[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := st.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ns := nsStats(stats, NS_ENTRIES); ns.Keys != 1 || ns.Bytes < 1 {
+		t.Fatalf("entries are %+v", ns)
+	}
+	if ns := nsStats(stats, NS_INDEX); ns.Keys != 2 {
+		t.Fatalf("indexes are %+v", ns)
+	}
+	if ns := nsStats(stats, NS_OTHER); ns.Keys != 0 {
+		t.Fatalf("other keys are %+v", ns)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Size != fi.Size() {
+		t.Fatalf("size is %d, the file is %d", stats.Size, fi.Size())
+	}
+
+	bk_path := BackupPath(dir, path, BACKUP_MANUAL, time.Now())
+	if err := st.Backup(bk_path); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Backup(bk_path); err == nil {
+		t.Fatal("backup overwrote a file.")
+	}
+	bk, err := OpenStorageWith(STORAGE_LOG, bk_path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := bk.Get(es[0].Id())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameEntry(t, es[0], got)
+	if _, err := bk.Get(es[1].Id()); err != ErrNotFound {
+		t.Fatalf("deleted entry is in the backup: %v", err)
+	}
+	bk_stats, err := bk.Stats()
+	bk.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bk_stats.Keys() != stats.Keys() || bk_stats.Size >= stats.Size {
+		t.Fatalf("backup has %d keys in %d bytes", bk_stats.Keys(), bk_stats.Size)
+	}
+
+	if err := st.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	compacted, err := st.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if compacted.Keys() != stats.Keys() || compacted.Size >= stats.Size {
+		t.Fatalf("compacted to %d keys in %d bytes", compacted.Keys(), compacted.Size)
+	}
+	got, err = st.Get(es[0].Id())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameEntry(t, es[0], got)
+
+	//the compacted log is written again, and read after reopening.
+	if err := st.Put(es[1]); err != nil {
+		t.Fatal(err)
+	}
+	st.Close()
+	st, err = OpenStorageWith(STORAGE_LOG, path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range es {
+		got, err := st.Get(want.Id())
+		if err != nil {
+			t.Fatal(err)
+		}
+		sameEntry(t, want, got)
+	}
+}
+
+//TestPruneBackups keeps the newest backups of a kind, and leaves the
+//other kinds.
+func TestPruneBackups(t *testing.T) {
+	dir, err := ioutil.TempDir("", "miniquet2-backup-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "log")
+
+	at := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	paths := []string{}
+	for i := 0; i < 3; i++ {
+		paths = append(paths, BackupPath(dir, path, BACKUP_AUTO, at.Add(time.Duration(i) * time.Hour)))
+	}
+	paths = append(paths, BackupPath(dir, path, BACKUP_MANUAL, at))
+	for _, p := range paths {
+		if err := ioutil.WriteFile(p, []byte{}, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if again := BackupPath(dir, path, BACKUP_AUTO, at); again != paths[0] + ".1" {
+		t.Fatalf("path of a taken time is %s", again)
+	}
+
+	removed, err := PruneBackups(dir, path, BACKUP_AUTO, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0] != paths[0] {
+		t.Fatalf("removed %v", removed)
+	}
+	autos, _ := ListBackups(dir, path, BACKUP_AUTO)
+	if len(autos) != 2 || autos[0] != paths[1] {
+		t.Fatalf("left %v", autos)
+	}
+	if manuals, _ := ListBackups(dir, path, BACKUP_MANUAL); len(manuals) != 1 {
+		t.Fatalf("manual backups are %v", manuals)
+	}
+}
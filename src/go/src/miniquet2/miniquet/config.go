@@ -1,6 +1,18 @@
 package miniquet
 
 import (
+	"os"
+	"os/user"
+	"fmt"
+	"net"
+	"time"
+	"sort"
+	"strconv"
+	"strings"
+	"reflect"
+	"runtime"
+	"unicode"
+	"io/ioutil"
 	"path/filepath"
 )
 
@@ -8,17 +20,648 @@ import (
 	"github.com/BurntSushi/toml"
 )
 
+const (
+	EnvPrefix string = "MINIQUET_"
+
+	DefaultTickInterval time.Duration = 1 * time.Second
+	MinTickInterval     time.Duration = 100 * time.Millisecond
+
+	DefaultKillGrace    time.Duration = 10 * time.Minute
+)
+
+var (
+	DefaultConfigOpt *ConfigOpt = &ConfigOpt{AllowInsecure: false, Passphrase: ReadPassphrase}
+)
+
 type Config struct {
 	ApiKey string
 	SecretKey string
+	SecretFile string
+
+	TickInterval string
+	//KillGrace is how long an entry stopped by kill9 can be revived.
+	KillGrace    string
+
+	Log      LogConfig
+	Metrics  MetricsConfig
+	Api      ApiConfig
+	Backup   BackupConfig
+	Trader   []TraderConfig
+	Notifier []NotifierConfig
+
+	path     string
+	warnings []string
+}
+
+type ConfigOpt struct {
+	//AllowInsecure loads a config holding secrets even if it is readable
+	//by other users.
+	AllowInsecure bool
+	//Passphrase unlocks the SecretFile. The argument is a prompt.
+	Passphrase func(string) ([]byte, error)
+	//SkipSecret loads a config without ApiKey and SecretKey, for a client
+	//which does not trade by itself.
+	SkipSecret bool
+	//SkipStrategy does not check the strategy of the traders is
+	//registered, for a program which does not run them.
+	SkipStrategy bool
+}
+
+//ConfigError points to the line of the config file which has a problem.
+//Line is 0 when the key is not written in the file.
+type ConfigError struct {
+	File string
+	Line int
+	Key  string
+	Msg  string
+}
+
+func (self *ConfigError) Error() string {
+	pos := self.File
+	if self.Line > 0 {
+		pos = fmt.Sprintf("%s:%d", self.File, self.Line)
+	}
+	if self.Key == "" {
+		return pos + ": " + self.Msg
+	}
+	return pos + ": " + self.Key + ": " + self.Msg
+}
+
+type ConfigErrors []*ConfigError
+
+func (self ConfigErrors) Error() string {
+	msgs := make([]string, 0, len(self))
+	for _, e := range self {
+		msgs = append(msgs, e.Error())
+	}
+	return strings.Join(msgs, "\n")
 }
 
 func LoadConfig(path string) (*Config, error) {
+	return LoadConfigWithOpt(path, nil)
+}
+
+//LoadConfigWithOpt reads the TOML config, applies MINIQUET_* environment
+//overrides and validates the result. Every problem found is returned at
+//once as ConfigErrors.
+func LoadConfigWithOpt(path string, opt *ConfigOpt) (*Config, error) {
+	if opt == nil {
+		opt = DefaultConfigOpt
+	}
 	fpath := filepath.Clean(path)
 
+	b, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		return nil, err
+	}
+
 	var conf Config
-	if _, err := toml.DecodeFile(fpath, &conf); err != nil {
+	md, err := toml.Decode(string(b), &conf)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", fpath, err)
+	}
+	conf.path = fpath
+
+	v := newConfigValidator(fpath, string(b))
+	for _, k := range md.Undecoded() {
+		v.Errorf(k.String(), "unknown key.")
+	}
+
+	conf.applyEnv(v)
+	v.skip_secret = opt.SkipSecret
+	v.skip_strategy = opt.SkipStrategy
+	if conf.SecretFile != "" && !opt.SkipSecret {
+		if md.IsDefined("ApiKey") || md.IsDefined("SecretKey") {
+			v.Errorf("SecretFile", "cannot be used with ApiKey or SecretKey in the same file.")
+		} else if err := conf.loadSecret(opt); err != nil {
+			v.Errorf("SecretFile", "%s", err)
+		}
+	}
+	conf.validate(v)
+
+	if len(v.errs) > 0 {
+		sort.SliceStable(v.errs, func(i, j int) bool { return v.errs[i].Line < v.errs[j].Line })
+		return nil, v.errs
+	}
+
+	if err := conf.checkPermission(md, opt); err != nil {
 		return nil, err
 	}
 	return &conf, nil
 }
+
+func (self *Config) Path() string {
+	return self.path
+}
+
+//Warnings returns problems which did not prevent loading.
+func (self *Config) Warnings() []string {
+	return self.warnings
+}
+
+//Tick returns TickInterval, the interval of fetching rates and running
+//the traders.
+func (self *Config) Tick() time.Duration {
+	d, err := time.ParseDuration(self.TickInterval)
+	if err != nil || d < MinTickInterval {
+		return DefaultTickInterval
+	}
+	return d
+}
+
+//Grace returns KillGrace, or DefaultKillGrace when it is not set.
+func (self *Config) Grace() time.Duration {
+	d, err := time.ParseDuration(self.KillGrace)
+	if err != nil || d < 0 {
+		return DefaultKillGrace
+	}
+	return d
+}
+
+//SecretPath returns the path of SecretFile. A relative path is relative
+//to the directory of the config.
+func (self *Config) SecretPath() string {
+	if self.SecretFile == "" {
+		return ""
+	}
+
+	path := self.SecretFile
+	if strings.HasPrefix(path, "~/") {
+		if usr, err := user.Current(); err == nil {
+			path = filepath.Join(usr.HomeDir, path[2:])
+		}
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(self.path), path)
+	}
+	return filepath.Clean(path)
+}
+
+//loadSecret fills ApiKey and SecretKey from the encrypted SecretFile.
+//Values given by the environment are kept.
+func (self *Config) loadSecret(opt *ConfigOpt) error {
+	if self.ApiKey != "" && self.SecretKey != "" {
+		return nil
+	}
+	if opt.Passphrase == nil {
+		return fmt.Errorf("no way to get the passphrase.")
+	}
+
+	path := self.SecretPath()
+	pass, err := opt.Passphrase(fmt.Sprintf("passphrase for %s: ", path))
+	if err != nil {
+		return err
+	}
+
+	s, err := ReadSecretFile(path, pass)
+	if err != nil {
+		return err
+	}
+
+	if self.ApiKey == "" {
+		self.ApiKey = s.ApiKey
+	}
+	if self.SecretKey == "" {
+		self.SecretKey = s.SecretKey
+	}
+	return nil
+}
+
+//applyEnv overrides values with MINIQUET_<NAME> environment variables.
+//ApiKey is read from MINIQUET_API_KEY, and Listen of [Api] from
+//MINIQUET_API_LISTEN. Arrays of tables cannot be overridden, and an
+//unknown MINIQUET_* variable is warned, so that a typo is not ignored.
+func (self *Config) applyEnv(v *configValidator) {
+	fields := make(map[string]string)
+	envFields(reflect.TypeOf(*self), "", fields)
+
+	names := []string{}
+	for _, kv := range os.Environ() {
+		name := strings.SplitN(kv, "=", 2)[0]
+		if strings.HasPrefix(name, EnvPrefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	rv := reflect.ValueOf(self).Elem()
+	for _, name := range names {
+		if name == EnvPassphrase || strings.HasPrefix(name, EnvNoticePrefix) {
+			continue
+		}
+		key, ok := fields[name]
+		if !ok {
+			self.warnings = append(self.warnings, fmt.Sprintf("%s is not a key of the config, ignored.", name))
+			continue
+		}
+		fv := rv
+		for _, f := range strings.Split(key, ".") {
+			fv = fv.FieldByName(f)
+		}
+		if err := setEnvValue(fv, os.Getenv(name)); err != nil {
+			v.Errorf(key, "%s: %s", name, err)
+		}
+	}
+}
+
+//envFields maps the environment variables to the keys of the values and
+//tables which can be overridden.
+func envFields(rt reflect.Type, prefix string, fields map[string]string) {
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		key := prefix + f.Name
+		switch f.Type.Kind() {
+		case reflect.Struct:
+			envFields(f.Type, key + ".", fields)
+		case reflect.String, reflect.Int, reflect.Int64, reflect.Float64, reflect.Bool:
+			fields[EnvName(key)] = key
+		}
+	}
+}
+
+func setEnvValue(fv reflect.Value, val string) error {
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(val)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(n)
+	case reflect.Bool:
+		n, err := strconv.ParseBool(val)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(n)
+	}
+	return nil
+}
+
+//EnvName returns the environment variable overriding a config key. A key
+//of a table is written as "Api.Listen".
+func EnvName(key string) string {
+	rs := []rune(key)
+	name := []rune(EnvPrefix)
+	for i, r := range rs {
+		if r == '.' {
+			name = append(name, '_')
+			continue
+		}
+		if i > 0 && unicode.IsUpper(r) && unicode.IsLower(rs[i - 1]) {
+			name = append(name, '_')
+		}
+		name = append(name, unicode.ToUpper(r))
+	}
+	return string(name)
+}
+
+func (self *Config) validate(v *configValidator) {
+	if !v.skip_secret {
+		self.validateSecret(v)
+	}
+
+	if self.TickInterval != "" {
+		d, err := time.ParseDuration(self.TickInterval)
+		if err != nil {
+			v.Errorf("TickInterval", "%s", err)
+		} else if d < MinTickInterval {
+			v.Errorf("TickInterval", "is shorter than %s.", MinTickInterval)
+		}
+	}
+	if self.KillGrace != "" {
+		d, err := time.ParseDuration(self.KillGrace)
+		if err != nil {
+			v.Errorf("KillGrace", "%s", err)
+		} else if d < 0 {
+			v.Errorf("KillGrace", "is negative.")
+		}
+	}
+
+	if _, err := ParseLevel(self.Log.Level); err != nil {
+		v.Errorf("Log.Level", "%s", err)
+	}
+	if self.Log.MaxAge != "" {
+		if _, err := time.ParseDuration(self.Log.MaxAge); err != nil {
+			v.Errorf("Log.MaxAge", "%s", err)
+		}
+	}
+	if self.Log.MaxSizeMB < 0 {
+		v.Errorf("Log.MaxSizeMB", "is negative.")
+	}
+	if self.Log.MaxBackups < 0 {
+		v.Errorf("Log.MaxBackups", "is negative.")
+	}
+
+	if self.Api.Listen != "" {
+		if !strings.HasPrefix(self.Api.Listen, UnixPrefix) {
+			if err := CheckLoopback(self.Api.Listen); err != nil {
+				v.Errorf("Api.Listen", "%s", err)
+			}
+		}
+		if self.Api.Token == "" && self.Api.TokenFile == "" {
+			v.Errorf("Api.Token", "is empty. set Token or TokenFile.")
+		}
+		if self.Api.Token != "" && self.Api.TokenFile != "" {
+			v.Errorf("Api.TokenFile", "cannot be used with Token.")
+		}
+	}
+
+	if self.Backup.Interval != "" {
+		d, err := time.ParseDuration(self.Backup.Interval)
+		if err != nil {
+			v.Errorf("Backup.Interval", "%s", err)
+		} else if d < MinBackupInterval {
+			v.Errorf("Backup.Interval", "is shorter than %s.", MinBackupInterval)
+		}
+	}
+	if self.Backup.Keep < 0 {
+		v.Errorf("Backup.Keep", "is negative.")
+	}
+
+	if self.Metrics.Listen != "" {
+		if _, _, err := net.SplitHostPort(self.Metrics.Listen); err != nil {
+			v.Errorf("Metrics.Listen", "%s", err)
+		}
+	}
+
+	t_names := make(map[string]bool)
+	for i := range self.Trader {
+		c := &self.Trader[i]
+		key := fmt.Sprintf("Trader.%d", i)
+
+		if c.Name == "" {
+			v.Errorf(key + ".Name", "is empty.")
+		} else if t_names[c.Name] {
+			v.Errorf(key + ".Name", "'%s' is defined twice.", c.Name)
+		}
+		t_names[c.Name] = true
+
+		if c.Strategy == "" {
+			v.Errorf(key + ".Strategy", "is empty. one of %s", StrategyIds())
+		} else if !v.skip_strategy {
+			if _, err := NewStrategy(c.Strategy, c.Params); err != nil {
+				v.Errorf(key + ".Strategy", "%s", err)
+			}
+		}
+
+		for _, sym := range c.Symbols {
+			if sym == "" {
+				v.Errorf(key + ".Symbols", "has an empty symbol.")
+			}
+		}
+		if c.Limits.MaxEntries < 0 {
+			v.Errorf(key + ".Limits.MaxEntries", "is negative.")
+		}
+		if c.Limits.MaxSize < 0 {
+			v.Errorf(key + ".Limits.MaxSize", "is negative.")
+		}
+		if c.Limits.MaxTotalSize < 0 {
+			v.Errorf(key + ".Limits.MaxTotalSize", "is negative.")
+		}
+	}
+
+	n_names := make(map[string]bool)
+	for i := range self.Notifier {
+		c := &self.Notifier[i]
+		key := fmt.Sprintf("Notifier.%d", i)
+
+		if c.Name == "" {
+			v.Errorf(key + ".Name", "is empty.")
+			continue
+		}
+		if n_names[c.Name] {
+			v.Errorf(key + ".Name", "'%s' is defined twice.", c.Name)
+		}
+		n_names[c.Name] = true
+
+		if _, err := newNoticeFilter(c); err != nil {
+			v.Errorf(key + ".Events", "%s", err)
+		}
+		if c.RateLimit < 0 {
+			v.Errorf(key + ".RateLimit", "is negative.")
+		}
+		if c.RatePeriod != "" {
+			if _, err := time.ParseDuration(c.RatePeriod); err != nil {
+				v.Errorf(key + ".RatePeriod", "%s", err)
+			}
+		}
+
+		n, err := NewNotifier(c)
+		if err != nil {
+			v.Errorf(key + ".Type", "%s", err)
+			continue
+		}
+		n.Close()
+	}
+}
+
+func (self *Config) validateSecret(v *configValidator) {
+	if self.SecretFile != "" {
+		if self.ApiKey == "" || self.SecretKey == "" {
+			v.Errorf("SecretFile", "does not have ApiKey and SecretKey.")
+		}
+		return
+	}
+
+	if self.ApiKey == "" {
+		v.Errorf("ApiKey", "is empty. set it in the file or %s.", EnvName("ApiKey"))
+	}
+	if self.SecretKey == "" {
+		v.Errorf("SecretKey", "is empty. set it in the file or %s.", EnvName("SecretKey"))
+	}
+}
+
+//checkPermission refuses a config holding secrets which other users can
+//read, and warns when its group can read it.
+func (self *Config) checkPermission(md toml.MetaData, opt *ConfigOpt) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	if !self.hasSecret(md) {
+		return nil
+	}
+
+	fi, err := os.Stat(self.path)
+	if err != nil {
+		return err
+	}
+
+	mode := fi.Mode().Perm()
+	if mode & 0004 != 0 {
+		msg := fmt.Sprintf("%s holds secrets and is readable by other users (%s). run 'chmod 600 %s'.",
+													self.path, mode, self.path)
+		if !opt.AllowInsecure {
+			return fmt.Errorf("%s", msg)
+		}
+		self.warnings = append(self.warnings, msg)
+		return nil
+	}
+	if mode & 0040 != 0 {
+		self.warnings = append(self.warnings,
+			fmt.Sprintf("%s holds secrets and is readable by its group (%s).", self.path, mode))
+	}
+	return nil
+}
+
+func (self *Config) hasSecret(md toml.MetaData) bool {
+	if md.IsDefined("ApiKey") || md.IsDefined("SecretKey") || self.Api.Token != "" {
+		return true
+	}
+	for _, c := range self.Notifier {
+		if c.SmtpPassword != "" || c.ConsumerSecret != "" || c.AccessSecret != "" {
+			return true
+		}
+	}
+	return false
+}
+
+type configValidator struct {
+	file  string
+	lines map[string]int
+	errs  ConfigErrors
+
+	skip_secret   bool
+	skip_strategy bool
+}
+
+func newConfigValidator(file string, body string) *configValidator {
+	return &configValidator{
+		file: file,
+		lines: keyLines(body),
+		errs: ConfigErrors{},
+	}
+}
+
+func (self *configValidator) Errorf(key string, s string, msg ...interface{}) {
+	self.errs = append(self.errs, &ConfigError{
+		File: self.file,
+		Line: self.line(key),
+		Key: key,
+		Msg: fmt.Sprintf(s, msg...),
+	})
+}
+
+//line finds the line of a key. Keys of array tables carry the index of
+//the table, as "Trader.1.Name". Without the index, the first one is used.
+func (self *configValidator) line(key string) int {
+	if key == "" {
+		return 0
+	}
+	if l, ok := self.lines[key]; ok {
+		return l
+	}
+
+	found := 0
+	for k, l := range self.lines {
+		if stripIndex(k) != key {
+			continue
+		}
+		if found == 0 || l < found {
+			found = l
+		}
+	}
+	if found != 0 {
+		return found
+	}
+
+	if i := strings.LastIndex(key, "."); i > 0 {
+		return self.line(key[:i])
+	}
+	return 0
+}
+
+func stripIndex(key string) string {
+	parts := []string{}
+	for _, p := range strings.Split(key, ".") {
+		if _, err := strconv.Atoi(p); err == nil {
+			continue
+		}
+		parts = append(parts, p)
+	}
+	return strings.Join(parts, ".")
+}
+
+//keyLines scans a TOML document for the line of each key and table.
+//It only understands what this config uses: tables, arrays of tables and
+//'key = value' lines.
+func keyLines(body string) map[string]int {
+	lines := make(map[string]int)
+	arrays := make(map[string]int)
+	table := ""
+
+	resolve := func(name string) string {
+		parts := strings.Split(name, ".")
+		path := ""
+		plain := ""
+		for _, p := range parts {
+			p = strings.Trim(strings.TrimSpace(p), "\"")
+			if plain != "" {
+				plain += "."
+				path += "."
+			}
+			plain += p
+			path += p
+			if n, ok := arrays[plain]; ok {
+				path += "." + strconv.Itoa(n - 1)
+			}
+		}
+		return path
+	}
+
+	for i, l := range strings.Split(body, "\n") {
+		l = strings.TrimSpace(l)
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(l, "[[") {
+			end := strings.Index(l, "]]")
+			if end < 0 {
+				continue
+			}
+			name := strings.TrimSpace(l[2:end])
+			arrays[name]++
+			table = resolve(name)
+			if _, ok := lines[table]; !ok {
+				lines[table] = i + 1
+			}
+			continue
+		}
+		if strings.HasPrefix(l, "[") {
+			end := strings.Index(l, "]")
+			if end < 0 {
+				continue
+			}
+			table = resolve(strings.TrimSpace(l[1:end]))
+			if _, ok := lines[table]; !ok {
+				lines[table] = i + 1
+			}
+			continue
+		}
+
+		eq := strings.Index(l, "=")
+		if eq < 1 {
+			continue
+		}
+		key := strings.Trim(strings.TrimSpace(l[:eq]), "\"")
+		if table != "" {
+			key = table + "." + key
+		}
+		if _, ok := lines[key]; !ok {
+			lines[key] = i + 1
+		}
+	}
+	return lines
+}
@@ -0,0 +1,209 @@
+package miniquet
+
+import (
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"io/ioutil"
+	"path/filepath"
+)
+
+const testConfig string = `ApiKey = "key"
+SecretKey = "secret"
+TickInterval = "2s"
+
+[Log]
+Level = "info"
+
+[[Trader]]
+Name = "alice"
+Strategy = "point"
+Params = { Threshold = 0.2 }
+Symbols = ["BTC"]
+`
+
+func writeConfig(t *testing.T, body string) string {
+	dir, err := ioutil.TempDir("", "miniquet2-config-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "config")
+	if err := ioutil.WriteFile(path, []byte(body), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := writeConfig(t, testConfig)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	conf, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.ApiKey != "key" || conf.Tick().String() != "2s" || len(conf.Trader) != 1 {
+		t.Fatalf("unexpected config: %+v", conf)
+	}
+}
+
+//TestConfigErrors checks every problem is reported at once, with the
+//line of its key.
+func TestConfigErrors(t *testing.T) {
+	body := strings.Replace(testConfig, `TickInterval = "2s"`, `TickInterval = "1ms"`, 1)
+	body += `Unknown = 1
+
+[[Trader]]
+Name = "alice"
+Strategy = "nobody"
+`
+	path := writeConfig(t, body)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	_, err := LoadConfig(path)
+	errs, ok := err.(ConfigErrors)
+	if !ok {
+		t.Fatalf("want ConfigErrors, got %v", err)
+	}
+
+	want := map[string]int{
+		"TickInterval": 3,
+		"Trader.Unknown": 13,
+		"Trader.1.Name": 16,
+		"Trader.1.Strategy": 17,
+	}
+	for _, e := range errs {
+		l, ok := want[e.Key]
+		if !ok {
+			t.Errorf("unexpected error: %s", e)
+			continue
+		}
+		if e.Line != l {
+			t.Errorf("%s: want line %d, got %d", e.Key, l, e.Line)
+		}
+		delete(want, e.Key)
+	}
+	for k := range want {
+		t.Errorf("%s is not reported.", k)
+	}
+}
+
+func TestConfigSkipStrategy(t *testing.T) {
+	body := strings.Replace(testConfig, `Strategy = "point"`, `Strategy = "alice"`, 1)
+	path := writeConfig(t, body)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	if _, err := LoadConfig(path); err == nil {
+		t.Fatal("unregistered strategy is accepted.")
+	}
+
+	opt := *DefaultConfigOpt
+	opt.SkipStrategy = true
+	if _, err := LoadConfigWithOpt(path, &opt); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfigEnv(t *testing.T) {
+	path := writeConfig(t, testConfig)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	env := map[string]string{
+		"MINIQUET_API_KEY": "env-key",
+		"MINIQUET_LOG_LEVEL": "debug",
+		"MINIQUET_LOG_MAX_SIZE_MB": "5",
+		"MINIQUET_BACKUP_DIR": "/tmp/backup",
+	}
+	for k, v := range env {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+
+	conf, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.ApiKey != "env-key" || conf.Log.Level != "debug" ||
+				conf.Log.MaxSizeMB != 5 || conf.Backup.Dir != "/tmp/backup" {
+		t.Fatalf("environment is not applied: %+v", conf)
+	}
+
+	os.Setenv("MINIQUET_TRADER_NAME", "bob")
+	defer os.Unsetenv("MINIQUET_TRADER_NAME")
+	os.Setenv("MINIQUET_LOG_MAX_BACKUPS", "x")
+	defer os.Unsetenv("MINIQUET_LOG_MAX_BACKUPS")
+
+	_, err = LoadConfig(path)
+	errs, ok := err.(ConfigErrors)
+	if !ok || len(errs) != 1 || !strings.Contains(errs.Error(), "MINIQUET_LOG_MAX_BACKUPS") {
+		t.Fatalf("want the error of MINIQUET_LOG_MAX_BACKUPS, got %v", err)
+	}
+
+	//an unknown variable is warned, and does not stop the load.
+	os.Unsetenv("MINIQUET_LOG_MAX_BACKUPS")
+	conf, err = LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ws := conf.Warnings(); len(ws) != 1 || !strings.Contains(ws[0], "MINIQUET_TRADER_NAME is not a key") {
+		t.Fatalf("unknown variable is not warned: %q", ws)
+	}
+}
+
+//TestConfigPermission checks a config holding secrets is refused when
+//other users can read it, and warned when its group can.
+func TestConfigPermission(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permissions are not checked on windows.")
+	}
+	path := writeConfig(t, testConfig)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	insecure := *DefaultConfigOpt
+	insecure.AllowInsecure = true
+	for _, c := range []struct {
+		mode     os.FileMode
+		refused  bool
+		warnings int
+	}{
+		{0600, false, 0},
+		{0604, true, 1},
+		{0640, false, 1},
+		{0620, false, 0},
+		{0602, false, 0},
+	} {
+		if err := os.Chmod(path, c.mode); err != nil {
+			t.Fatal(err)
+		}
+
+		conf, err := LoadConfig(path)
+		if (err != nil) != c.refused {
+			t.Fatalf("%s: refused is %v, want %v", c.mode, err, c.refused)
+		}
+		if !c.refused && len(conf.Warnings()) != c.warnings {
+			t.Fatalf("%s: warnings are %q", c.mode, conf.Warnings())
+		}
+
+		conf, err = LoadConfigWithOpt(path, &insecure)
+		if err != nil {
+			t.Fatalf("%s: %s", c.mode, err)
+		}
+		if len(conf.Warnings()) != c.warnings {
+			t.Fatalf("%s: warnings are %q", c.mode, conf.Warnings())
+		}
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	for key, want := range map[string]string{
+		"ApiKey": "MINIQUET_API_KEY",
+		"Api.TokenFile": "MINIQUET_API_TOKEN_FILE",
+		"Log.MaxSizeMB": "MINIQUET_LOG_MAX_SIZE_MB",
+	} {
+		if got := EnvName(key); got != want {
+			t.Errorf("%s: want %s, got %s", key, want, got)
+		}
+	}
+}
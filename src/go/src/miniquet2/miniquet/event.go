@@ -0,0 +1,337 @@
+package miniquet
+
+import (
+	"sync"
+	"time"
+)
+
+import (
+	"github.com/vouquet/shop"
+	"github.com/vouquet/go-gmo-coin/gomocoin"
+)
+
+const (
+	EventEntryAdded     EventKind = "entry-added"
+	EventOrderSubmitted EventKind = "order-submitted"
+	EventOrderFilled    EventKind = "order-filled"
+	EventEntryTurned    EventKind = "entry-turned"
+	EventEntryStopped   EventKind = "entry-stopped"
+	EventEntryKilled    EventKind = "entry-killed"
+	EventEntryClosed    EventKind = "entry-closed"
+	EventEntryRevived   EventKind = "entry-revived"
+	EventTradeFailed    EventKind = "trade-failed"
+	EventRateUpdated    EventKind = "rate-updated"
+	EventRiskBreach     EventKind = "risk-breach"
+
+	//DropNewest discards the incoming event when the buffer is full.
+	DropNewest OverflowPolicy = 0
+	//DropOldest discards the oldest buffered event to make room.
+	DropOldest OverflowPolicy = 1
+
+	SIZE_EVENT_BUFFER int = 64
+)
+
+type EventKind string
+
+type OverflowPolicy uint8
+
+type Event interface {
+	Kind() EventKind
+	When() time.Time
+}
+
+type EntryEvent struct {
+	At       time.Time
+	Trader   string
+	EntryId  string
+	Symbol   string
+	Position string
+	Size     float64
+	Rate     float64
+	Win      float64
+}
+
+func newEntryEvent(trader string, entry *Entry) EntryEvent {
+	return EntryEvent{
+		At: time.Now(),
+		Trader: trader,
+		EntryId: entry.Id(),
+		Symbol: entry.Symbol,
+		Position: entry.Position,
+		Size: entry.Size,
+		Rate: entry.LastRate(),
+		Win: entry.Win,
+	}
+}
+
+func (self *EntryEvent) When() time.Time {
+	return self.At
+}
+
+type EntryAdded struct {
+	EntryEvent
+}
+
+func (self *EntryAdded) Kind() EventKind {
+	return EventEntryAdded
+}
+
+type OrderSubmitted struct {
+	EntryEvent
+}
+
+func (self *OrderSubmitted) Kind() EventKind {
+	return EventOrderSubmitted
+}
+
+type OrderFilled struct {
+	EntryEvent
+	OrderId string
+}
+
+func (self *OrderFilled) Kind() EventKind {
+	return EventOrderFilled
+}
+
+type EntryTurned struct {
+	EntryEvent
+}
+
+func (self *EntryTurned) Kind() EventKind {
+	return EventEntryTurned
+}
+
+type EntryStopped struct {
+	EntryEvent
+}
+
+func (self *EntryStopped) Kind() EventKind {
+	return EventEntryStopped
+}
+
+type EntryKilled struct {
+	EntryEvent
+}
+
+func (self *EntryKilled) Kind() EventKind {
+	return EventEntryKilled
+}
+
+//EntryRevived is published when an entry stopped by kill9 is brought
+//back.
+type EntryRevived struct {
+	EntryEvent
+}
+
+func (self *EntryRevived) Kind() EventKind {
+	return EventEntryRevived
+}
+
+//EntryClosed is published when an entry finished its last run and is
+//archived.
+type EntryClosed struct {
+	EntryEvent
+}
+
+func (self *EntryClosed) Kind() EventKind {
+	return EventEntryClosed
+}
+
+type TradeFailed struct {
+	EntryEvent
+	Err error
+}
+
+func (self *TradeFailed) Kind() EventKind {
+	return EventTradeFailed
+}
+
+type RateUpdated struct {
+	At    time.Time
+	Rates map[string]shop.Rate
+}
+
+func NewRateUpdated(rates map[string]shop.Rate) *RateUpdated {
+	return &RateUpdated{At: time.Now(), Rates: rates}
+}
+
+func (self *RateUpdated) Kind() EventKind {
+	return EventRateUpdated
+}
+
+func (self *RateUpdated) When() time.Time {
+	return self.At
+}
+
+type RiskBreach struct {
+	At     time.Time
+	Trader string
+	Symbol string
+	Reason string
+}
+
+func (self *RiskBreach) Kind() EventKind {
+	return EventRiskBreach
+}
+
+func (self *RiskBreach) When() time.Time {
+	return self.At
+}
+
+//orderRate returns the rate an order of the entry is filled with.
+func orderRate(entry *Entry, ask float64, bid float64) float64 {
+	if entry.Position == gomocoin.SIDE_SELL {
+		return bid
+	}
+	return ask
+}
+
+//EventBus delivers events to every subscriber without blocking the
+//publisher. A subscriber that does not keep up loses events according to
+//its OverflowPolicy, and the loss is counted in Subscription.Dropped.
+type EventBus struct {
+	subs   map[*Subscription]struct{}
+	closed bool
+
+	mtx *sync.Mutex
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{
+		subs: make(map[*Subscription]struct{}),
+		mtx: new(sync.Mutex),
+	}
+}
+
+//Subscribe registers a subscriber with a buffer of size events. Without
+//kinds, every event is delivered.
+func (self *EventBus) Subscribe(size int, policy OverflowPolicy, kinds ...EventKind) *Subscription {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	if size < 1 {
+		size = SIZE_EVENT_BUFFER
+	}
+
+	sub := &Subscription{
+		bus: self,
+		ch: make(chan Event, size),
+		kinds: make(map[EventKind]bool),
+		policy: policy,
+		mtx: new(sync.Mutex),
+	}
+	for _, k := range kinds {
+		sub.kinds[k] = true
+	}
+
+	if self.closed {
+		sub.close()
+		return sub
+	}
+	self.subs[sub] = struct{}{}
+	return sub
+}
+
+func (self *EventBus) Publish(ev Event) {
+	if self == nil || ev == nil {
+		return
+	}
+
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	for sub, _ := range self.subs {
+		sub.deliver(ev)
+	}
+}
+
+func (self *EventBus) Close() {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	for sub, _ := range self.subs {
+		sub.close()
+	}
+	self.subs = make(map[*Subscription]struct{})
+	self.closed = true
+}
+
+func (self *EventBus) unsubscribe(sub *Subscription) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	if _, ok := self.subs[sub]; !ok {
+		return
+	}
+	delete(self.subs, sub)
+	sub.close()
+}
+
+type Subscription struct {
+	bus     *EventBus
+	ch      chan Event
+	kinds   map[EventKind]bool
+	policy  OverflowPolicy
+	dropped uint64
+	closed  bool
+
+	mtx *sync.Mutex
+}
+
+//C returns the channel of events. It is closed by Close or EventBus.Close.
+func (self *Subscription) C() <-chan Event {
+	return self.ch
+}
+
+func (self *Subscription) Dropped() uint64 {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	return self.dropped
+}
+
+func (self *Subscription) Close() {
+	self.bus.unsubscribe(self)
+}
+
+func (self *Subscription) deliver(ev Event) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	if self.closed {
+		return
+	}
+	if len(self.kinds) > 0 && !self.kinds[ev.Kind()] {
+		return
+	}
+
+	for {
+		select {
+		case self.ch <- ev:
+			return
+		default:
+		}
+
+		if self.policy != DropOldest {
+			self.dropped++
+			return
+		}
+
+		select {
+		case <- self.ch:
+			self.dropped++
+		default:
+		}
+	}
+}
+
+func (self *Subscription) close() {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	if self.closed {
+		return
+	}
+	self.closed = true
+	close(self.ch)
+}
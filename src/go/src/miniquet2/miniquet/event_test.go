@@ -0,0 +1,78 @@
+package miniquet
+
+import (
+	"fmt"
+	"testing"
+)
+
+//drainBreaches closes the bus and returns the reasons of the buffered
+//risk breaches in order.
+func drainBreaches(t *testing.T, bus *EventBus, sub *Subscription) []string {
+	bus.Close()
+
+	reasons := []string{}
+	for ev := range sub.C() {
+		b, ok := ev.(*RiskBreach)
+		if !ok {
+			t.Fatalf("unexpected event %s", ev.Kind())
+		}
+		reasons = append(reasons, b.Reason)
+	}
+	return reasons
+}
+
+//TestEventBusOverflow fills a small buffer, and checks which events survive
+//and the count of the dropped ones under each policy.
+func TestEventBusOverflow(t *testing.T) {
+	tests := []struct {
+		policy OverflowPolicy
+		want   string
+	}{
+		{DropNewest, "[r0 r1 r2]"},
+		{DropOldest, "[r2 r3 r4]"},
+	}
+	for _, tt := range tests {
+		bus := NewEventBus()
+		sub := bus.Subscribe(3, tt.policy)
+		for i := 0; i < 5; i++ {
+			bus.Publish(&RiskBreach{Reason: fmt.Sprintf("r%d", i)})
+		}
+		if n := sub.Dropped(); n != 2 {
+			t.Fatalf("policy %d: dropped %d", tt.policy, n)
+		}
+		if got := fmt.Sprint(drainBreaches(t, bus, sub)); got != tt.want {
+			t.Fatalf("policy %d: got %s, want %s", tt.policy, got, tt.want)
+		}
+	}
+}
+
+//TestEventBusKinds checks a subscriber gets only the kinds it asked for,
+//and the others are not counted as dropped.
+func TestEventBusKinds(t *testing.T) {
+	bus := NewEventBus()
+	sub := bus.Subscribe(2, DropNewest, EventRiskBreach)
+	all := bus.Subscribe(8, DropNewest)
+	defer all.Close()
+
+	for i := 0; i < 3; i++ {
+		bus.Publish(NewRateUpdated(nil))
+		bus.Publish(&RiskBreach{Reason: fmt.Sprintf("r%d", i)})
+	}
+	if n := sub.Dropped(); n != 1 {
+		t.Fatalf("dropped %d", n)
+	}
+	if n := all.Dropped(); n != 0 {
+		t.Fatalf("dropped %d without a filter", n)
+	}
+	if got := fmt.Sprint(drainBreaches(t, bus, sub)); got != "[r0 r1]" {
+		t.Fatalf("got %s", got)
+	}
+
+	n := 0
+	for range all.C() {
+		n++
+	}
+	if n != 6 {
+		t.Fatalf("%d events without a filter", n)
+	}
+}
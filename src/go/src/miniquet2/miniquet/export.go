@@ -0,0 +1,328 @@
+package miniquet
+
+import (
+	"io"
+	"fmt"
+	"time"
+	"bufio"
+	"bytes"
+	"strconv"
+	"strings"
+	"path/filepath"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/base64"
+)
+
+import (
+	"github.com/google/uuid"
+	"github.com/vouquet/go-gmo-coin/gomocoin"
+)
+
+const (
+	FORMAT_JSON string = "json"
+	FORMAT_CSV  string = "csv"
+
+	SIZE_EXPORT_LINE int = 1024 * 1024
+)
+
+var (
+	CsvEntryHeader []string = []string{
+		"id", "trader", "symbol", "position", "size", "win",
+		"last_rate", "last_date", "stopping", "state",
+	}
+)
+
+//ExportFormat returns the format given by name, or guessed from the
+//extension of path when name is empty. JSON Lines is the default.
+func ExportFormat(name string, path string) (string, error) {
+	if name == "" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".csv":
+			return FORMAT_CSV, nil
+		}
+		return FORMAT_JSON, nil
+	}
+
+	switch strings.ToLower(name) {
+	case FORMAT_JSON, "jsonl":
+		return FORMAT_JSON, nil
+	case FORMAT_CSV:
+		return FORMAT_CSV, nil
+	}
+	return "", fmt.Errorf("unknown format '%s', use json or csv.", name)
+}
+
+func WriteEntries(w io.Writer, format string, es []*Entry) error {
+	switch format {
+	case FORMAT_JSON:
+		return WriteEntriesJSON(w, es)
+	case FORMAT_CSV:
+		return WriteEntriesCSV(w, es)
+	}
+	return fmt.Errorf("unknown format '%s'.", format)
+}
+
+//ReadEntries reads the entries written by WriteEntries. Every entry is
+//validated, and an id found twice is an error.
+func ReadEntries(r io.Reader, format string) ([]*Entry, error) {
+	switch format {
+	case FORMAT_JSON:
+		return ReadEntriesJSON(r)
+	case FORMAT_CSV:
+		return ReadEntriesCSV(r)
+	}
+	return nil, fmt.Errorf("unknown format '%s'.", format)
+}
+
+//WriteEntriesJSON writes the entries as JSON Lines, one entry per line.
+func WriteEntriesJSON(w io.Writer, es []*Entry) error {
+	enc := json.NewEncoder(w)
+	for _, e := range es {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//ReadEntriesJSON reads the entries written by WriteEntriesJSON.
+func ReadEntriesJSON(r io.Reader) ([]*Entry, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64 * 1024), SIZE_EXPORT_LINE)
+
+	es := []*Entry{}
+	ids := make(map[string]int)
+	for line := 1; sc.Scan(); line++ {
+		b := bytes.TrimSpace(sc.Bytes())
+		if len(b) < 1 {
+			continue
+		}
+
+		e, err := unmarshalEntry(b)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+		if err := checkEntry(e, ids, line); err != nil {
+			return nil, err
+		}
+		es = append(es, e)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return es, nil
+}
+
+//unmarshalEntry decodes a line of JSON Lines, and upgrades an entry
+//exported by an older miniquet2.
+func unmarshalEntry(b []byte) (*Entry, error) {
+	var e Entry
+	if err := json.Unmarshal(b, &e); err != nil {
+		return nil, err
+	}
+	if e.Version == SCHEMA_VERSION {
+		return &e, nil
+	}
+	if e.Version > SCHEMA_VERSION {
+		return nil, fmt.Errorf("exported by a newer miniquet2 (schema version %d, supported %d).",
+										e.Version, SCHEMA_VERSION)
+	}
+
+	rec := make(map[string]interface{})
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return nil, err
+	}
+	//the byte buffers of schema 1 are base64 in JSON.
+	for _, k := range []string{"Gb03", "Gb04"} {
+		if s, ok := rec[k].(string); ok {
+			b, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %s", k, err)
+			}
+			rec[k] = b
+		}
+	}
+	if err := upgradeRecord(rec, e.Version); err != nil {
+		return nil, err
+	}
+	up, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+
+	var u Entry
+	if err := json.Unmarshal(up, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+//WriteEntriesCSV writes the entries as CSV with CsvEntryHeader.
+func WriteEntriesCSV(w io.Writer, es []*Entry) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(CsvEntryHeader); err != nil {
+		return err
+	}
+	for _, e := range es {
+		rec := []string{
+			e.Id(),
+			e.Trader,
+			e.Symbol,
+			e.Position,
+			strconv.FormatFloat(e.Size, 'f', -1, 64),
+			strconv.FormatFloat(e.Win, 'f', -1, 64),
+			strconv.FormatFloat(e.Last_fix_rate, 'f', -1, 64),
+			e.Last_fix_date.Format(time.RFC3339Nano),
+			strconv.FormatBool(e.Last_run),
+			"",
+		}
+		if len(e.State) > 0 {
+			b, err := json.Marshal(e.State)
+			if err != nil {
+				return err
+			}
+			rec[9] = string(b)
+		}
+		if err := cw.Write(rec); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+//ReadEntriesCSV reads the entries written by WriteEntriesCSV. The columns
+//are found by the header, so they may be reordered in a spreadsheet.
+func ReadEntriesCSV(r io.Reader) ([]*Entry, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return []*Entry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cols := make(map[string]int)
+	for i, name := range header {
+		cols[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range CsvEntryHeader[:9] {
+		if _, ok := cols[name]; !ok {
+			return nil, fmt.Errorf("line 1: column '%s' is missing.", name)
+		}
+	}
+
+	es := []*Entry{}
+	ids := make(map[string]int)
+	for line := 2; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return es, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := csvEntry(cols, rec)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+		if err := checkEntry(e, ids, line); err != nil {
+			return nil, err
+		}
+		es = append(es, e)
+	}
+}
+
+func csvEntry(cols map[string]int, rec []string) (*Entry, error) {
+	get := func(name string) string {
+		i, ok := cols[name]
+		if !ok || i >= len(rec) {
+			return ""
+		}
+		return strings.TrimSpace(rec[i])
+	}
+	float := func(name string) (float64, error) {
+		s := get(name)
+		if s == "" {
+			return 0, nil
+		}
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s '%s'.", name, s)
+		}
+		return v, nil
+	}
+
+	var err error
+	e := &Entry{
+		Trader: get("trader"),
+		Symbol: get("symbol"),
+		Position: strings.ToUpper(get("position")),
+	}
+	if e.Uuid, err = uuid.Parse(get("id")); err != nil {
+		return nil, fmt.Errorf("invalid id '%s'.", get("id"))
+	}
+	if e.Size, err = float("size"); err != nil {
+		return nil, err
+	}
+	if e.Win, err = float("win"); err != nil {
+		return nil, err
+	}
+	if e.Last_fix_rate, err = float("last_rate"); err != nil {
+		return nil, err
+	}
+	if e.Last_fix_date, err = time.Parse(time.RFC3339Nano, get("last_date")); err != nil {
+		return nil, fmt.Errorf("invalid last_date '%s'.", get("last_date"))
+	}
+	if e.Last_run, err = strconv.ParseBool(get("stopping")); err != nil {
+		return nil, fmt.Errorf("invalid stopping '%s'.", get("stopping"))
+	}
+	if s := get("state"); s != "" {
+		if err := json.Unmarshal([]byte(s), &e.State); err != nil {
+			return nil, fmt.Errorf("invalid state: %s", err)
+		}
+	}
+	return e, nil
+}
+
+//ValidateEntry checks that the entry can be traded.
+func ValidateEntry(e *Entry) error {
+	if e.Uuid == uuid.Nil {
+		return fmt.Errorf("id is empty.")
+	}
+	if e.Trader == "" {
+		return fmt.Errorf("trader is empty.")
+	}
+	if e.Symbol == "" {
+		return fmt.Errorf("symbol is empty.")
+	}
+	if e.Position != gomocoin.SIDE_BUY && e.Position != gomocoin.SIDE_SELL {
+		return fmt.Errorf("position must be %s or %s, got '%s'.",
+						gomocoin.SIDE_BUY, gomocoin.SIDE_SELL, e.Position)
+	}
+	if e.Size <= 0 {
+		return fmt.Errorf("size must be positive, got %v.", e.Size)
+	}
+	if e.Last_fix_rate <= 0 {
+		return fmt.Errorf("last rate must be positive, got %v.", e.Last_fix_rate)
+	}
+	if e.Last_fix_date.IsZero() {
+		return fmt.Errorf("last date is empty.")
+	}
+	return e.State.Validate()
+}
+
+func checkEntry(e *Entry, ids map[string]int, line int) error {
+	if err := ValidateEntry(e); err != nil {
+		return fmt.Errorf("line %d: %s", line, err)
+	}
+	if first, ok := ids[e.Id()]; ok {
+		return fmt.Errorf("line %d: id '%s' is duplicated with line %d.", line, e.Id(), first)
+	}
+	ids[e.Id()] = line
+	return nil
+}
@@ -0,0 +1,153 @@
+package miniquet
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"encoding/json"
+)
+
+import (
+	"github.com/vouquet/go-gmo-coin/gomocoin"
+)
+
+func testEntries() []*Entry {
+	a := NewEntry("alice", "BTC", 0.013, 2981200)
+	a.Win = -1.5
+	a.StateOf(StrategyPoint).SetFloat(STATE_POINT_DIFF, 12.5)
+	a.StateOf("x").SetBytes("buf", []byte{0, 1, 255})
+	a.StateOf("x").Keep("buf", true)
+
+	b := NewEntry("john", "ETH", 1, 250000)
+	b.Position = gomocoin.SIDE_SELL
+	b.Lastone()
+	return []*Entry{a, b}
+}
+
+func sameEntry(t *testing.T, want *Entry, got *Entry) {
+	if got.Id() != want.Id() || got.Trader != want.Trader || got.Symbol != want.Symbol ||
+			got.Position != want.Position || got.Size != want.Size || got.Win != want.Win ||
+			got.Last_fix_rate != want.Last_fix_rate || got.Last_run != want.Last_run {
+		t.Fatalf("want %+v, got %+v", want, got)
+	}
+	if !got.Last_fix_date.Equal(want.Last_fix_date) {
+		t.Fatalf("%s: want date %s, got %s", want.Id(), want.Last_fix_date, got.Last_fix_date)
+	}
+
+	ws, _ := json.Marshal(want.State)
+	gs, _ := json.Marshal(got.State)
+	if string(ws) != string(gs) {
+		t.Fatalf("%s: want state %s, got %s", want.Id(), ws, gs)
+	}
+}
+
+//TestExportRoundTrip exports the entries of a storage and imports them
+//into another one, in each format.
+func TestExportRoundTrip(t *testing.T) {
+	for _, format := range []string{FORMAT_JSON, FORMAT_CSV} {
+		src := NewMemStorage()
+		es := testEntries()
+		for _, e := range es {
+			if err := src.Put(e); err != nil {
+				t.Fatal(err)
+			}
+		}
+		walked, err := src.Walk()
+		if err != nil {
+			t.Fatal(err)
+		}
+		src.Close()
+
+		buf := new(bytes.Buffer)
+		if err := WriteEntries(buf, format, walked); err != nil {
+			t.Fatal(err)
+		}
+		read, err := ReadEntries(buf, format)
+		if err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+		if len(read) != len(es) {
+			t.Fatalf("%s: want %d entries, got %d", format, len(es), len(read))
+		}
+
+		dst := NewMemStorage()
+		for _, e := range read {
+			if err := dst.Put(e); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, want := range es {
+			got, err := dst.Get(want.Id())
+			if err != nil {
+				t.Fatalf("%s: %s", format, err)
+			}
+			sameEntry(t, want, got)
+		}
+		dst.Close()
+	}
+}
+
+func TestExportFormat(t *testing.T) {
+	for _, c := range [][3]string{
+		{"", "entries.CSV", FORMAT_CSV},
+		{"", "entries.jsonl", FORMAT_JSON},
+		{"", "-", FORMAT_JSON},
+		{"jsonl", "entries.csv", FORMAT_JSON},
+		{"csv", "", FORMAT_CSV},
+	} {
+		got, err := ExportFormat(c[0], c[1])
+		if err != nil || got != c[2] {
+			t.Errorf("%q %q: want %s, got %s %v", c[0], c[1], c[2], got, err)
+		}
+	}
+	if _, err := ExportFormat("xml", ""); err == nil {
+		t.Error("unknown format is accepted.")
+	}
+}
+
+//TestImportSchema1 imports a line exported before the scratch buffers
+//were moved into the State.
+func TestImportSchema1(t *testing.T) {
+	line := `{"Version":1,"Uuid":"aaaaaaaa-0000-4000-8000-000000000000","Trader":"alice",` +
+		`"Symbol":"BTC","Position":"BUY","Size":0.1,"Win":0,"Last_fix_rate":100,` +
+		`"Last_fix_date":"2021-01-02T03:04:05Z","Gb01":0,"Gb02":3,"Gb03":"AQI=","Gb04":null,"Last_run":false}`
+
+	es, err := ReadEntriesJSON(strings.NewReader(line + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := es[0]
+	if e.Version != SCHEMA_VERSION || e.Point() != 0.03 {
+		t.Fatalf("entry is not upgraded: %+v", e)
+	}
+	if b, ok := e.StateOf(STATE_NS_LEGACY).Bytes("gb03"); !ok || string(b) != "\x01\x02" {
+		t.Fatalf("gb03 is %v, %v", b, ok)
+	}
+}
+
+func TestImportRejects(t *testing.T) {
+	buf := new(bytes.Buffer)
+	es := testEntries()
+	if err := WriteEntriesJSON(buf, append(es, es[0])); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadEntriesJSON(buf); err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Fatalf("duplicated id is not reported at line 3: %v", err)
+	}
+
+	buf.Reset()
+	es[1].Size = 0
+	if err := WriteEntriesCSV(buf, es); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadEntriesCSV(buf); err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Fatalf("invalid size is not reported at line 3: %v", err)
+	}
+
+	if _, err := ReadEntriesCSV(strings.NewReader("id,trader\n")); err == nil {
+		t.Fatal("missing columns are accepted.")
+	}
+	if _, err := ReadEntriesJSON(strings.NewReader(`{"Version":99}` + "\n")); err == nil {
+		t.Fatal("newer schema is accepted.")
+	}
+}
@@ -0,0 +1,204 @@
+package miniquet
+
+import (
+	"fmt"
+	"time"
+)
+
+import (
+	"github.com/ugorji/go/codec"
+)
+
+//StorageCorruptedError is returned when the storage cannot be opened for
+//a broken file. RecoverStorage may repair it.
+type StorageCorruptedError struct {
+	Path string
+	Err  error
+}
+
+func (self *StorageCorruptedError) Error() string {
+	return fmt.Sprintf("%s is corrupted: %s", self.Path, self.Err)
+}
+
+//RecoverStorage repairs the files of a storage which cannot be opened.
+//The storage must not be used meanwhile. Data which cannot be recovered
+//is dropped, or moved aside to the returned path. Check the entries with
+//Storage.Check after it.
+func RecoverStorage(s_type string, path string) (string, error) {
+	switch s_type {
+	case STORAGE_LEVELDB, "":
+		return "", recoverLevel(path)
+	case STORAGE_LOG:
+		return recoverLog(path)
+	case STORAGE_MEMORY:
+		return "", fmt.Errorf("memory storage has nothing to recover.")
+	}
+	return "", fmt.Errorf("unknown storage type '%s'.", s_type)
+}
+
+//QuarantineRecord is a record which could not be decoded. It is moved
+//from its key to NS_QUARANTINE with the reason, so that the healthy
+//entries can still be loaded.
+type QuarantineRecord struct {
+	Key    string
+	Reason string
+	Time   time.Time
+	Value  []byte
+}
+
+func quarantineKey(key string) []byte {
+	return []byte(NS_QUARANTINE + key)
+}
+
+func encodeQuarantine(r *QuarantineRecord) ([]byte, error) {
+	var b []byte
+	if err := codec.NewEncoderBytes(&b, MsgpckHndl).Encode(r); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func decodeQuarantine(b []byte) (*QuarantineRecord, error) {
+	var r QuarantineRecord
+	if err := codec.NewDecoderBytes(b, MsgpckHndl).Decode(&r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+//quarantine moves the record at key to NS_QUARANTINE in the batch.
+func quarantine(batch *Batch, key []byte, val []byte, reason error) (*QuarantineRecord, error) {
+	r := &QuarantineRecord{
+		Key: string(key),
+		Reason: reason.Error(),
+		Time: time.Now(),
+		Value: copyBytes(val),
+	}
+	b, err := encodeQuarantine(r)
+	if err != nil {
+		return nil, err
+	}
+	batch.Delete(key)
+	batch.Put(quarantineKey(r.Key), b)
+	return r, nil
+}
+
+//CheckReport is the result of Storage.Check.
+type CheckReport struct {
+	//Entries is the number of the healthy entries.
+	Entries     int
+	//Broken are the entries which cannot be decoded.
+	Broken      []*QuarantineRecord
+	//Dangling is the number of the index keys without an entry.
+	Dangling    int
+	//Missing is the number of the index keys missing for an entry.
+	Missing     int
+	//Quarantined is the number of the records quarantined before.
+	Quarantined int
+}
+
+//OK is true when nothing needs a repair.
+func (self *CheckReport) OK() bool {
+	return len(self.Broken) == 0 && self.Dangling == 0 && self.Missing == 0
+}
+
+func (self *CheckReport) String() string {
+	return fmt.Sprintf("%d entries, %d broken, %d dangling index keys, %d missing index keys, %d quarantined before",
+				self.Entries, len(self.Broken), self.Dangling, self.Missing, self.Quarantined)
+}
+
+//Check decodes every entry and compares the indexes with them. With fix,
+//the broken entries are moved to NS_QUARANTINE and the indexes are
+//repaired in one batch. Without fix, nothing is written.
+func (self *Storage) Check(fix bool) (*CheckReport, error) {
+	self.lock()
+	defer self.unlock()
+	defer self.observed("check", time.Now())
+
+	if self.b == nil {
+		return nil, fmt.Errorf("target database is nil pointer.")
+	}
+	return check(self.b, fix)
+}
+
+func check(b Backend, fix bool) (*CheckReport, error) {
+	report := &CheckReport{Broken: []*QuarantineRecord{}}
+	batch := NewBatch()
+
+	want := make(map[string]bool)
+	err := b.Range([]byte(NS_ENTRIES), func(key []byte, val []byte) error {
+		e, err := decode(val)
+		if err == nil && string(key) != string(entryKey(e.Id())) {
+			err = fmt.Errorf("the id is '%s'.", e.Id())
+		}
+		if err != nil {
+			r, q_err := quarantine(batch, key, val, err)
+			if q_err != nil {
+				return q_err
+			}
+			report.Broken = append(report.Broken, r)
+			return nil
+		}
+
+		report.Entries++
+		for _, k := range indexKeys(e) {
+			want[string(k)] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = b.Range([]byte(NS_INDEX), func(key []byte, _ []byte) error {
+		if want[string(key)] {
+			delete(want, string(key))
+			return nil
+		}
+		report.Dangling++
+		batch.Delete(key)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for k, _ := range want {
+		report.Missing++
+		batch.Put([]byte(k), []byte{})
+	}
+
+	err = b.Range([]byte(NS_QUARANTINE), func([]byte, []byte) error {
+		report.Quarantined++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !fix || batch.Len() == 0 {
+		return report, nil
+	}
+	if err := b.Write(batch); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+//ForEachQuarantine calls f with the quarantined records in the order of
+//the key. f must not use the storage.
+func (self *Storage) ForEachQuarantine(f func(*QuarantineRecord) error) error {
+	self.lock()
+	defer self.unlock()
+
+	if self.b == nil {
+		return fmt.Errorf("target database is nil pointer.")
+	}
+
+	return self.b.Range([]byte(NS_QUARANTINE), func(key []byte, val []byte) error {
+		r, err := decodeQuarantine(val)
+		if err != nil {
+			return fmt.Errorf("record '%s': %s", string(key), err)
+		}
+		return f(r)
+	})
+}
@@ -0,0 +1,163 @@
+package miniquet
+
+import (
+	"os"
+	"testing"
+	"io/ioutil"
+	"path/filepath"
+)
+
+//TestCheckQuarantine checks an undecodable entry is quarantined and the
+//indexes are repaired, and the healthy entries are left in place.
+func TestCheckQuarantine(t *testing.T) {
+	b := NewMemBackend()
+	st, err := NewStorage(b, "check", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	es := testEntries()
+	for _, e := range es {
+		if err := st.Put(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	batch := NewBatch()
+	batch.Put(entryKey("broken"), []byte{0xc1})
+	batch.Put(indexKey(IDX_TRADER, "bob", "gone"), []byte{})
+	batch.Delete(indexKey(IDX_SYMBOL, es[0].Symbol, es[0].Id()))
+	if err := b.Write(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := st.Check(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.OK() || report.Entries != 2 || len(report.Broken) != 1 ||
+			report.Dangling != 1 || report.Missing != 1 || report.Quarantined != 0 {
+		t.Fatalf("unexpected report: %s", report)
+	}
+	if report.Broken[0].Key != string(entryKey("broken")) {
+		t.Fatalf("broken key is %s", report.Broken[0].Key)
+	}
+	if _, err := b.Get(entryKey("broken")); err != nil {
+		t.Fatalf("check without fix wrote: %v", err)
+	}
+
+	if _, err := st.Check(true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Get(entryKey("broken")); err != ErrNotFound {
+		t.Fatalf("broken entry is left: %v", err)
+	}
+	for _, want := range es {
+		got, err := st.Get(want.Id())
+		if err != nil {
+			t.Fatal(err)
+		}
+		sameEntry(t, want, got)
+	}
+	if n, err := countEntries(st.ForEachBySymbol, es[0].Symbol); err != nil || n != 1 {
+		t.Fatalf("index of the symbol: %d entries, %v", n, err)
+	}
+
+	qs := []*QuarantineRecord{}
+	err = st.ForEachQuarantine(func(r *QuarantineRecord) error {
+		qs = append(qs, r)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(qs) != 1 || qs[0].Key != string(entryKey("broken")) || string(qs[0].Value) != "\xc1" {
+		t.Fatalf("quarantined %+v", qs)
+	}
+
+	report, err = st.Check(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || report.Entries != 2 || report.Quarantined != 1 {
+		t.Fatalf("unexpected report after the fix: %s", report)
+	}
+}
+
+//writeLogStorage writes the entries to a new log storage, one record
+//each, and returns the size of the file before and after each record.
+func writeLogStorage(t *testing.T, path string, es []*Entry) []int64 {
+	st, err := OpenStorageWith(STORAGE_LOG, path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	sizes := []int64{}
+	for i := 0; i <= len(es); i++ {
+		if i > 0 {
+			if err := st.Put(es[i - 1]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes = append(sizes, fi.Size())
+	}
+	return sizes
+}
+
+//TestRecoverLog breaks a record in the middle of a log storage, and
+//recovers the records after it.
+func TestRecoverLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "miniquet2-recover-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "log")
+
+	es := testEntries()
+	sizes := writeLogStorage(t, path, es)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[sizes[1] - 1] ^= 0xff
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = OpenStorageWith(STORAGE_LOG, path, nil)
+	if _, ok := err.(*StorageCorruptedError); !ok {
+		t.Fatalf("want StorageCorruptedError, got %v", err)
+	}
+
+	aside, err := RecoverStorage(STORAGE_LOG, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(aside); err != nil || fi.Size() != sizes[1] - sizes[0] {
+		t.Fatalf("broken record is not moved aside: %v", err)
+	}
+
+	st, err := OpenStorageWith(STORAGE_LOG, path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	if _, err := st.Get(es[0].Id()); err == nil {
+		t.Fatal("entry of the broken record is read.")
+	}
+	got, err := st.Get(es[1].Id())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameEntry(t, es[1], got)
+
+	if again, err := RecoverStorage(STORAGE_LOG, path); err == nil || again != "" {
+		t.Fatalf("recovered a storage in use: %s, %v", again, err)
+	}
+}
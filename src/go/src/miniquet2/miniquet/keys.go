@@ -0,0 +1,80 @@
+package miniquet
+
+import (
+	"strings"
+)
+
+//The keyspace of the storage is split by the prefix of the keys.
+const (
+	NS_ENTRIES string = "entries/"
+	NS_TRADERS string = "traders/"
+	NS_LEDGER  string = "ledger/"
+	NS_TICKS   string = "ticks/"
+	NS_META    string = "meta/"
+	//NS_INDEX holds the secondary indexes of the entries. They have no
+	//value, and are written in the same batch as the entry.
+	NS_INDEX   string = "index/"
+	//NS_QUARANTINE holds the records which cannot be decoded, under their
+	//original keys.
+	NS_QUARANTINE string = "quarantine/"
+	//NS_ARCHIVE holds the entries which are not traded anymore.
+	NS_ARCHIVE string = "archive/"
+	//NS_KILLED holds the entries stopped by kill9, until they are revived
+	//or archived.
+	NS_KILLED  string = "killed/"
+
+	IDX_TRADER string = NS_INDEX + "trader/"
+	IDX_SYMBOL string = NS_INDEX + "symbol/"
+
+	//SEP_INDEX separates the indexed value and the id. It cannot be in a
+	//trader name or a symbol.
+	SEP_INDEX string = "\x00"
+)
+
+var (
+	Namespaces []string = []string{NS_ENTRIES, NS_TRADERS, NS_LEDGER, NS_TICKS, NS_META, NS_INDEX, NS_QUARANTINE, NS_ARCHIVE, NS_KILLED}
+)
+
+func entryKey(id string) []byte {
+	return []byte(NS_ENTRIES + id)
+}
+
+func indexPrefix(idx string, value string) []byte {
+	return []byte(idx + value + SEP_INDEX)
+}
+
+func indexKey(idx string, value string, id string) []byte {
+	return append(indexPrefix(idx, value), []byte(id)...)
+}
+
+func indexKeys(e *Entry) [][]byte {
+	return [][]byte{
+		indexKey(IDX_TRADER, e.Trader, e.Id()),
+		indexKey(IDX_SYMBOL, e.Symbol, e.Id()),
+	}
+}
+
+//indexId returns the id of an index key.
+func indexId(key []byte) string {
+	s := string(key)
+	return s[strings.LastIndex(s, SEP_INDEX) + len(SEP_INDEX):]
+}
+
+func isNamespace(ns string) bool {
+	for _, n := range Namespaces {
+		if n == ns {
+			return true
+		}
+	}
+	return false
+}
+
+//isFlatKey is true for the keys of the entries before the keyspace was
+//split. They were the raw ids.
+func isFlatKey(key []byte) bool {
+	return !strings.Contains(string(key), "/")
+}
+
+func hasPrefix(key []byte, prefix string) bool {
+	return strings.HasPrefix(string(key), prefix)
+}
@@ -0,0 +1,27 @@
+//go:build !windows
+// +build !windows
+
+package miniquet
+
+import (
+	"os"
+	"syscall"
+)
+
+//lockFile takes the lock of the file without waiting. Readers share it.
+func lockFile(f *os.File, shared bool) error {
+	how := syscall.LOCK_EX
+	if shared {
+		how = syscall.LOCK_SH
+	}
+	return syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB)
+}
+
+//isLocked tells the error is of a file locked by another process.
+func isLocked(err error) bool {
+	errno, ok := err.(syscall.Errno)
+	if !ok {
+		return false
+	}
+	return errno == syscall.EAGAIN || errno == syscall.EWOULDBLOCK
+}
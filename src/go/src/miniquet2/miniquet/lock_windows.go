@@ -0,0 +1,47 @@
+package miniquet
+
+import (
+	"os"
+	"unsafe"
+	"syscall"
+)
+
+const (
+	LOCKFILE_FAIL_IMMEDIATELY uint32 = 0x1
+	LOCKFILE_EXCLUSIVE_LOCK   uint32 = 0x2
+
+	ERROR_SHARING_VIOLATION syscall.Errno = 32
+	ERROR_LOCK_VIOLATION    syscall.Errno = 33
+)
+
+var (
+	modkernel32    = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx = modkernel32.NewProc("LockFileEx")
+)
+
+//lockFile takes the lock of the whole file without waiting. Readers
+//share it.
+func lockFile(f *os.File, shared bool) error {
+	flags := LOCKFILE_FAIL_IMMEDIATELY
+	if !shared {
+		flags |= LOCKFILE_EXCLUSIVE_LOCK
+	}
+
+	ol := new(syscall.Overlapped)
+	all := ^uint32(0)
+	r, _, err := procLockFileEx.Call(f.Fd(), uintptr(flags), 0,
+							uintptr(all), uintptr(all), uintptr(unsafe.Pointer(ol)))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+//isLocked tells the error is of a file locked by another process.
+func isLocked(err error) bool {
+	errno, ok := err.(syscall.Errno)
+	if !ok {
+		return false
+	}
+	return errno == ERROR_LOCK_VIOLATION || errno == ERROR_SHARING_VIOLATION
+}
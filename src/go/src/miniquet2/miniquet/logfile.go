@@ -0,0 +1,256 @@
+package miniquet
+
+import (
+	"os"
+	"fmt"
+	"bufio"
+	"sort"
+	"sync"
+	"time"
+	"strings"
+	"path/filepath"
+)
+
+const (
+	FmtRotateTime string = "20060102-150405"
+	SIZE_LOG_LINE int = 1024 * 1024
+)
+
+var (
+	DefaultRotateOpt *RotateOpt = &RotateOpt{
+		MaxSize: 10 * 1024 * 1024,
+		MaxAge: 7 * 24 * time.Hour,
+		MaxBackups: 10,
+	}
+)
+
+//RotateOpt limits a log file. A zero value disables the limit.
+type RotateOpt struct {
+	MaxSize    int64
+	MaxAge     time.Duration
+	MaxBackups int
+}
+
+type LogConfig struct {
+	File       string
+	Level      string
+	MaxSizeMB  int
+	MaxAge     string
+	MaxBackups int
+
+	AuditFile  string
+}
+
+//RotateOpt returns the rotation limits, using DefaultRotateOpt for the
+//values which are not set.
+func (self *LogConfig) RotateOpt() *RotateOpt {
+	opt := *DefaultRotateOpt
+	if self.MaxSizeMB > 0 {
+		opt.MaxSize = int64(self.MaxSizeMB) * 1024 * 1024
+	}
+	if d, err := time.ParseDuration(self.MaxAge); err == nil && d > 0 {
+		opt.MaxAge = d
+	}
+	if self.MaxBackups > 0 {
+		opt.MaxBackups = self.MaxBackups
+	}
+	return &opt
+}
+
+//RotateFile is a log file which is renamed to '<path>.<time>' when it
+//grows over MaxSize. Old files over MaxAge or MaxBackups are removed.
+type RotateFile struct {
+	path string
+	opt  *RotateOpt
+
+	f    *os.File
+	size int64
+
+	mtx *sync.Mutex
+}
+
+func OpenRotateFile(path string, opt *RotateOpt) (*RotateFile, error) {
+	if opt == nil {
+		opt = DefaultRotateOpt
+	}
+
+	self := &RotateFile{
+		path: filepath.Clean(path),
+		opt: opt,
+		mtx: new(sync.Mutex),
+	}
+	if err := self.open(); err != nil {
+		return nil, err
+	}
+	self.purge()
+	return self, nil
+}
+
+func (self *RotateFile) Path() string {
+	return self.path
+}
+
+func (self *RotateFile) WriteRecord(r *LogRecord) error {
+	_, err := self.Write([]byte(r.Format()))
+	return err
+}
+
+func (self *RotateFile) Write(b []byte) (int, error) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	if self.f == nil {
+		return 0, fmt.Errorf("log file is closed.")
+	}
+
+	if self.opt.MaxSize > 0 && self.size > 0 && self.size + int64(len(b)) > self.opt.MaxSize {
+		if err := self.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := self.f.Write(b)
+	self.size += int64(n)
+	return n, err
+}
+
+func (self *RotateFile) Close() error {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	if self.f == nil {
+		return nil
+	}
+	err := self.f.Close()
+	self.f = nil
+	return err
+}
+
+func (self *RotateFile) open() error {
+	f, err := os.OpenFile(self.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	self.f = f
+	self.size = fi.Size()
+	return nil
+}
+
+func (self *RotateFile) rotate() error {
+	if err := self.f.Close(); err != nil {
+		return err
+	}
+	self.f = nil
+
+	name := self.path + "." + time.Now().Format(FmtRotateTime)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(name); os.IsNotExist(err) {
+			break
+		}
+		name = fmt.Sprintf("%s.%s.%d", self.path, time.Now().Format(FmtRotateTime), i)
+	}
+	if err := os.Rename(self.path, name); err != nil {
+		return err
+	}
+
+	if err := self.open(); err != nil {
+		return err
+	}
+	self.purge()
+	return nil
+}
+
+//Tail returns the last n records written, oldest first. The rotated
+//files are read when the current one is shorter than n. Lines which are
+//not records are skipped.
+func (self *RotateFile) Tail(n int) ([]*LogRecord, error) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	rs := []*LogRecord{}
+	for _, path := range append([]string{self.path}, self.Backups()...) {
+		f_rs, err := readLogRecords(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		rs = append(f_rs, rs...)
+
+		if len(rs) >= n {
+			return rs[len(rs) - n:], nil
+		}
+	}
+	return rs, nil
+}
+
+func readLogRecords(path string) ([]*LogRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rs := []*LogRecord{}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64 * 1024), SIZE_LOG_LINE)
+	for sc.Scan() {
+		r, err := ParseLogRecord(sc.Text())
+		if err != nil {
+			continue
+		}
+		rs = append(rs, r)
+	}
+	return rs, sc.Err()
+}
+
+//Backups returns the rotated files, newest first.
+func (self *RotateFile) Backups() []string {
+	files, err := filepath.Glob(self.path + ".*")
+	if err != nil {
+		return nil
+	}
+
+	backups := []string{}
+	for _, f := range files {
+		suffix := strings.TrimPrefix(f, self.path + ".")
+		if len(suffix) < len(FmtRotateTime) {
+			continue
+		}
+		if _, err := time.Parse(FmtRotateTime, suffix[:len(FmtRotateTime)]); err != nil {
+			continue
+		}
+		backups = append(backups, f)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+	return backups
+}
+
+func (self *RotateFile) purge() {
+	now := time.Now()
+	for i, f := range self.Backups() {
+		if self.opt.MaxBackups > 0 && i >= self.opt.MaxBackups {
+			os.Remove(f)
+			continue
+		}
+
+		if self.opt.MaxAge < 1 {
+			continue
+		}
+		fi, err := os.Stat(f)
+		if err != nil {
+			continue
+		}
+		if now.Sub(fi.ModTime()) > self.opt.MaxAge {
+			os.Remove(f)
+		}
+	}
+}
@@ -1,6 +1,298 @@
 package miniquet
 
+import (
+	"io"
+	"fmt"
+	"sync"
+	"time"
+	"strconv"
+	"strings"
+)
+
+const (
+	LevelDebug Level = 0
+	LevelInfo  Level = 1
+	LevelWarn  Level = 2
+	LevelError Level = 3
+
+	FmtLogTime string = "2006-01-02T15:04:05.000Z07:00"
+)
+
 type Logger interface {
 	WriteMsgLog(string, ...interface{})
 	WriteErrLog(string, ...interface{})
 }
+
+type Level int8
+
+func (self Level) String() string {
+	switch self {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return fmt.Sprintf("LEVEL(%d)", int8(self))
+}
+
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "info", "":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return LevelInfo, fmt.Errorf("unknown log level: '%s'", s)
+}
+
+//LogRecord is one log line. Fields holds key and value pairs.
+type LogRecord struct {
+	Time   time.Time
+	Level  Level
+	Msg    string
+	Fields []interface{}
+}
+
+func (self *LogRecord) Field(key string) (interface{}, bool) {
+	for i := 0; i + 1 < len(self.Fields); i += 2 {
+		if fmt.Sprint(self.Fields[i]) == key {
+			return self.Fields[i + 1], true
+		}
+	}
+	return nil, false
+}
+
+//Text returns the message followed by the fields as key=value.
+func (self *LogRecord) Text() string {
+	b := new(strings.Builder)
+	b.WriteString(self.Msg)
+
+	for i := 0; i < len(self.Fields); i += 2 {
+		key := fmt.Sprint(self.Fields[i])
+		var val interface{} = "(MISSING)"
+		if i + 1 < len(self.Fields) {
+			val = self.Fields[i + 1]
+		}
+
+		b.WriteString(" ")
+		b.WriteString(key)
+		b.WriteString("=")
+		b.WriteString(quoteLogValue(fmt.Sprint(val)))
+	}
+	return b.String()
+}
+
+//Format returns the record as one line of a log file.
+func (self *LogRecord) Format() string {
+	return fmt.Sprintf("%s %-5s %s\n", self.Time.Format(FmtLogTime), self.Level, self.Text())
+}
+
+//ParseLogRecord reads a line written by Format. The fields stay in the
+//message, as the record is read back only to be shown.
+func ParseLogRecord(line string) (*LogRecord, error) {
+	line = strings.TrimRight(line, "\n")
+
+	ts := strings.SplitN(line, " ", 2)
+	if len(ts) < 2 {
+		return nil, fmt.Errorf("not a log record: '%s'.", line)
+	}
+	t, err := time.Parse(FmtLogTime, ts[0])
+	if err != nil {
+		return nil, fmt.Errorf("not a log record: '%s'.", line)
+	}
+
+	rest := ts[1]
+	lvs := strings.Fields(rest)
+	if len(lvs) < 1 {
+		return nil, fmt.Errorf("not a log record: '%s'.", line)
+	}
+	level, err := ParseLevel(lvs[0])
+	if err != nil {
+		return nil, err
+	}
+
+	rest = rest[len(lvs[0]):]
+	if pad := 5 - len(lvs[0]); pad > 0 {
+		rest = strings.TrimPrefix(rest, strings.Repeat(" ", pad))
+	}
+	rest = strings.TrimPrefix(rest, " ")
+	return &LogRecord{Time: t, Level: level, Msg: rest}, nil
+}
+
+func quoteLogValue(s string) string {
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+type LogSink interface {
+	WriteRecord(*LogRecord) error
+}
+
+//WriterSink writes formatted records to an io.Writer, as os.Stdout.
+type WriterSink struct {
+	w   io.Writer
+	mtx *sync.Mutex
+}
+
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w, mtx: new(sync.Mutex)}
+}
+
+func (self *WriterSink) WriteRecord(r *LogRecord) error {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	_, err := io.WriteString(self.w, r.Format())
+	return err
+}
+
+//LogRing keeps the last records in memory.
+type LogRing struct {
+	recs []*LogRecord
+	head int
+	full bool
+
+	mtx *sync.Mutex
+}
+
+func NewLogRing(size int) *LogRing {
+	if size < 1 {
+		size = 1
+	}
+	return &LogRing{recs: make([]*LogRecord, size), mtx: new(sync.Mutex)}
+}
+
+func (self *LogRing) WriteRecord(r *LogRecord) error {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	self.recs[self.head] = r
+	self.head = (self.head + 1) % len(self.recs)
+	if self.head == 0 {
+		self.full = true
+	}
+	return nil
+}
+
+//Records returns the last n records, oldest first. n < 1 returns all.
+func (self *LogRing) Records(n int) []*LogRecord {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	rs := make([]*LogRecord, 0, len(self.recs))
+	if self.full {
+		rs = append(rs, self.recs[self.head:]...)
+	}
+	rs = append(rs, self.recs[:self.head]...)
+
+	if n > 0 && len(rs) > n {
+		rs = rs[len(rs) - n:]
+	}
+	return rs
+}
+
+type logCore struct {
+	level Level
+	sinks []LogSink
+
+	mtx *sync.Mutex
+}
+
+//LevelLogger is a leveled logger writing to several sinks. Loggers made
+//by With share the level and sinks of their parent.
+type LevelLogger struct {
+	core   *logCore
+	fields []interface{}
+}
+
+func NewLevelLogger(level Level, sinks ...LogSink) *LevelLogger {
+	return &LevelLogger{
+		core: &logCore{
+			level: level,
+			sinks: sinks,
+			mtx: new(sync.Mutex),
+		},
+	}
+}
+
+func (self *LevelLogger) SetLevel(level Level) {
+	self.core.mtx.Lock()
+	defer self.core.mtx.Unlock()
+
+	self.core.level = level
+}
+
+func (self *LevelLogger) Level() Level {
+	self.core.mtx.Lock()
+	defer self.core.mtx.Unlock()
+
+	return self.core.level
+}
+
+func (self *LevelLogger) AddSink(sink LogSink) {
+	self.core.mtx.Lock()
+	defer self.core.mtx.Unlock()
+
+	self.core.sinks = append(self.core.sinks, sink)
+}
+
+//With returns a logger which adds the key and value pairs to every record.
+func (self *LevelLogger) With(kv ...interface{}) *LevelLogger {
+	fields := make([]interface{}, 0, len(self.fields) + len(kv))
+	fields = append(fields, self.fields...)
+	fields = append(fields, kv...)
+
+	return &LevelLogger{core: self.core, fields: fields}
+}
+
+func (self *LevelLogger) Debug(msg string, kv ...interface{}) {
+	self.write(LevelDebug, msg, kv)
+}
+
+func (self *LevelLogger) Info(msg string, kv ...interface{}) {
+	self.write(LevelInfo, msg, kv)
+}
+
+func (self *LevelLogger) Warn(msg string, kv ...interface{}) {
+	self.write(LevelWarn, msg, kv)
+}
+
+func (self *LevelLogger) Error(msg string, kv ...interface{}) {
+	self.write(LevelError, msg, kv)
+}
+
+func (self *LevelLogger) WriteMsgLog(s string, msg ...interface{}) {
+	self.write(LevelInfo, fmt.Sprintf(s, msg...), nil)
+}
+
+func (self *LevelLogger) WriteErrLog(s string, msg ...interface{}) {
+	self.write(LevelError, fmt.Sprintf(s, msg...), nil)
+}
+
+func (self *LevelLogger) write(level Level, msg string, kv []interface{}) {
+	self.core.mtx.Lock()
+	defer self.core.mtx.Unlock()
+
+	if level < self.core.level {
+		return
+	}
+
+	fields := make([]interface{}, 0, len(self.fields) + len(kv))
+	fields = append(fields, self.fields...)
+	fields = append(fields, kv...)
+
+	r := &LogRecord{Time: time.Now(), Level: level, Msg: msg, Fields: fields}
+	for _, sink := range self.core.sinks {
+		sink.WriteRecord(r)
+	}
+}
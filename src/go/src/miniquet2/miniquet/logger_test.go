@@ -0,0 +1,102 @@
+package miniquet
+
+import (
+	"os"
+	"fmt"
+	"time"
+	"testing"
+	"io/ioutil"
+	"path/filepath"
+)
+
+func logMsgs(rs []*LogRecord) string {
+	msgs := []string{}
+	for _, r := range rs {
+		msgs = append(msgs, r.Msg)
+	}
+	return fmt.Sprint(msgs)
+}
+
+//TestRotateFile writes records over MaxSize, and checks the rotated files
+//are purged to MaxBackups and Tail reads across them.
+func TestRotateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "miniquet2-logfile-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	at := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	rec := func(i int) *LogRecord {
+		return &LogRecord{Time: at, Level: LevelInfo, Msg: fmt.Sprintf("m%d", i)}
+	}
+	//a file holds two records.
+	size := int64(len(rec(0).Format()))
+	f, err := OpenRotateFile(filepath.Join(dir, "log"), &RotateOpt{MaxSize: size * 2, MaxBackups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	for i := 0; i < 5; i++ {
+		if err := f.WriteRecord(rec(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if bs := f.Backups(); len(bs) != 2 {
+		t.Fatalf("backups are %v", bs)
+	}
+	if fi, err := os.Stat(f.Path()); err != nil || fi.Size() != size {
+		t.Fatalf("current file is not rotated: %v", err)
+	}
+	rs, err := f.Tail(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := logMsgs(rs); got != "[m2 m3 m4]" {
+		t.Fatalf("tail is %s", got)
+	}
+
+	for i := 5; i < 7; i++ {
+		if err := f.WriteRecord(rec(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if bs := f.Backups(); len(bs) != 2 {
+		t.Fatalf("backups are not purged: %v", bs)
+	}
+	rs, err = f.Tail(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := logMsgs(rs); got != "[m2 m3 m4 m5 m6]" {
+		t.Fatalf("tail is %s", got)
+	}
+}
+
+//TestLogRing checks the ring keeps the last records written through a
+//logger, oldest first, and the filtered levels are not kept.
+func TestLogRing(t *testing.T) {
+	ring := NewLogRing(3)
+	log := NewLevelLogger(LevelWarn, ring)
+
+	log.Warn("w0")
+	log.Info("i0")
+	if got := logMsgs(ring.Records(0)); got != "[w0]" {
+		t.Fatalf("records are %s", got)
+	}
+
+	for i := 1; i < 5; i++ {
+		log.Error(fmt.Sprintf("e%d", i), "trader", "alice")
+	}
+	if got := logMsgs(ring.Records(0)); got != "[e2 e3 e4]" {
+		t.Fatalf("records are %s", got)
+	}
+	rs := ring.Records(2)
+	if got := logMsgs(rs); got != "[e3 e4]" {
+		t.Fatalf("last records are %s", got)
+	}
+	if v, ok := rs[1].Field("trader"); !ok || v != "alice" {
+		t.Fatalf("field is %v", v)
+	}
+}
@@ -0,0 +1,325 @@
+package miniquet
+
+import (
+	"io"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+	"bytes"
+	"strings"
+	"net/http"
+)
+
+import (
+	"github.com/vouquet/go-gmo-coin/gomocoin"
+)
+
+const (
+	MetricCounter   string = "counter"
+	MetricGauge     string = "gauge"
+	MetricHistogram string = "histogram"
+
+	MetricsPath string = "/metrics"
+)
+
+var (
+	//DefaultBuckets are the upper bounds of histogram buckets in seconds.
+	DefaultBuckets []float64 = []float64{
+		0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10,
+	}
+)
+
+type MetricsConfig struct {
+	Listen string
+}
+
+//Metrics holds the metrics of a running miniquet and writes them in the
+//Prometheus text format.
+type Metrics struct {
+	rate_fetch   *metricVec
+	rate_errors  *metricVec
+	tick_lag     *metricVec
+	storage      *metricVec
+	submitted    *metricVec
+	failed       *metricVec
+	filled       *metricVec
+
+	traders func() []*Trader
+
+	mtx *sync.Mutex
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		rate_fetch: newMetricVec("miniquet_rate_fetch_seconds",
+				"Latency of fetching the rates.", MetricHistogram),
+		rate_errors: newMetricVec("miniquet_rate_fetch_errors_total",
+				"Failed rate fetches.", MetricCounter),
+		tick_lag: newMetricVec("miniquet_tick_lag_seconds",
+				"Delay from a tick until the traders get the rates.", MetricHistogram),
+		storage: newMetricVec("miniquet_storage_op_seconds",
+				"Latency of storage operations.", MetricHistogram, "op"),
+		submitted: newMetricVec("miniquet_orders_submitted_total",
+				"Orders submitted.", MetricCounter, "trader", "symbol"),
+		failed: newMetricVec("miniquet_orders_failed_total",
+				"Orders failed.", MetricCounter, "trader", "symbol"),
+		filled: newMetricVec("miniquet_orders_filled_total",
+				"Orders filled.", MetricCounter, "trader", "symbol"),
+		mtx: new(sync.Mutex),
+	}
+}
+
+//TraderSource sets the function returning the traders whose Win and
+//entries are reported at every scrape.
+func (self *Metrics) TraderSource(f func() []*Trader) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	self.traders = f
+}
+
+func (self *Metrics) ObserveRateFetch(d time.Duration, err error) {
+	if self == nil {
+		return
+	}
+	self.rate_fetch.observe(d.Seconds())
+	if err != nil {
+		self.rate_errors.add(1)
+	}
+}
+
+func (self *Metrics) ObserveTickLag(d time.Duration) {
+	if self == nil {
+		return
+	}
+	self.tick_lag.observe(d.Seconds())
+}
+
+func (self *Metrics) ObserveStorage(op string, d time.Duration) {
+	if self == nil {
+		return
+	}
+	self.storage.observe(d.Seconds(), op)
+}
+
+//Listen counts the orders published on the subscription until it is closed.
+func (self *Metrics) Listen(sub *Subscription) {
+	go func() {
+		for ev := range sub.C() {
+			switch e := ev.(type) {
+			case *OrderSubmitted:
+				self.submitted.add(1, e.Trader, e.Symbol)
+			case *OrderFilled:
+				self.filled.add(1, e.Trader, e.Symbol)
+			case *TradeFailed:
+				self.failed.add(1, e.Trader, e.Symbol)
+			}
+		}
+	}()
+}
+
+func (self *Metrics) WriteText(w io.Writer) error {
+	buf := new(bytes.Buffer)
+	for _, v := range []*metricVec{self.rate_fetch, self.rate_errors, self.tick_lag,
+			self.storage, self.submitted, self.failed, self.filled} {
+		v.write(buf)
+	}
+	for _, v := range self.collectTraders() {
+		v.write(buf)
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func (self *Metrics) collectTraders() []*metricVec {
+	win := newMetricVec("miniquet_trader_win",
+				"Win of the trader.", MetricGauge, "trader")
+	e_win := newMetricVec("miniquet_entry_win",
+				"Win of the entry.", MetricGauge, "trader", "entry", "symbol")
+	count := newMetricVec("miniquet_entries",
+				"Entries by state.", MetricGauge, "trader", "state")
+
+	self.mtx.Lock()
+	f := self.traders
+	self.mtx.Unlock()
+	if f == nil {
+		return nil
+	}
+
+	for _, tr := range f() {
+		closed, _ := tr.ClosedWin()
+		win.set(tr.Win(), tr.Name())
+		count.set(float64(closed), tr.Name(), "archived")
+		count.set(float64(len(tr.Killed())), tr.Name(), "killed")
+		count.set(0, tr.Name(), "buy")
+		count.set(0, tr.Name(), "sell")
+		count.set(0, tr.Name(), "stopping")
+
+		//EntryInfos copies the entries under the lock of the trader, as
+		//Do turns them while the metrics are scraped.
+		for _, e := range tr.EntryInfos() {
+			e_win.set(e.Win, tr.Name(), e.Id, e.Symbol)
+
+			state := "buy"
+			if e.Position == gomocoin.SIDE_SELL {
+				state = "sell"
+			}
+			if e.Stopping {
+				state = "stopping"
+			}
+			count.add(1, tr.Name(), state)
+		}
+	}
+	return []*metricVec{win, e_win, count}
+}
+
+func (self *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	self.WriteText(w)
+}
+
+//Serve starts the metrics listener at addr. Close the returned server to
+//stop it.
+func (self *Metrics) Serve(addr string) (*http.Server, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(MetricsPath, self)
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(l)
+	return srv, nil
+}
+
+type metricVec struct {
+	name    string
+	help    string
+	typ     string
+	labels  []string
+	buckets []float64
+
+	vals map[string]*metricValue
+
+	mtx *sync.Mutex
+}
+
+type metricValue struct {
+	labels []string
+	val    float64
+
+	counts []uint64
+	count  uint64
+}
+
+func newMetricVec(name string, help string, typ string, labels ...string) *metricVec {
+	self := &metricVec{
+		name: name,
+		help: help,
+		typ: typ,
+		labels: labels,
+		vals: make(map[string]*metricValue),
+		mtx: new(sync.Mutex),
+	}
+	if typ == MetricHistogram {
+		self.buckets = DefaultBuckets
+	}
+	return self
+}
+
+func (self *metricVec) get(lvs []string) *metricValue {
+	key := strings.Join(lvs, "\xff")
+	v, ok := self.vals[key]
+	if !ok {
+		v = &metricValue{labels: lvs, counts: make([]uint64, len(self.buckets))}
+		self.vals[key] = v
+	}
+	return v
+}
+
+func (self *metricVec) add(d float64, lvs ...string) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	self.get(lvs).val += d
+}
+
+func (self *metricVec) set(val float64, lvs ...string) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	self.get(lvs).val = val
+}
+
+//observe adds a sample to a histogram. val holds the sum of the samples.
+func (self *metricVec) observe(sample float64, lvs ...string) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	v := self.get(lvs)
+	for i, le := range self.buckets {
+		if sample <= le {
+			v.counts[i]++
+		}
+	}
+	v.count++
+	v.val += sample
+}
+
+func (self *metricVec) write(w io.Writer) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", self.name, self.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", self.name, self.typ)
+
+	keys := make([]string, 0, len(self.vals))
+	for k, _ := range self.vals {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v := self.vals[k]
+		if self.typ != MetricHistogram {
+			fmt.Fprintf(w, "%s%s %v\n", self.name, self.labelText(v.labels), v.val)
+			continue
+		}
+
+		for i, le := range self.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", self.name,
+					self.labelText(v.labels, "le", fmt.Sprint(le)), v.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", self.name,
+				self.labelText(v.labels, "le", "+Inf"), v.count)
+		fmt.Fprintf(w, "%s_sum%s %v\n", self.name, self.labelText(v.labels), v.val)
+		fmt.Fprintf(w, "%s_count%s %d\n", self.name, self.labelText(v.labels), v.count)
+	}
+}
+
+func (self *metricVec) labelText(lvs []string, extra ...string) string {
+	pairs := []string{}
+	for i, name := range self.labels {
+		if i < len(lvs) {
+			pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", name, escapeLabel(lvs[i])))
+		}
+	}
+	for i := 0; i + 1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", extra[i], escapeLabel(extra[i + 1])))
+	}
+
+	if len(pairs) < 1 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escapeLabel(s string) string {
+	s = strings.Replace(s, "\\", "\\\\", -1)
+	s = strings.Replace(s, "\"", "\\\"", -1)
+	return strings.Replace(s, "\n", "\\n", -1)
+}
@@ -0,0 +1,77 @@
+package miniquet
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"io/ioutil"
+)
+
+import (
+	"github.com/vouquet/shop"
+)
+
+type testRate struct {
+	symbol string
+	ask    float64
+	bid    float64
+}
+
+func (self *testRate) Symbol() string {
+	return self.symbol
+}
+
+func (self *testRate) Ask() float64 {
+	return self.ask
+}
+
+func (self *testRate) Bid() float64 {
+	return self.bid
+}
+
+//TestMetricsScrapeWhileDo scrapes the entries while Do changes them. Run
+//it with -race.
+func TestMetricsScrapeWhileDo(t *testing.T) {
+	tr := NewTrader("alice", "", nil, NewMemStorage())
+	tr.SetCheckFunc(func(e *Entry, ask float64, bid float64) bool {
+		e.Win += 1
+		e.StateOf(StrategyPoint).SetFloat(STATE_POINT_DIFF, bid - e.Last_fix_rate)
+		return false
+	})
+	e := NewEntry("alice", "BTC", 0.1, 100)
+	if err := tr.RequestAppend(e); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewMetrics()
+	m.TraderSource(func() []*Trader { return []*Trader{tr} })
+
+	log := NewLevelLogger(LevelError, NewWriterSink(ioutil.Discard))
+	rates := map[string]shop.Rate{"BTC": &testRate{symbol: "BTC", ask: 101, bid: 99}}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			tr.Do(log, rates)
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		if err := m.WriteText(new(bytes.Buffer)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+
+	buf := new(bytes.Buffer)
+	if err := m.WriteText(buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `miniquet_entry_win{trader="alice",entry="` + e.Id() + `",symbol="BTC"} 100`
+	if !strings.Contains(buf.String(), want) {
+		t.Fatalf("want %s in\n%s", want, buf)
+	}
+	if !strings.Contains(buf.String(), `miniquet_entries{trader="alice",state="buy"} 1`) {
+		t.Fatalf("entry is not counted:\n%s", buf)
+	}
+}
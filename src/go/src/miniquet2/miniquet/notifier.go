@@ -0,0 +1,427 @@
+package miniquet
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	NoticeTrade        NoticeType = "trade"
+	NoticeError        NoticeType = "error"
+	NoticeRiskBreach   NoticeType = "risk-breach"
+	NoticeDailySummary NoticeType = "daily-summary"
+
+	NotifierTypeWebhook string = "webhook"
+	NotifierTypeMail    string = "mail"
+	NotifierTypeTwitter string = "twitter"
+	NotifierTypeExec    string = "exec"
+
+	SIZE_NOTICE_BUFFER int = 32
+	DefaultRatePeriod  time.Duration = time.Minute
+)
+
+type NoticeType string
+
+type Notice struct {
+	Type    NoticeType `json:"type"`
+	Time    time.Time  `json:"time"`
+	Trader  string     `json:"trader,omitempty"`
+	Symbol  string     `json:"symbol,omitempty"`
+	EntryId string     `json:"entry_id,omitempty"`
+	Message string     `json:"message"`
+}
+
+func NewNotice(t NoticeType, s string, msg ...interface{}) *Notice {
+	return &Notice{
+		Type: t,
+		Time: time.Now(),
+		Message: fmt.Sprintf(s, msg...),
+	}
+}
+
+func (self *Notice) String() string {
+	head := "[" + string(self.Type) + "]"
+	if self.Trader != "" {
+		head += " " + self.Trader
+	}
+	if self.Symbol != "" {
+		head += " " + self.Symbol
+	}
+	return head + ": " + self.Message
+}
+
+type Notifier interface {
+	Name() string
+	Notify(*Notice) error
+	Close() error
+}
+
+type NotifierConfig struct {
+	Name       string
+	Type       string
+
+	Events     []string
+	Traders    []string
+	Symbols    []string
+	RateLimit  int
+	RatePeriod string
+
+	//webhook
+	Url        string
+
+	//mail
+	SmtpHost     string
+	SmtpPort     int
+	SmtpUser     string
+	SmtpPassword string
+	MailFrom     string
+	MailTo       []string
+
+	//twitter
+	ConsumerKey    string
+	ConsumerSecret string
+	Token          string
+	AccessSecret   string
+
+	//exec
+	Command    string
+	Args       []string
+}
+
+func NewNotifier(c *NotifierConfig) (Notifier, error) {
+	if c.Name == "" {
+		return nil, fmt.Errorf("notifier has no name.")
+	}
+
+	switch c.Type {
+	case NotifierTypeWebhook:
+		return NewWebhookNotifier(c)
+	case NotifierTypeMail:
+		return NewMailNotifier(c)
+	case NotifierTypeTwitter:
+		return NewTwitterNotifier(c)
+	case NotifierTypeExec:
+		return NewExecNotifier(c)
+	}
+	return nil, fmt.Errorf("notifier '%s' has unknown type: '%s'", c.Name, c.Type)
+}
+
+type NotifyHub struct {
+	sinks    []*notifySink
+	err_hdlr func(string, ...interface{})
+
+	//subs are the subscriptions listened, and wg waits for their
+	//goroutines on Close.
+	subs     []*Subscription
+	wg       *sync.WaitGroup
+	closed   bool
+
+	mtx *sync.Mutex
+}
+
+func NewNotifyHub(confs []NotifierConfig) (*NotifyHub, error) {
+	self := &NotifyHub{
+		sinks: make([]*notifySink, 0, len(confs)),
+		subs: make([]*Subscription, 0),
+		wg: new(sync.WaitGroup),
+		mtx: new(sync.Mutex),
+	}
+
+	for i := range confs {
+		sink, err := newNotifySink(&confs[i], self.callErrorHandler)
+		if err != nil {
+			self.Close()
+			return nil, err
+		}
+		self.sinks = append(self.sinks, sink)
+	}
+	return self, nil
+}
+
+func (self *NotifyHub) Name() string {
+	return "hub"
+}
+
+func (self *NotifyHub) ErrorHandler(f func(string, ...interface{})) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	self.err_hdlr = f
+}
+
+func (self *NotifyHub) callErrorHandler(s string, msg ...interface{}) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	if self.err_hdlr == nil {
+		return
+	}
+	self.err_hdlr(s, msg...)
+}
+
+func (self *NotifyHub) Notify(n *Notice) error {
+	if n == nil {
+		return fmt.Errorf("notice is nil pointer.")
+	}
+
+	for _, sink := range self.sinks {
+		sink.push(n)
+	}
+	return nil
+}
+
+//Listen turns the trade and risk events of the subscription into notices until the
+//subscription is closed. Close closes the subscription.
+func (self *NotifyHub) Listen(sub *Subscription) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	if self.closed {
+		sub.Close()
+		return
+	}
+	self.subs = append(self.subs, sub)
+
+	self.wg.Add(1)
+	go func() {
+		defer self.wg.Done()
+
+		for ev := range sub.C() {
+			n := eventNotice(ev)
+			if n == nil {
+				continue
+			}
+			self.Notify(n)
+		}
+	}()
+}
+
+func eventNotice(ev Event) *Notice {
+	switch e := ev.(type) {
+	case *OrderFilled:
+		return entryNotice(NoticeTrade, &e.EntryEvent, "%s %.5f at %.3f, order_id: '%s', win: %.3f",
+										e.Position, e.Size, e.Rate, e.OrderId, e.Win)
+	case *EntryClosed:
+		return entryNotice(NoticeTrade, &e.EntryEvent, "closed, win: %.3f", e.Win)
+	case *TradeFailed:
+		return entryNotice(NoticeError, &e.EntryEvent, "failed the trade: %s", e.Err)
+	case *RiskBreach:
+		n := NewNotice(NoticeRiskBreach, "%s", e.Reason)
+		n.Time = e.At
+		n.Trader = e.Trader
+		n.Symbol = e.Symbol
+		return n
+	}
+	return nil
+}
+
+func entryNotice(t NoticeType, e *EntryEvent, s string, msg ...interface{}) *Notice {
+	n := NewNotice(t, s, msg...)
+	n.Time = e.At
+	n.Trader = e.Trader
+	n.Symbol = e.Symbol
+	n.EntryId = e.EntryId
+	return n
+}
+
+//Close unsubscribes the subscriptions listened, and waits for the
+//notices left in them before closing the sinks.
+func (self *NotifyHub) Close() error {
+	self.mtx.Lock()
+	if self.closed {
+		self.mtx.Unlock()
+		return nil
+	}
+	self.closed = true
+	subs := self.subs
+	self.subs = nil
+	self.mtx.Unlock()
+
+	for _, sub := range subs {
+		sub.Close()
+	}
+	self.wg.Wait()
+
+	for _, sink := range self.sinks {
+		sink.close()
+	}
+	return nil
+}
+
+type notifySink struct {
+	n       Notifier
+	filter  *noticeFilter
+	limiter *rateLimiter
+
+	ch       chan *Notice
+	done     chan struct{}
+	closed   bool
+	err_hdlr func(string, ...interface{})
+
+	mtx *sync.Mutex
+}
+
+func newNotifySink(c *NotifierConfig, err_hdlr func(string, ...interface{})) (*notifySink, error) {
+	filter, err := newNoticeFilter(c)
+	if err != nil {
+		return nil, err
+	}
+
+	period := DefaultRatePeriod
+	if c.RatePeriod != "" {
+		p, err := time.ParseDuration(c.RatePeriod)
+		if err != nil {
+			return nil, fmt.Errorf("notifier '%s' has invalid RatePeriod: %s", c.Name, err)
+		}
+		period = p
+	}
+
+	n, err := NewNotifier(c)
+	if err != nil {
+		return nil, err
+	}
+
+	self := &notifySink{
+		n: n,
+		filter: filter,
+		limiter: newRateLimiter(c.RateLimit, period),
+		ch: make(chan *Notice, SIZE_NOTICE_BUFFER),
+		done: make(chan struct{}),
+		err_hdlr: err_hdlr,
+		mtx: new(sync.Mutex),
+	}
+	go self.run()
+	return self, nil
+}
+
+func (self *notifySink) push(n *Notice) {
+	if !self.filter.Match(n) {
+		return
+	}
+	if !self.limiter.Allow(n.Time) {
+		return
+	}
+
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	if self.closed {
+		return
+	}
+	select {
+	case self.ch <- n:
+	default:
+		self.err_hdlr("notifier '%s' is busy, dropped a notice: %s", self.n.Name(), n)
+	}
+}
+
+func (self *notifySink) run() {
+	defer close(self.done)
+
+	for n := range self.ch {
+		if err := self.n.Notify(n); err != nil {
+			self.err_hdlr("notifier '%s' failed: %s", self.n.Name(), err)
+		}
+	}
+}
+
+func (self *notifySink) close() {
+	self.mtx.Lock()
+	if self.closed {
+		self.mtx.Unlock()
+		return
+	}
+	self.closed = true
+	close(self.ch)
+	self.mtx.Unlock()
+
+	<- self.done
+
+	self.n.Close()
+}
+
+type noticeFilter struct {
+	events  map[NoticeType]bool
+	traders map[string]bool
+	symbols map[string]bool
+}
+
+func newNoticeFilter(c *NotifierConfig) (*noticeFilter, error) {
+	self := &noticeFilter{
+		events: make(map[NoticeType]bool),
+		traders: make(map[string]bool),
+		symbols: make(map[string]bool),
+	}
+
+	for _, ev := range c.Events {
+		t := NoticeType(ev)
+		switch t {
+		case NoticeTrade, NoticeError, NoticeRiskBreach, NoticeDailySummary:
+		default:
+			return nil, fmt.Errorf("notifier '%s' has unknown event: '%s'", c.Name, ev)
+		}
+		self.events[t] = true
+	}
+	for _, tr := range c.Traders {
+		self.traders[tr] = true
+	}
+	for _, sym := range c.Symbols {
+		self.symbols[sym] = true
+	}
+	return self, nil
+}
+
+//Match reports whether the notice passes the filter. An empty list
+//accepts everything, and notices without a trader or symbol are not
+//filtered by that list.
+func (self *noticeFilter) Match(n *Notice) bool {
+	if len(self.events) > 0 && !self.events[n.Type] {
+		return false
+	}
+	if len(self.traders) > 0 && n.Trader != "" && !self.traders[n.Trader] {
+		return false
+	}
+	if len(self.symbols) > 0 && n.Symbol != "" && !self.symbols[n.Symbol] {
+		return false
+	}
+	return true
+}
+
+type rateLimiter struct {
+	limit  int
+	period time.Duration
+	sent   []time.Time
+
+	mtx *sync.Mutex
+}
+
+func newRateLimiter(limit int, period time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit: limit,
+		period: period,
+		sent: make([]time.Time, 0, limit),
+		mtx: new(sync.Mutex),
+	}
+}
+
+func (self *rateLimiter) Allow(now time.Time) bool {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	if self.limit < 1 {
+		return true
+	}
+
+	head := 0
+	for head < len(self.sent) && now.Sub(self.sent[head]) >= self.period {
+		head++
+	}
+	self.sent = self.sent[head:]
+
+	if len(self.sent) >= self.limit {
+		return false
+	}
+	self.sent = append(self.sent, now)
+	return true
+}
@@ -0,0 +1,71 @@
+package miniquet
+
+import (
+	"os"
+	"os/exec"
+	"fmt"
+	"time"
+	"bytes"
+	"context"
+	"encoding/json"
+)
+
+const (
+	ExecTimeout time.Duration = 30 * time.Second
+
+	EnvNoticePrefix string = "MINIQUET_NOTICE_"
+)
+
+//ExecNotifier runs a local command for each notice. The notice is given
+//as JSON on stdin and as MINIQUET_NOTICE_* environment variables.
+type ExecNotifier struct {
+	name string
+	cmd  string
+	args []string
+}
+
+func NewExecNotifier(c *NotifierConfig) (*ExecNotifier, error) {
+	if c.Command == "" {
+		return nil, fmt.Errorf("notifier '%s' has no Command.", c.Name)
+	}
+
+	return &ExecNotifier{
+		name: c.Name,
+		cmd: c.Command,
+		args: c.Args,
+	}, nil
+}
+
+func (self *ExecNotifier) Name() string {
+	return self.name
+}
+
+func (self *ExecNotifier) Notify(n *Notice) error {
+	b, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ExecTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, self.cmd, self.args...)
+	cmd.Stdin = bytes.NewReader(b)
+	cmd.Env = append(os.Environ(),
+		EnvNoticePrefix + "TYPE=" + string(n.Type),
+		EnvNoticePrefix + "TRADER=" + n.Trader,
+		EnvNoticePrefix + "SYMBOL=" + n.Symbol,
+		EnvNoticePrefix + "ENTRY=" + n.EntryId,
+		EnvNoticePrefix + "MESSAGE=" + n.Message,
+	)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+func (self *ExecNotifier) Close() error {
+	return nil
+}
@@ -0,0 +1,70 @@
+package miniquet
+
+import (
+	"fmt"
+	"strings"
+	"net/smtp"
+)
+
+const (
+	DefaultSmtpPort int = 587
+)
+
+type MailNotifier struct {
+	name string
+	addr string
+	auth smtp.Auth
+	from string
+	to   []string
+}
+
+func NewMailNotifier(c *NotifierConfig) (*MailNotifier, error) {
+	if c.SmtpHost == "" {
+		return nil, fmt.Errorf("notifier '%s' has no SmtpHost.", c.Name)
+	}
+	if c.MailFrom == "" || len(c.MailTo) < 1 {
+		return nil, fmt.Errorf("notifier '%s' needs MailFrom and MailTo.", c.Name)
+	}
+
+	port := c.SmtpPort
+	if port == 0 {
+		port = DefaultSmtpPort
+	}
+
+	var auth smtp.Auth
+	if c.SmtpUser != "" {
+		auth = smtp.PlainAuth("", c.SmtpUser, c.SmtpPassword, c.SmtpHost)
+	}
+
+	return &MailNotifier{
+		name: c.Name,
+		addr: fmt.Sprintf("%s:%d", c.SmtpHost, port),
+		auth: auth,
+		from: c.MailFrom,
+		to: c.MailTo,
+	}, nil
+}
+
+func (self *MailNotifier) Name() string {
+	return self.name
+}
+
+func (self *MailNotifier) Notify(n *Notice) error {
+	subject := fmt.Sprintf("[miniquet2] %s", n.Type)
+	if n.Trader != "" {
+		subject += " " + n.Trader
+	}
+
+	body := new(strings.Builder)
+	fmt.Fprintf(body, "From: %s\r\n", self.from)
+	fmt.Fprintf(body, "To: %s\r\n", strings.Join(self.to, ", "))
+	fmt.Fprintf(body, "Subject: %s\r\n", subject)
+	fmt.Fprintf(body, "Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	fmt.Fprintf(body, "%s\r\n\r\n%s\r\n", n.Time.Format("2006-01-02 15:04:05"), n.String())
+
+	return smtp.SendMail(self.addr, self.auth, self.from, self.to, []byte(body.String()))
+}
+
+func (self *MailNotifier) Close() error {
+	return nil
+}
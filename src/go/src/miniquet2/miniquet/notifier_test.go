@@ -0,0 +1,75 @@
+package miniquet
+
+import (
+	"sync"
+	"testing"
+	"time"
+	"net/http"
+	"net/http/httptest"
+)
+
+//TestNotifyHubCloseWhileListening closes the hub while events are still
+//buffered in its subscription, as Reload does with the old hub. The
+//buffered notices reach the sink before Close returns.
+func TestNotifyHubCloseWhileListening(t *testing.T) {
+	var mtx sync.Mutex
+	got := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		got++
+		mtx.Unlock()
+	}))
+	defer srv.Close()
+
+	//fewer than the buffer of the sink, so that none is dropped.
+	n := SIZE_NOTICE_BUFFER / 2
+	for i := 0; i < 20; i++ {
+		bus := NewEventBus()
+		hub, err := NewNotifyHub([]NotifierConfig{{Name: "hook", Type: NotifierTypeWebhook, Url: srv.URL}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		hub.ErrorHandler(func(s string, msg ...interface{}) {
+			t.Errorf(s, msg...)
+		})
+		hub.Listen(bus.Subscribe(SIZE_EVENT_BUFFER, DropOldest, EventRiskBreach))
+
+		for j := 0; j < n; j++ {
+			bus.Publish(&RiskBreach{At: time.Now(), Trader: "alice", Reason: "test"})
+		}
+		if err := hub.Close(); err != nil {
+			t.Fatal(err)
+		}
+		mtx.Lock()
+		if want := (i + 1) * n; got != want {
+			mtx.Unlock()
+			t.Fatalf("round %d: %d notices are sent, want %d", i, got, want)
+		}
+		mtx.Unlock()
+
+		//a late notice is dropped, not sent on the closed sink.
+		hub.Notify(NewNotice(NoticeError, "late"))
+		bus.Publish(&RiskBreach{At: time.Now(), Trader: "alice", Reason: "late"})
+	}
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	if want := 20 * n; got != want {
+		t.Fatalf("late notices are sent: %d, want %d", got, want)
+	}
+}
+
+func TestNotifyHubListenAfterClose(t *testing.T) {
+	bus := NewEventBus()
+	hub, err := NewNotifyHub(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub.Close()
+
+	sub := bus.Subscribe(1, DropOldest)
+	hub.Listen(sub)
+	if _, ok := <- sub.C(); ok {
+		t.Fatal("subscription is not closed.")
+	}
+}
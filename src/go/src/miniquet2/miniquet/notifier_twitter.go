@@ -0,0 +1,64 @@
+package miniquet
+
+import (
+	"fmt"
+	"sync"
+)
+
+import (
+	"github.com/dghubble/oauth1"
+	"github.com/dghubble/go-twitter/twitter"
+)
+
+const (
+	TweetLimit int = 280
+)
+
+type TwitterNotifier struct {
+	name string
+	cl   *twitter.Client
+
+	mtx  *sync.Mutex
+}
+
+func NewTwitterNotifier(c *NotifierConfig) (*TwitterNotifier, error) {
+	if c.ConsumerKey == "" || c.Token == "" {
+		return nil, fmt.Errorf("notifier '%s' has no twitter credentials.", c.Name)
+	}
+
+	config := oauth1.NewConfig(c.ConsumerKey, c.ConsumerSecret)
+	token := oauth1.NewToken(c.Token, c.AccessSecret)
+	httpClient := config.Client(oauth1.NoContext, token)
+
+	client := twitter.NewClient(httpClient)
+
+	return &TwitterNotifier{
+		name: c.Name,
+		cl: client,
+		mtx: new(sync.Mutex),
+	}, nil
+}
+
+func (self *TwitterNotifier) Name() string {
+	return self.name
+}
+
+func (self *TwitterNotifier) Notify(n *Notice) error {
+	msg := []rune(n.String())
+	if len(msg) > TweetLimit {
+		msg = append(msg[:TweetLimit - 3], []rune("...")...)
+	}
+	return self.Tweet(string(msg))
+}
+
+func (self *TwitterNotifier) Tweet(msg string) error {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	_, _, err := self.cl.Statuses.Update(msg, nil)
+	return err
+}
+
+func (self *TwitterNotifier) Close() error {
+	return nil
+}
@@ -0,0 +1,59 @@
+package miniquet
+
+import (
+	"fmt"
+	"time"
+	"bytes"
+	"net/http"
+	"encoding/json"
+)
+
+const (
+	WebhookTimeout time.Duration = 10 * time.Second
+)
+
+type WebhookNotifier struct {
+	name string
+	url  string
+
+	cl   *http.Client
+}
+
+func NewWebhookNotifier(c *NotifierConfig) (*WebhookNotifier, error) {
+	if c.Url == "" {
+		return nil, fmt.Errorf("notifier '%s' has no Url.", c.Name)
+	}
+
+	return &WebhookNotifier{
+		name: c.Name,
+		url: c.Url,
+		cl: &http.Client{Timeout: WebhookTimeout},
+	}, nil
+}
+
+func (self *WebhookNotifier) Name() string {
+	return self.name
+}
+
+func (self *WebhookNotifier) Notify(n *Notice) error {
+	b, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	resp, err := self.cl.Post(self.url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (self *WebhookNotifier) Close() error {
+	self.cl.CloseIdleConnections()
+	return nil
+}
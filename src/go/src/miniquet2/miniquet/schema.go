@@ -0,0 +1,234 @@
+package miniquet
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+import (
+	"github.com/ugorji/go/codec"
+)
+
+const (
+	//SCHEMA_VERSION is the version of the Entry records written by this
+	//build. Add a Migration when the Entry is changed.
+	SCHEMA_VERSION int = 3
+
+	KEY_SCHEMA_VERSION string = NS_META + "schema_version"
+)
+
+//Migration upgrades a record to Version. The record is the decoded msgpack
+//map of an Entry, so a migration can read fields which were removed from
+//the Entry.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(rec map[string]interface{}) error
+}
+
+var (
+	Migrations []*Migration = []*Migration{
+		&Migration{
+			Version: 1,
+			Name: "record the schema version",
+			Up: func(rec map[string]interface{}) error { return nil },
+		},
+		&Migration{
+			Version: 2,
+			Name: "move the scratch buffers into the state",
+			Up: migrateGb,
+		},
+		&Migration{
+			Version: 3,
+			Name: "move the entries into the entries namespace",
+			//the keys are moved by migrate.
+			Up: func(rec map[string]interface{}) error { return nil },
+		},
+	}
+)
+
+//StorageVersionError is returned when the storage was written by a newer
+//miniquet2.
+type StorageVersionError struct {
+	Path    string
+	Version int
+}
+
+func (self *StorageVersionError) Error() string {
+	return fmt.Sprintf("%s was written by a newer miniquet2 (schema version %d, supported %d). update miniquet2.",
+										self.Path, self.Version, SCHEMA_VERSION)
+}
+
+//SchemaVersion returns the schema version recorded in the storage. A
+//storage without it was written before the version was recorded.
+func (self *Storage) SchemaVersion() (int, error) {
+	self.lock()
+	defer self.unlock()
+
+	if self.b == nil {
+		return 0, fmt.Errorf("target database is nil pointer.")
+	}
+	return schemaVersion(self.b)
+}
+
+func schemaVersion(bk Backend) (int, error) {
+	b, err := bk.Get([]byte(KEY_SCHEMA_VERSION))
+	if err == ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	v, err := strconv.Atoi(string(b))
+	if err != nil {
+		return 0, fmt.Errorf("broken schema version '%s'.", string(b))
+	}
+	return v, nil
+}
+
+//migrate upgrades every entry older than SCHEMA_VERSION, moves the
+//entries of the flat keyspace into NS_ENTRIES, rebuilds the indexes and
+//records the version. A record which cannot be decoded is quarantined.
+//All is written in one batch, so a failed migration leaves the storage
+//untouched.
+func migrate(bk Backend) error {
+	batch := NewBatch()
+	idx := [][]byte{}
+	err := bk.Range(nil, func(key []byte, val []byte) error {
+		if hasPrefix(key, NS_INDEX) {
+			batch.Delete(key)
+			return nil
+		}
+		if !hasPrefix(key, NS_ENTRIES) && !isFlatKey(key) {
+			return nil
+		}
+
+		e, err := decode(val)
+		if err != nil {
+			_, err := quarantine(batch, key, val, err)
+			return err
+		}
+		b, err := encode(e)
+		if err != nil {
+			return fmt.Errorf("record '%s': %s", string(key), err)
+		}
+		if isFlatKey(key) {
+			batch.Delete(key)
+		}
+		batch.Put(entryKey(e.Id()), b)
+		idx = append(idx, indexKeys(e)...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, k := range idx {
+		batch.Put(k, []byte{})
+	}
+	batch.Put([]byte(KEY_SCHEMA_VERSION), []byte(strconv.Itoa(SCHEMA_VERSION)))
+	return bk.Write(batch)
+}
+
+//upgrade applies the migrations newer than the version of the record.
+func upgrade(b []byte, version int) ([]byte, error) {
+	rec := make(map[string]interface{})
+	if err := codec.NewDecoderBytes(b, MsgpckHndl).Decode(&rec); err != nil {
+		return nil, err
+	}
+	if err := upgradeRecord(rec, version); err != nil {
+		return nil, err
+	}
+
+	var out []byte
+	if err := codec.NewEncoderBytes(&out, MsgpckHndl).Encode(rec); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func upgradeRecord(rec map[string]interface{}, version int) error {
+	for _, m := range Migrations {
+		if m.Version <= version {
+			continue
+		}
+		if err := m.Up(rec); err != nil {
+			return fmt.Errorf("migration %d '%s': %s", m.Version, m.Name, err)
+		}
+		rec["Version"] = m.Version
+	}
+	return nil
+}
+
+//migrateGb moves Gb01-Gb04 into the State. Gb02 was the diff of the point
+//strategy, the others had no owner and are kept under STATE_NS_LEGACY.
+func migrateGb(rec map[string]interface{}) error {
+	var st State
+	for _, k := range []string{"Gb01", "Gb02"} {
+		v, ok := rec[k]
+		if !ok {
+			continue
+		}
+		delete(rec, k)
+
+		f, err := toFloat(v)
+		if err != nil {
+			return fmt.Errorf("%s: %s", k, err)
+		}
+		if f == 0 {
+			continue
+		}
+		key := STATE_NS_LEGACY + "/" + strings.ToLower(k)
+		if k == "Gb02" {
+			key = StrategyPoint + "/" + STATE_POINT_DIFF
+		}
+		st = st.put(&StateValue{Key: key, Type: STATE_FLOAT, Float: f})
+	}
+	for _, k := range []string{"Gb03", "Gb04"} {
+		v, ok := rec[k]
+		if !ok {
+			continue
+		}
+		delete(rec, k)
+
+		var b []byte
+		switch t := v.(type) {
+		case nil:
+		case []byte:
+			b = t
+		case string:
+			b = []byte(t)
+		default:
+			return fmt.Errorf("%s: unexpected type %T.", k, v)
+		}
+		if len(b) < 1 {
+			continue
+		}
+		st = st.put(&StateValue{Key: STATE_NS_LEGACY + "/" + strings.ToLower(k), Type: STATE_BYTES, Bytes: b})
+	}
+
+	if len(st) > 0 {
+		rec["State"] = st
+	}
+	return nil
+}
+
+func toFloat(v interface{}) (float64, error) {
+	switch t := v.(type) {
+	case nil:
+		return 0, nil
+	case float64:
+		return t, nil
+	case float32:
+		return float64(t), nil
+	case int64:
+		return float64(t), nil
+	case uint64:
+		return float64(t), nil
+	case int:
+		return float64(t), nil
+	}
+	return 0, fmt.Errorf("unexpected type %T.", v)
+}
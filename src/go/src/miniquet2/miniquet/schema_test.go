@@ -0,0 +1,228 @@
+package miniquet
+
+import (
+	"os"
+	"time"
+	"testing"
+	"io/ioutil"
+	"path/filepath"
+)
+
+import (
+	"github.com/google/uuid"
+	"github.com/ugorji/go/codec"
+)
+
+//legacyEntry is the Entry before the schema version was recorded.
+type legacyEntry struct {
+	Uuid          uuid.UUID
+	Trader        string
+	Symbol        string
+	Position      string
+	Size          float64
+	Win           float64
+	Last_fix_rate float64
+	Last_fix_date time.Time
+	Gb01          float64
+	Gb02          float64
+	Gb03          []byte
+	Gb04          []byte
+	Last_run      bool
+}
+
+func newLegacy() *legacyEntry {
+	return &legacyEntry{Uuid: uuid.New(), Trader: "alice", Symbol: "BTC", Position: "BUY",
+			Size: 0.1, Last_fix_rate: 100, Last_fix_date: time.Now(),
+			Gb01: 0, Gb02: 3, Gb03: []byte{1, 2}, Gb04: []byte{}}
+}
+
+//writeLegacy writes the records as a miniquet2 before the schema version
+//did, and returns the path of the storage.
+func writeLegacy(t *testing.T, dir string, raw map[string][]byte, ls ...*legacyEntry) string {
+	path := filepath.Join(dir, "db")
+	b, err := openLevelBackend(path, DefaultStorageOpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	batch := NewBatch()
+	for _, l := range ls {
+		var val []byte
+		if err := codec.NewEncoderBytes(&val, MsgpckHndl).Encode(l); err != nil {
+			t.Fatal(err)
+		}
+		batch.Put([]byte(l.Uuid.String()), val)
+	}
+	for k, v := range raw {
+		batch.Put([]byte(k), v)
+	}
+	if err := b.Write(batch); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "miniquet2-schema-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestMigrateLegacy(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	l := newLegacy()
+	path := writeLegacy(t, dir, nil, l)
+
+	st, err := OpenStorage(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, err := st.SchemaVersion(); err != nil || v != SCHEMA_VERSION {
+		t.Fatalf("schema version %d, %v", v, err)
+	}
+	e, err := st.Get(l.Uuid.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Version != SCHEMA_VERSION || e.Trader != "alice" || e.Size != 0.1 {
+		t.Fatalf("unexpected entry: %+v", e)
+	}
+	if p := e.Point(); p != 0.03 {
+		t.Fatalf("Point is %v, want 0.03.", p)
+	}
+	if bs, ok := e.StateOf(STATE_NS_LEGACY).Bytes("gb03"); !ok || string(bs) != "\x01\x02" {
+		t.Fatalf("gb03 is %v, %v", bs, ok)
+	}
+	if _, ok := e.StateOf(STATE_NS_LEGACY).Float("gb01"); ok {
+		t.Fatal("zero gb01 is kept.")
+	}
+	if _, ok := e.StateOf(STATE_NS_LEGACY).Bytes("gb04"); ok {
+		t.Fatal("empty gb04 is kept.")
+	}
+
+	n := 0
+	err = st.ForEachByTrader("alice", func(*Entry) error {
+		n++
+		return nil
+	})
+	if err != nil || n != 1 {
+		t.Fatalf("index of the trader: %d entries, %v", n, err)
+	}
+	st.Close()
+
+	b, err := openLevelBackend(path, DefaultStorageOpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if _, err := b.Get([]byte(l.Uuid.String())); err != ErrNotFound {
+		t.Fatalf("flat key is left: %v", err)
+	}
+}
+
+//TestMigrateReadOnly checks a read only storage is migrated in memory,
+//and the file is left as it was.
+func TestMigrateReadOnly(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	l := newLegacy()
+	path := writeLegacy(t, dir, nil, l)
+
+	st, err := OpenStorage(path, &StorageOpt{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := st.Get(l.Uuid.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Version != SCHEMA_VERSION {
+		t.Fatalf("record is not upgraded: %d", e.Version)
+	}
+	st.Close()
+
+	b, err := openLevelBackend(path, DefaultStorageOpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if v, err := schemaVersion(b); err != nil || v != 0 {
+		t.Fatalf("read only storage is migrated: %d, %v", v, err)
+	}
+}
+
+func TestMigrateNewer(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := writeLegacy(t, dir, map[string][]byte{KEY_SCHEMA_VERSION: []byte("99")})
+
+	_, err := OpenStorage(path, nil)
+	if e, ok := err.(*StorageVersionError); !ok || e.Version != 99 {
+		t.Fatalf("want StorageVersionError, got %v", err)
+	}
+}
+
+//TestMigrateBroken checks an undecodable record is quarantined, and does
+//not stop the migration of the others.
+func TestMigrateBroken(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	l := newLegacy()
+	path := writeLegacy(t, dir, map[string][]byte{"broken": []byte{0xc1}}, l)
+
+	st, err := OpenStorage(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Get(l.Uuid.String()); err != nil {
+		t.Fatal(err)
+	}
+	st.Close()
+
+	b, err := openLevelBackend(path, DefaultStorageOpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if _, err := b.Get([]byte("broken")); err != ErrNotFound {
+		t.Fatalf("broken record is left: %v", err)
+	}
+	if _, err := b.Get(quarantineKey("broken")); err != nil {
+		t.Fatalf("broken record is not quarantined: %v", err)
+	}
+}
+
+func TestUpgradeRecord(t *testing.T) {
+	rec := map[string]interface{}{
+		"Gb01": int64(2),
+		"Gb02": float64(0),
+		"Gb03": "abc",
+		"Gb04": nil,
+	}
+	if err := upgradeRecord(rec, 0); err != nil {
+		t.Fatal(err)
+	}
+	if rec["Version"] != SCHEMA_VERSION {
+		t.Fatalf("version is %v", rec["Version"])
+	}
+	for _, k := range []string{"Gb01", "Gb02", "Gb03", "Gb04"} {
+		if _, ok := rec[k]; ok {
+			t.Fatalf("%s is left.", k)
+		}
+	}
+
+	st := rec["State"].(State)
+	if keys := st.Keys(); len(keys) != 2 || keys[0] != "legacy/gb01" || keys[1] != "legacy/gb03" {
+		t.Fatalf("state keys are %v", keys)
+	}
+
+	bad := map[string]interface{}{"Gb01": "x"}
+	if err := upgradeRecord(bad, 1); err == nil {
+		t.Fatal("a broken record is upgraded.")
+	}
+}
@@ -0,0 +1,276 @@
+package miniquet
+
+import (
+	"os"
+	"io"
+	"fmt"
+	"bytes"
+	"strings"
+	"io/ioutil"
+	"path/filepath"
+	"crypto/aes"
+	"crypto/rand"
+	"crypto/cipher"
+)
+
+import (
+	"github.com/BurntSushi/toml"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+const (
+	EnvPassphrase string = "MINIQUET_PASSPHRASE"
+	SecretFileSuffix string = ".secret"
+
+	SIZE_SECRET_SALT int = 16
+	SIZE_SECRET_KEY  int = 32
+)
+
+var (
+	SecretMagic []byte = []byte("MQSECRET1")
+
+	//scrypt parameters, recommended for interactive logins.
+	ScryptN int = 1 << 15
+	ScryptR int = 8
+	ScryptP int = 1
+)
+
+//Secret is the content of an encrypted secret file.
+type Secret struct {
+	ApiKey    string
+	SecretKey string
+}
+
+//EncryptSecret seals the secret with AES-GCM. The key is derived from the
+//passphrase with scrypt and a random salt, which is stored in the output.
+func EncryptSecret(s *Secret, passphrase []byte) ([]byte, error) {
+	if len(passphrase) < 1 {
+		return nil, fmt.Errorf("empty passphrase.")
+	}
+
+	plain := new(bytes.Buffer)
+	if err := toml.NewEncoder(plain).Encode(s); err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, SIZE_SECRET_SALT)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+
+	aead, err := newSecretAEAD(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	out := append([]byte{}, SecretMagic...)
+	out = append(out, salt...)
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, plain.Bytes(), SecretMagic), nil
+}
+
+func DecryptSecret(b []byte, passphrase []byte) (*Secret, error) {
+	if !bytes.HasPrefix(b, SecretMagic) {
+		return nil, fmt.Errorf("not a miniquet secret file.")
+	}
+	b = b[len(SecretMagic):]
+
+	if len(b) < SIZE_SECRET_SALT {
+		return nil, fmt.Errorf("secret file is broken.")
+	}
+	salt := b[:SIZE_SECRET_SALT]
+	b = b[SIZE_SECRET_SALT:]
+
+	aead, err := newSecretAEAD(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < aead.NonceSize() {
+		return nil, fmt.Errorf("secret file is broken.")
+	}
+
+	plain, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], SecretMagic)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt the secret file, wrong passphrase?")
+	}
+
+	var s Secret
+	if _, err := toml.Decode(string(plain), &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func newSecretAEAD(passphrase []byte, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, ScryptN, ScryptR, ScryptP, SIZE_SECRET_KEY)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func ReadSecretFile(path string, passphrase []byte) (*Secret, error) {
+	b, err := ioutil.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+	return DecryptSecret(b, passphrase)
+}
+
+func WriteSecretFile(path string, s *Secret, passphrase []byte) error {
+	b, err := EncryptSecret(s, passphrase)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Clean(path), b, 0600)
+}
+
+//ReadPassphrase returns MINIQUET_PASSPHRASE, or asks on the terminal when
+//it is not set.
+func ReadPassphrase(prompt string) ([]byte, error) {
+	if p, ok := os.LookupEnv(EnvPassphrase); ok {
+		return []byte(p), nil
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, fmt.Errorf("no terminal to ask the passphrase. set %s.", EnvPassphrase)
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	defer fmt.Fprintln(os.Stderr)
+	return term.ReadPassword(fd)
+}
+
+//ReadNewPassphrase is ReadPassphrase asking twice on the terminal.
+func ReadNewPassphrase(prompt string) ([]byte, error) {
+	if _, ok := os.LookupEnv(EnvPassphrase); ok {
+		return ReadPassphrase(prompt)
+	}
+
+	pass, err := ReadPassphrase(prompt)
+	if err != nil {
+		return nil, err
+	}
+	again, err := ReadPassphrase("again: ")
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(pass, again) {
+		return nil, fmt.Errorf("passphrases do not match.")
+	}
+	if len(pass) < 1 {
+		return nil, fmt.Errorf("empty passphrase.")
+	}
+	return pass, nil
+}
+
+//EncryptConfig moves ApiKey and SecretKey of a plaintext config into an
+//encrypted secret file next to it, and rewrites the config in place to
+//point to that file. It returns the path of the secret file.
+func EncryptConfig(path string, passphrase []byte) (string, error) {
+	fpath := filepath.Clean(path)
+
+	b, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		return "", err
+	}
+
+	var conf Config
+	md, err := toml.Decode(string(b), &conf)
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", fpath, err)
+	}
+	if md.IsDefined("SecretFile") {
+		return "", fmt.Errorf("%s already has SecretFile.", fpath)
+	}
+	if conf.ApiKey == "" && conf.SecretKey == "" {
+		return "", fmt.Errorf("%s has no ApiKey and SecretKey.", fpath)
+	}
+
+	fi, err := os.Stat(fpath)
+	if err != nil {
+		return "", err
+	}
+
+	s_path := fpath + SecretFileSuffix
+	if _, err := os.Stat(s_path); err == nil {
+		return "", fmt.Errorf("%s already exists.", s_path)
+	}
+
+	s := &Secret{ApiKey: conf.ApiKey, SecretKey: conf.SecretKey}
+	if err := WriteSecretFile(s_path, s, passphrase); err != nil {
+		return "", err
+	}
+
+	lines := []string{fmt.Sprintf("SecretFile = %q", filepath.Base(s_path))}
+	table := false
+	for _, l := range strings.Split(string(b), "\n") {
+		t := strings.TrimSpace(l)
+		if strings.HasPrefix(t, "[") {
+			table = true
+		}
+		if !table && isKeyLine(t, "ApiKey", "SecretKey") {
+			continue
+		}
+		lines = append(lines, l)
+	}
+
+	body := []byte(strings.Join(lines, "\n"))
+	if err := writeFileAtomic(fpath, body, fi.Mode().Perm()); err != nil {
+		os.Remove(s_path)
+		return "", err
+	}
+	return s_path, nil
+}
+
+func isKeyLine(line string, keys ...string) bool {
+	eq := strings.Index(line, "=")
+	if eq < 1 {
+		return false
+	}
+
+	k := strings.Trim(strings.TrimSpace(line[:eq]), "\"")
+	for _, key := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+func writeFileAtomic(path string, b []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "." + filepath.Base(path) + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
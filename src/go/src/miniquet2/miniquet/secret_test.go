@@ -0,0 +1,141 @@
+package miniquet
+
+import (
+	"os"
+	"bytes"
+	"strings"
+	"testing"
+	"io/ioutil"
+	"path/filepath"
+)
+
+const secretConfig string = `ApiKey = "key"
+SecretKey = "secret"
+
+[[Trader]]
+Name = "alice"
+Strategy = "point"
+Params = { Threshold = 0.2 }
+`
+
+//fastScrypt lowers the cost of scrypt for the tests, and returns the
+//function restoring it.
+func fastScrypt() func() {
+	n := ScryptN
+	ScryptN = 1 << 10
+	return func() { ScryptN = n }
+}
+
+func TestSecretRoundTrip(t *testing.T) {
+	defer fastScrypt()()
+
+	s := &Secret{ApiKey: "key", SecretKey: "secret \"quoted\""}
+	b, err := EncryptSecret(s, []byte("pass"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(b, []byte("secret")) {
+		t.Fatal("the secret is written in plain.")
+	}
+
+	got, err := DecryptSecret(b, []byte("pass"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != *s {
+		t.Fatalf("want %+v, got %+v", s, got)
+	}
+
+	again, err := EncryptSecret(s, []byte("pass"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(b, again) {
+		t.Fatal("the salt and the nonce are not random.")
+	}
+}
+
+func TestSecretRejects(t *testing.T) {
+	defer fastScrypt()()
+
+	b, err := EncryptSecret(&Secret{ApiKey: "key", SecretKey: "secret"}, []byte("pass"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := DecryptSecret(b, []byte("wrong")); err == nil {
+		t.Fatal("decrypted with a wrong passphrase.")
+	}
+
+	tampered := append([]byte{}, b...)
+	tampered[len(tampered) - 1] ^= 1
+	if _, err := DecryptSecret(tampered, []byte("pass")); err == nil {
+		t.Fatal("decrypted a tampered file.")
+	}
+
+	if _, err := DecryptSecret(b[:len(SecretMagic) + 4], []byte("pass")); err == nil {
+		t.Fatal("decrypted a truncated file.")
+	}
+	if _, err := DecryptSecret([]byte("ApiKey = \"key\""), []byte("pass")); err == nil {
+		t.Fatal("decrypted a plain file.")
+	}
+	if _, err := EncryptSecret(&Secret{}, nil); err == nil {
+		t.Fatal("encrypted with an empty passphrase.")
+	}
+}
+
+//TestEncryptConfig moves the keys of a config into the secret file, and
+//loads the config with the passphrase.
+func TestEncryptConfig(t *testing.T) {
+	defer fastScrypt()()
+
+	dir, err := ioutil.TempDir("", "miniquet2-secret-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config")
+	if err := ioutil.WriteFile(path, []byte(secretConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s_path, err := EncryptConfig(path, []byte("pass"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "ApiKey") || strings.Contains(string(b), "SecretKey") {
+		t.Fatalf("keys are left in the config:\n%s", b)
+	}
+	if !strings.Contains(string(b), "[[Trader]]") {
+		t.Fatalf("the config is broken:\n%s", b)
+	}
+	if _, err := EncryptConfig(path, []byte("pass")); err == nil {
+		t.Fatal("encrypted the config twice.")
+	}
+
+	opt := *DefaultConfigOpt
+	opt.Passphrase = func(prompt string) ([]byte, error) {
+		if !strings.Contains(prompt, s_path) {
+			t.Errorf("prompt does not show the secret file: %s", prompt)
+		}
+		return []byte("pass"), nil
+	}
+	conf, err := LoadConfigWithOpt(path, &opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.ApiKey != "key" || conf.SecretKey != "secret" {
+		t.Fatalf("keys are not loaded: %s %s", conf.ApiKey, conf.SecretKey)
+	}
+
+	opt.Passphrase = func(string) ([]byte, error) { return []byte("wrong"), nil }
+	if _, err := LoadConfigWithOpt(path, &opt); err == nil {
+		t.Fatal("loaded with a wrong passphrase.")
+	}
+}
@@ -0,0 +1,293 @@
+package miniquet
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"encoding/base64"
+)
+
+const (
+	STATE_FLOAT  string = "float"
+	STATE_INT    string = "int"
+	STATE_STRING string = "string"
+	STATE_BOOL   string = "bool"
+	STATE_BYTES  string = "bytes"
+
+	//STATE_NS_LEGACY keeps the scratch buffers of old records which no
+	//strategy claimed.
+	STATE_NS_LEGACY string = "legacy"
+)
+
+//StateValue is a typed value of a strategy, keyed by
+//'<namespace>/<name>'. It is dropped at the turn of the entry unless Keep
+//is set.
+type StateValue struct {
+	Key    string
+	Type   string
+	Float  float64 `json:",omitempty"`
+	Int    int64   `json:",omitempty"`
+	String string  `json:",omitempty"`
+	Bool   bool    `json:",omitempty"`
+	Bytes  []byte  `json:",omitempty"`
+	Keep   bool    `json:",omitempty"`
+}
+
+func (self *StateValue) Text() string {
+	switch self.Type {
+	case STATE_FLOAT:
+		return fmt.Sprintf("%v", self.Float)
+	case STATE_INT:
+		return fmt.Sprintf("%d", self.Int)
+	case STATE_STRING:
+		return self.String
+	case STATE_BOOL:
+		return fmt.Sprintf("%v", self.Bool)
+	case STATE_BYTES:
+		return base64.StdEncoding.EncodeToString(self.Bytes)
+	}
+	return ""
+}
+
+//State is the per-entry store of the strategies, sorted by the key. A
+//strategy reads and writes it through Entry.StateOf with its own
+//namespace. It is a slice, not a map, to keep the msgpack record stable.
+type State []*StateValue
+
+func (self State) Keys() []string {
+	keys := make([]string, 0, len(self))
+	for _, v := range self {
+		keys = append(keys, v.Key)
+	}
+	return keys
+}
+
+func (self State) Get(key string) (*StateValue, bool) {
+	i := self.index(key)
+	if i >= len(self) || self[i].Key != key {
+		return nil, false
+	}
+	return self[i], true
+}
+
+func (self State) index(key string) int {
+	return sort.Search(len(self), func(i int) bool { return self[i].Key >= key })
+}
+
+func (self State) Validate() error {
+	for i, v := range self {
+		if v == nil {
+			return fmt.Errorf("state %d is empty.", i)
+		}
+		if !strings.Contains(v.Key, "/") {
+			return fmt.Errorf("state '%s' has no namespace.", v.Key)
+		}
+		if i > 0 && self[i - 1].Key >= v.Key {
+			return fmt.Errorf("state '%s' is duplicated or not sorted.", v.Key)
+		}
+		switch v.Type {
+		case STATE_FLOAT, STATE_INT, STATE_STRING, STATE_BOOL, STATE_BYTES:
+		default:
+			return fmt.Errorf("state '%s' has unknown type '%s'.", v.Key, v.Type)
+		}
+	}
+	return nil
+}
+
+//put replaces or inserts the value at its place.
+func (self State) put(v *StateValue) State {
+	i := self.index(v.Key)
+	if i < len(self) && self[i].Key == v.Key {
+		self[i] = v
+		return self
+	}
+	self = append(self, nil)
+	copy(self[i + 1:], self[i:])
+	self[i] = v
+	return self
+}
+
+func (self State) delete(key string) State {
+	i := self.index(key)
+	if i >= len(self) || self[i].Key != key {
+		return self
+	}
+	return append(self[:i], self[i + 1:]...)
+}
+
+//clone copies the values, so that a copy of an entry can be changed
+//without changing the entry.
+func (self State) clone() State {
+	if self == nil {
+		return nil
+	}
+
+	c := make(State, 0, len(self))
+	for _, v := range self {
+		cv := *v
+		cv.Bytes = append([]byte(nil), v.Bytes...)
+		c = append(c, &cv)
+	}
+	return c
+}
+
+//turn drops the values which are not kept.
+func (self State) turn() State {
+	kept := self[:0]
+	for _, v := range self {
+		if v.Keep {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
+
+//StateSpace is the part of an entry state owned by one strategy.
+type StateSpace struct {
+	e  *Entry
+	ns string
+}
+
+func (self *Entry) StateOf(ns string) *StateSpace {
+	return &StateSpace{e: self, ns: ns}
+}
+
+func (self *StateSpace) key(name string) string {
+	return self.ns + "/" + name
+}
+
+func (self *StateSpace) get(name string, t string) (*StateValue, bool) {
+	v, ok := self.e.State.Get(self.key(name))
+	if !ok || v.Type != t {
+		return nil, false
+	}
+	return v, true
+}
+
+//set replaces the value, and keeps it over the turn if it was kept.
+func (self *StateSpace) set(name string, v *StateValue) {
+	v.Key = self.key(name)
+	if old, ok := self.e.State.Get(v.Key); ok {
+		v.Keep = old.Keep
+	}
+	self.e.State = self.e.State.put(v)
+}
+
+func (self *StateSpace) Float(name string) (float64, bool) {
+	v, ok := self.get(name, STATE_FLOAT)
+	if !ok {
+		return 0, false
+	}
+	return v.Float, true
+}
+
+func (self *StateSpace) SetFloat(name string, f float64) {
+	self.set(name, &StateValue{Type: STATE_FLOAT, Float: f})
+}
+
+func (self *StateSpace) Int(name string) (int64, bool) {
+	v, ok := self.get(name, STATE_INT)
+	if !ok {
+		return 0, false
+	}
+	return v.Int, true
+}
+
+func (self *StateSpace) SetInt(name string, i int64) {
+	self.set(name, &StateValue{Type: STATE_INT, Int: i})
+}
+
+func (self *StateSpace) String(name string) (string, bool) {
+	v, ok := self.get(name, STATE_STRING)
+	if !ok {
+		return "", false
+	}
+	return v.String, true
+}
+
+func (self *StateSpace) SetString(name string, s string) {
+	self.set(name, &StateValue{Type: STATE_STRING, String: s})
+}
+
+func (self *StateSpace) Bool(name string) (bool, bool) {
+	v, ok := self.get(name, STATE_BOOL)
+	if !ok {
+		return false, false
+	}
+	return v.Bool, true
+}
+
+func (self *StateSpace) SetBool(name string, b bool) {
+	self.set(name, &StateValue{Type: STATE_BOOL, Bool: b})
+}
+
+func (self *StateSpace) Bytes(name string) ([]byte, bool) {
+	v, ok := self.get(name, STATE_BYTES)
+	if !ok {
+		return nil, false
+	}
+	return v.Bytes, true
+}
+
+func (self *StateSpace) SetBytes(name string, b []byte) {
+	self.set(name, &StateValue{Type: STATE_BYTES, Bytes: append([]byte{}, b...)})
+}
+
+//Keep makes the value survive the turn of the entry. A value is dropped
+//at the turn by default.
+func (self *StateSpace) Keep(name string, keep bool) error {
+	v, ok := self.e.State.Get(self.key(name))
+	if !ok {
+		return fmt.Errorf("state '%s' is not set.", self.key(name))
+	}
+	v.Keep = keep
+	return nil
+}
+
+func (self *StateSpace) Delete(name string) {
+	self.e.State = self.e.State.delete(self.key(name))
+}
+
+//gbSnapshot is Gb01-Gb04 as loaded from the State.
+type gbSnapshot struct {
+	f01, f02 float64
+	b03, b04 []byte
+}
+
+//loadGb fills Gb01-Gb04 from the State, at the keys migrateGb moved them
+//to. Gb02 is the diff shared with the point strategy.
+func (self *Entry) loadGb() gbSnapshot {
+	legacy := self.StateOf(STATE_NS_LEGACY)
+	self.Gb01, _ = legacy.Float("gb01")
+	self.Gb02, _ = self.StateOf(StrategyPoint).Float(STATE_POINT_DIFF)
+	self.Gb03, _ = legacy.Bytes("gb03")
+	self.Gb04, _ = legacy.Bytes("gb04")
+
+	return gbSnapshot{f01: self.Gb01, f02: self.Gb02,
+			b03: append([]byte{}, self.Gb03...), b04: append([]byte{}, self.Gb04...)}
+}
+
+//storeGb writes back the buffers which the check changed, so that a
+//value written through StateOf is not overwritten by a stale one.
+func (self *Entry) storeGb(before gbSnapshot) {
+	legacy := self.StateOf(STATE_NS_LEGACY)
+	if self.Gb01 != before.f01 {
+		legacy.SetFloat("gb01", self.Gb01)
+	}
+	if self.Gb02 != before.f02 {
+		self.StateOf(StrategyPoint).SetFloat(STATE_POINT_DIFF, self.Gb02)
+	}
+	storeGbBytes(legacy, "gb03", self.Gb03, before.b03)
+	storeGbBytes(legacy, "gb04", self.Gb04, before.b04)
+}
+
+func storeGbBytes(ss *StateSpace, name string, b []byte, before []byte) {
+	if string(b) == string(before) {
+		return
+	}
+	if len(b) < 1 {
+		ss.Delete(name)
+		return
+	}
+	ss.SetBytes(name, b)
+}
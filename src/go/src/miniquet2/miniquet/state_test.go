@@ -0,0 +1,78 @@
+package miniquet
+
+import (
+	"testing"
+	"time"
+)
+
+//TestGbShim runs a check written against Gb01-Gb04, as the strategies of
+//vouquet/brain are, and reads its values back through the State.
+func TestGbShim(t *testing.T) {
+	e := NewEntry("alice", "BTC", 0.1, 100)
+	check := func(e *Entry, ask float64, bid float64) bool {
+		e.Gb01 += 1
+		e.Gb02 = ask - e.Last_fix_rate
+		e.Gb03 = append(e.Gb03, 'x')
+		return false
+	}
+
+	for i := 0; i < 2; i++ {
+		gb := e.loadGb()
+		check(e, 110, 109)
+		e.storeGb(gb)
+	}
+
+	if f, _ := e.StateOf(STATE_NS_LEGACY).Float("gb01"); f != 2 {
+		t.Fatalf("gb01 is %v, want 2.", f)
+	}
+	if p := e.Point(); p != 0.1 {
+		t.Fatalf("Point is %v, want 0.1.", p)
+	}
+	if b, _ := e.StateOf(STATE_NS_LEGACY).Bytes("gb03"); string(b) != "xx" {
+		t.Fatalf("gb03 is '%s', want 'xx'.", b)
+	}
+	if err := e.State.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	e.Turn(time.Now(), 110, 109)
+	e.loadGb()
+	if e.Gb01 != 0 || e.Gb02 != 0 || len(e.Gb03) != 0 {
+		t.Fatalf("buffers are not reset at the turn: %v %v %v", e.Gb01, e.Gb02, e.Gb03)
+	}
+}
+
+//TestGbShimKeepsState checks a value written through StateOf during the
+//check is not overwritten by the stale buffer.
+func TestGbShimKeepsState(t *testing.T) {
+	e := NewEntry("alice", "BTC", 0.1, 100)
+	e.StateOf(StrategyPoint).SetFloat(STATE_POINT_DIFF, 1)
+
+	gb := e.loadGb()
+	e.StateOf(StrategyPoint).SetFloat(STATE_POINT_DIFF, 5)
+	e.storeGb(gb)
+
+	if f, _ := e.StateOf(StrategyPoint).Float(STATE_POINT_DIFF); f != 5 {
+		t.Fatalf("diff is %v, want 5.", f)
+	}
+}
+
+func TestGbNotStored(t *testing.T) {
+	st := NewMemStorage()
+	defer st.Close()
+
+	e := NewEntry("alice", "BTC", 0.1, 100)
+	e.Gb01 = 3
+	e.Gb04 = []byte("stale")
+	if err := st.Put(e); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := st.Get(e.Id())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Gb01 != 0 || len(got.Gb04) != 0 {
+		t.Fatalf("buffers are stored: %v %v", got.Gb01, got.Gb04)
+	}
+}
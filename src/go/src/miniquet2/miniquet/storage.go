@@ -3,9 +3,10 @@ package miniquet
 import (
 	"io"
 	"fmt"
-	"path/filepath"
 	"sync"
+	"time"
 	"bytes"
+	"reflect"
 )
 
 import (
@@ -16,35 +17,98 @@ import (
 var (
 	DefaultStorageOpt *StorageOpt = &StorageOpt{ErrorIfExist: false}
 	MsgpckHndl *codec.MsgpackHandle = &codec.MsgpackHandle{}
+
+	ErrNotFound error = leveldb.ErrNotFound
+
+	errNeedMigration error = fmt.Errorf("storage needs migration.")
 )
 
 func init() {
 	MsgpckHndl.RawToString = true
+	//records are decoded into maps by the migrations.
+	MsgpckHndl.MapType = reflect.TypeOf(map[string]interface{}(nil))
 }
 
+//Storage keeps the entries and the other records on a Backend.
 type Storage struct {
-	db  *leveldb.DB
+	b   Backend
+	name string
+
+	observe func(string, time.Duration)
+	//backups waits for the running backups at Close.
+	backups *sync.WaitGroup
 
 	mtx *sync.Mutex
 }
 
 type StorageOpt struct {
 	ErrorIfExist bool
+	//ReadOnly opens the storage without writing. It still fails while
+	//another process holds the storage.
+	ReadOnly     bool
+	//ErrorIfMissing refuses to create a new storage.
+	ErrorIfMissing bool
+}
+
+//StorageLockedError is returned when another process holds the storage.
+type StorageLockedError struct {
+	Path string
+}
+
+func (self *StorageLockedError) Error() string {
+	return fmt.Sprintf("%s is used by another process. stop it first.", self.Path)
 }
 
-func OpenStorage(path string, opt *StorageOpt) (*Storage, error) {
-	if opt == nil {
-		opt = DefaultStorageOpt
+//EntryStore is what a Trader needs of a Storage.
+type EntryStore interface {
+	Put(*Entry) error
+	Delete(*Entry) error
+	Archive(*Entry, string) error
+	Kill(*Entry) (*ArchiveRecord, error)
+	Revive(string) (*Entry, error)
+	ArchiveKilled(string) error
+	Get(string) (*Entry, error)
+	Walk() ([]*Entry, error)
+}
+
+//OpenStorage opens the LevelDB storage at path.
+func OpenStorage(path string, s_opt *StorageOpt) (*Storage, error) {
+	return OpenStorageWith(STORAGE_LEVELDB, path, s_opt)
+}
+
+//OpenStorageWith opens the storage on a backend of the type.
+func OpenStorageWith(s_type string, path string, s_opt *StorageOpt) (*Storage, error) {
+	if s_opt == nil {
+		s_opt = DefaultStorageOpt
 	}
 
-	c_path := filepath.Clean(path)
-	db, err := leveldb.OpenFile(c_path, nil)
+	b, err := OpenBackend(s_type, path, s_opt)
 	if err != nil {
 		return nil, err
 	}
+	return NewStorage(b, path, s_opt.ReadOnly)
+}
+
+//NewStorage checks the schema of the backend, and migrates it. A read only
+//backend of an older schema is copied into memory and migrated there. The
+//name is shown in the errors.
+func NewStorage(b Backend, name string, read_only bool) (*Storage, error) {
+	if err := checkSchema(b, name, read_only); err == errNeedMigration {
+		mem, err := migrateInMemory(b)
+		b.Close()
+		if err != nil {
+			return nil, err
+		}
+		b = mem
+	} else if err != nil {
+		b.Close()
+		return nil, err
+	}
 
 	return &Storage{
-		db: db,
+		b: b,
+		name: name,
+		backups: new(sync.WaitGroup),
 		mtx: new(sync.Mutex),
 	}, nil
 }
@@ -53,18 +117,41 @@ func (self *Storage) Close() error {
 	self.lock()
 	defer self.unlock()
 
-	if err := self.db.Close(); err != nil {
+	if self.b == nil {
+		return nil
+	}
+	self.backups.Wait()
+	if err := self.b.Close(); err != nil {
 		return err
 	}
-	self.db = nil
+	self.b = nil
 	return nil
 }
 
+//ObserveHandler sets the function called with the name and the latency
+//of every storage operation.
+func (self *Storage) ObserveHandler(f func(string, time.Duration)) {
+	self.lock()
+	defer self.unlock()
+
+	self.observe = f
+}
+
+func (self *Storage) observed(op string, start time.Time) {
+	if self.observe == nil {
+		return
+	}
+	self.observe(op, time.Since(start))
+}
+
+//Put writes the entry and its indexes in one batch. The indexes of the
+//previous record are removed, as the trader or the symbol may change.
 func (self *Storage) Put(entry *Entry) error {
 	self.lock()
 	defer self.unlock()
+	defer self.observed("put", time.Now())
 
-	if self.db == nil {
+	if self.b == nil {
 		return fmt.Errorf("target database is nil pointer.")
 	}
 
@@ -73,55 +160,150 @@ func (self *Storage) Put(entry *Entry) error {
 		return err
 	}
 
-	id := []byte(entry.Id())
-	return self.db.Put(id, b, nil)
+	batch := NewBatch()
+	if err := self.deleteIndex(batch, entry.Id()); err != nil {
+		return err
+	}
+	batch.Put(entryKey(entry.Id()), b)
+	for _, k := range indexKeys(entry) {
+		batch.Put(k, []byte{})
+	}
+	return self.b.Write(batch)
 }
 
 func (self *Storage) Delete(entry *Entry) error {
 	self.lock()
 	defer self.unlock()
+	defer self.observed("delete", time.Now())
 
-	if self.db == nil {
+	if self.b == nil {
 		return fmt.Errorf("target database is nil pointer.")
 	}
 
-	id := []byte(entry.Id())
-	return self.db.Delete(id, nil)
+	batch := NewBatch()
+	if err := self.deleteIndex(batch, entry.Id()); err != nil {
+		return err
+	}
+	batch.Delete(entryKey(entry.Id()))
+	return self.b.Write(batch)
 }
 
-func (self *Storage) Walk() ([]*Entry, error) {
+//deleteIndex adds the removal of the indexes of the stored record.
+func (self *Storage) deleteIndex(batch *Batch, id string) error {
+	val, err := self.b.Get(entryKey(id))
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	old, err := decode(val)
+	if err != nil {
+		return err
+	}
+	for _, k := range indexKeys(old) {
+		batch.Delete(k)
+	}
+	return nil
+}
+
+//FindId returns the id of the entry which starts with the prefix. The
+//prefix must match only one entry.
+func (self *Storage) FindId(prefix string) (string, error) {
 	self.lock()
 	defer self.unlock()
 
-	if self.db == nil {
-		return nil, fmt.Errorf("target database is nil pointer.")
+	if self.b == nil {
+		return "", fmt.Errorf("target database is nil pointer.")
 	}
 
-	iter := self.db.NewIterator(nil, nil)
-	defer iter.Release()
+	ids := []string{}
+	err := self.b.Range(entryKey(prefix), func(key []byte, _ []byte) error {
+		ids = append(ids, string(bytes.TrimPrefix(key, []byte(NS_ENTRIES))))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return MatchId(prefix, ids)
+}
 
+func (self *Storage) Walk() ([]*Entry, error) {
 	es := []*Entry{}
-	for iter.Next() {
-		v := iter.Value()
+	err := self.ForEach(func(e *Entry) error {
+		es = append(es, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return es, nil
+}
+
+//ForEach calls f with every entry in the order of the id, without
+//loading all of them. f must not use the storage. An error of f stops
+//the iteration and is returned.
+func (self *Storage) ForEach(f func(*Entry) error) error {
+	self.lock()
+	defer self.unlock()
+	defer self.observed("walk", time.Now())
+
+	if self.b == nil {
+		return fmt.Errorf("target database is nil pointer.")
+	}
 
-		e, err := decode(v)
+	return self.b.Range([]byte(NS_ENTRIES), func(key []byte, val []byte) error {
+		e, err := decode(val)
 		if err != nil {
-			return nil, err
+			return fmt.Errorf("record '%s': %s", string(key), err)
 		}
-		es = append(es, e)
+		return f(e)
+	})
+}
+
+//ForEachByTrader calls f with the entries of the trader, using the index.
+func (self *Storage) ForEachByTrader(trader string, f func(*Entry) error) error {
+	return self.forEachIndex(IDX_TRADER, trader, f)
+}
+
+//ForEachBySymbol calls f with the entries of the symbol, using the index.
+func (self *Storage) ForEachBySymbol(symbol string, f func(*Entry) error) error {
+	return self.forEachIndex(IDX_SYMBOL, symbol, f)
+}
+
+func (self *Storage) forEachIndex(idx string, value string, f func(*Entry) error) error {
+	self.lock()
+	defer self.unlock()
+	defer self.observed("walk", time.Now())
+
+	if self.b == nil {
+		return fmt.Errorf("target database is nil pointer.")
 	}
-	return es, nil
+
+	return self.b.Range(indexPrefix(idx, value), func(key []byte, _ []byte) error {
+		val, err := self.b.Get(entryKey(indexId(key)))
+		if err != nil {
+			return fmt.Errorf("index '%s' is broken: %s", string(key), err)
+		}
+		e, err := decode(val)
+		if err != nil {
+			return err
+		}
+		return f(e)
+	})
 }
 
 func (self *Storage) Get(key string) (*Entry, error) {
 	self.lock()
 	defer self.unlock()
+	defer self.observed("get", time.Now())
 
-	if self.db == nil {
+	if self.b == nil {
 		return nil, fmt.Errorf("target database is nil pointer.")
 	}
 
-	val, err := self.db.Get([]byte(key), nil)
+	val, err := self.b.Get(entryKey(key))
 	if err != nil {
 		return nil, err
 	}
@@ -129,6 +311,221 @@ func (self *Storage) Get(key string) (*Entry, error) {
 	return decode(val)
 }
 
+//TraderRecord is what the storage keeps of a trader definition.
+type TraderRecord struct {
+	Name        string
+	Description string
+	Updated     time.Time
+}
+
+func (self *Storage) PutTrader(r *TraderRecord) error {
+	var b []byte
+	if err := codec.NewEncoderBytes(&b, MsgpckHndl).Encode(r); err != nil {
+		return err
+	}
+	return self.PutRaw(NS_TRADERS, r.Name, b)
+}
+
+func (self *Storage) GetTrader(name string) (*TraderRecord, error) {
+	b, err := self.GetRaw(NS_TRADERS, name)
+	if err != nil {
+		return nil, err
+	}
+	return decodeTrader(b)
+}
+
+func (self *Storage) DeleteTrader(name string) error {
+	return self.DeleteRaw(NS_TRADERS, name)
+}
+
+func (self *Storage) ForEachTrader(f func(*TraderRecord) error) error {
+	return self.Range(NS_TRADERS, "", func(_ string, b []byte) error {
+		r, err := decodeTrader(b)
+		if err != nil {
+			return err
+		}
+		return f(r)
+	})
+}
+
+func decodeTrader(b []byte) (*TraderRecord, error) {
+	var r TraderRecord
+	if err := codec.NewDecoderBytes(b, MsgpckHndl).Decode(&r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+//PutRaw writes a value to a namespace which has no typed accessor yet.
+func (self *Storage) PutRaw(ns string, key string, val []byte) error {
+	if !isNamespace(ns) || ns == NS_ENTRIES || ns == NS_INDEX {
+		return fmt.Errorf("cannot write raw values to namespace '%s'.", ns)
+	}
+
+	self.lock()
+	defer self.unlock()
+	defer self.observed("put", time.Now())
+
+	if self.b == nil {
+		return fmt.Errorf("target database is nil pointer.")
+	}
+	batch := NewBatch()
+	batch.Put([]byte(ns + key), val)
+	return self.b.Write(batch)
+}
+
+func (self *Storage) GetRaw(ns string, key string) ([]byte, error) {
+	if !isNamespace(ns) {
+		return nil, fmt.Errorf("unknown namespace '%s'.", ns)
+	}
+
+	self.lock()
+	defer self.unlock()
+	defer self.observed("get", time.Now())
+
+	if self.b == nil {
+		return nil, fmt.Errorf("target database is nil pointer.")
+	}
+	return self.b.Get([]byte(ns + key))
+}
+
+func (self *Storage) DeleteRaw(ns string, key string) error {
+	if !isNamespace(ns) || ns == NS_ENTRIES || ns == NS_INDEX {
+		return fmt.Errorf("cannot delete raw values of namespace '%s'.", ns)
+	}
+
+	self.lock()
+	defer self.unlock()
+	defer self.observed("delete", time.Now())
+
+	if self.b == nil {
+		return fmt.Errorf("target database is nil pointer.")
+	}
+	batch := NewBatch()
+	batch.Delete([]byte(ns + key))
+	return self.b.Write(batch)
+}
+
+//Range calls f with the keys and the values in the namespace which start
+//with prefix, in the order of the key. The key is given without the
+//namespace. f must not use the storage.
+func (self *Storage) Range(ns string, prefix string, f func(string, []byte) error) error {
+	if !isNamespace(ns) {
+		return fmt.Errorf("unknown namespace '%s'.", ns)
+	}
+
+	self.lock()
+	defer self.unlock()
+	defer self.observed("walk", time.Now())
+
+	if self.b == nil {
+		return fmt.Errorf("target database is nil pointer.")
+	}
+
+	return self.b.Range([]byte(ns + prefix), func(key []byte, val []byte) error {
+		return f(string(key[len(ns):]), val)
+	})
+}
+
+//Backup writes a copy of the storage to a new storage at path. It reads
+//a snapshot, so the storage can be used meanwhile.
+func (self *Storage) Backup(path string) error {
+	self.lock()
+	b := self.b
+	if b == nil {
+		self.unlock()
+		return fmt.Errorf("target database is nil pointer.")
+	}
+	//the storage is not locked while the backup is written, and Close
+	//waits for it instead.
+	self.backups.Add(1)
+	self.unlock()
+	defer self.backups.Done()
+
+	return b.Backup(path)
+}
+
+//Compact reclaims the space of the deleted and overwritten records. The
+//storage is locked while compacting.
+func (self *Storage) Compact() error {
+	self.lock()
+	defer self.unlock()
+	defer self.observed("compact", time.Now())
+
+	if self.b == nil {
+		return fmt.Errorf("target database is nil pointer.")
+	}
+	return self.b.Compact()
+}
+
+//Stats counts the keys and the bytes of every namespace.
+func (self *Storage) Stats() (*StorageStats, error) {
+	self.lock()
+	defer self.unlock()
+
+	if self.b == nil {
+		return nil, fmt.Errorf("target database is nil pointer.")
+	}
+
+	stats := newStorageStats(self.name)
+	err := self.b.Range(nil, func(key []byte, val []byte) error {
+		stats.add(string(key), len(key) + len(val))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := self.b.Size()
+	if err != nil {
+		return nil, err
+	}
+	stats.Size = size
+	return stats, nil
+}
+
+//checkSchema refuses a storage written by a newer miniquet2 and migrates
+//an older one. A read only storage returns errNeedMigration instead.
+func checkSchema(b Backend, name string, read_only bool) error {
+	v, err := schemaVersion(b)
+	if err != nil {
+		return err
+	}
+	if v > SCHEMA_VERSION {
+		return &StorageVersionError{Path: name, Version: v}
+	}
+	if v == SCHEMA_VERSION {
+		return nil
+	}
+	if read_only {
+		return errNeedMigration
+	}
+	return migrate(b)
+}
+
+//migrateInMemory copies a read only storage of an older schema into
+//memory and migrates the copy.
+func migrateInMemory(src Backend) (Backend, error) {
+	mem := NewMemBackend()
+
+	batch := NewBatch()
+	err := src.Range(nil, func(k []byte, v []byte) error {
+		batch.Put(k, v)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := mem.Write(batch); err != nil {
+		return nil, err
+	}
+
+	if err := migrate(mem); err != nil {
+		return nil, err
+	}
+	return mem, nil
+}
+
 func (self *Storage) lock() {
 	self.mtx.Lock()
 }
@@ -141,7 +538,9 @@ func encode(e *Entry) ([]byte, error) {
 	buf := new(bytes.Buffer)
 	var w io.Writer = buf
 
-	if err := codec.NewEncoder(w, MsgpckHndl).Encode(e); err != nil {
+	c := *e
+	c.Version = SCHEMA_VERSION
+	if err := codec.NewEncoder(w, MsgpckHndl).Encode(&c); err != nil {
 		return nil, err
 	}
 
@@ -153,5 +552,20 @@ func decode(b []byte) (*Entry, error) {
 	if err := codec.NewDecoderBytes(b, MsgpckHndl).Decode(&e); err != nil {
 		return nil, err
 	}
-	return &e, nil
+	if e.Version == SCHEMA_VERSION {
+		return &e, nil
+	}
+	if e.Version > SCHEMA_VERSION {
+		return nil, fmt.Errorf("record schema version %d is newer than %d.", e.Version, SCHEMA_VERSION)
+	}
+
+	up, err := upgrade(b, e.Version)
+	if err != nil {
+		return nil, err
+	}
+	var u Entry
+	if err := codec.NewDecoderBytes(up, MsgpckHndl).Decode(&u); err != nil {
+		return nil, err
+	}
+	return &u, nil
 }
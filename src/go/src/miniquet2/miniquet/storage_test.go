@@ -0,0 +1,98 @@
+package miniquet
+
+import (
+	"testing"
+)
+
+//expectIndex checks the number of the entries under each value of the
+//index.
+func expectIndex(t *testing.T, each func(string, func(*Entry) error) error, want map[string]int) {
+	t.Helper()
+	for v, n := range want {
+		got, err := countEntries(each, v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != n {
+			t.Fatalf("index of '%s': want %d entries, got %d", v, n, got)
+		}
+	}
+}
+
+//expectIndexKeys checks the number of the index keys, and that every key
+//points to a stored entry of the indexed trader or symbol.
+func expectIndexKeys(t *testing.T, st *Storage, want int) {
+	t.Helper()
+	keys := []string{}
+	err := st.Range(NS_INDEX, "", func(key string, _ []byte) error {
+		keys = append(keys, NS_INDEX + key)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != want {
+		t.Fatalf("want %d index keys, got %d", want, len(keys))
+	}
+	for _, k := range keys {
+		e, err := st.Get(indexId([]byte(k)))
+		if err != nil {
+			t.Fatalf("index '%s' is dangling: %s", k, err)
+		}
+		if k != string(indexKey(IDX_TRADER, e.Trader, e.Id())) &&
+				k != string(indexKey(IDX_SYMBOL, e.Symbol, e.Id())) {
+			t.Fatalf("index '%s' is stale", k)
+		}
+	}
+}
+
+//TestStorageIndexes checks the indexes follow the entries through Put,
+//Delete and Archive.
+func TestStorageIndexes(t *testing.T) {
+	st := NewMemStorage()
+	defer st.Close()
+
+	a := NewEntry("alice", "BTC", 0.1, 100)
+	b := NewEntry("alice", "ETH", 1, 200)
+	c := NewEntry("bob", "BTC", 0.2, 110)
+	for _, e := range []*Entry{a, b, c} {
+		if err := st.Put(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectIndex(t, st.ForEachByTrader, map[string]int{"alice": 2, "bob": 1})
+	expectIndex(t, st.ForEachBySymbol, map[string]int{"BTC": 2, "ETH": 1})
+	expectIndexKeys(t, st, 6)
+
+	a.Symbol = "ETH"
+	if err := st.Put(a); err != nil {
+		t.Fatal(err)
+	}
+	expectIndex(t, st.ForEachBySymbol, map[string]int{"BTC": 1, "ETH": 2})
+	expectIndexKeys(t, st, 6)
+
+	if err := st.Delete(b); err != nil {
+		t.Fatal(err)
+	}
+	expectIndex(t, st.ForEachByTrader, map[string]int{"alice": 1, "bob": 1})
+	expectIndex(t, st.ForEachBySymbol, map[string]int{"BTC": 1, "ETH": 1})
+	expectIndexKeys(t, st, 4)
+
+	if err := st.Archive(c, ARCHIVE_CLOSED); err != nil {
+		t.Fatal(err)
+	}
+	expectIndex(t, st.ForEachByTrader, map[string]int{"alice": 1, "bob": 0})
+	expectIndex(t, st.ForEachBySymbol, map[string]int{"BTC": 0, "ETH": 1})
+	expectIndexKeys(t, st, 2)
+	if _, err := st.GetArchive(c.Id()); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := st.Check(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || report.Entries != 1 {
+		t.Fatalf("unexpected report: %s", report)
+	}
+}
@@ -0,0 +1,91 @@
+package miniquet
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+import (
+	"github.com/vouquet/go-gmo-coin/gomocoin"
+)
+
+const (
+	StrategyPoint string = "point"
+
+	//STATE_POINT_DIFF is the move from the last fixed rate, written by
+	//the point strategy on every check.
+	STATE_POINT_DIFF string = "diff"
+)
+
+var (
+	strategies     map[string]StrategyFactory = make(map[string]StrategyFactory)
+	strategies_mtx *sync.Mutex = new(sync.Mutex)
+)
+
+func init() {
+	RegisterStrategy(StrategyPoint, newPointStrategy)
+}
+
+type CheckFunc func(*Entry, float64, float64) bool
+
+//StrategyFactory builds a check function from the Params of a trader
+//definition.
+type StrategyFactory func(map[string]float64) (CheckFunc, error)
+
+func RegisterStrategy(id string, f StrategyFactory) error {
+	strategies_mtx.Lock()
+	defer strategies_mtx.Unlock()
+
+	if f == nil {
+		return fmt.Errorf("strategy '%s' has nil factory.", id)
+	}
+	if _, ok := strategies[id]; ok {
+		return fmt.Errorf("strategy '%s' is already registered.", id)
+	}
+
+	strategies[id] = f
+	return nil
+}
+
+func NewStrategy(id string, params map[string]float64) (CheckFunc, error) {
+	strategies_mtx.Lock()
+	f, ok := strategies[id]
+	strategies_mtx.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown strategy: '%s'", id)
+	}
+	return f(params)
+}
+
+func StrategyIds() []string {
+	strategies_mtx.Lock()
+	defer strategies_mtx.Unlock()
+
+	ids := []string{}
+	for id, _ := range strategies {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+//newPointStrategy trades when the rate moved Threshold percent to the
+//profitable side of the last fixed rate.
+func newPointStrategy(params map[string]float64) (CheckFunc, error) {
+	th, ok := params["Threshold"]
+	if !ok || th <= 0 {
+		return nil, fmt.Errorf("strategy '%s' needs a positive Threshold.", StrategyPoint)
+	}
+
+	return func(e *Entry, ask float64, bid float64) bool {
+		st := e.StateOf(StrategyPoint)
+		if e.Position == gomocoin.SIDE_SELL {
+			st.SetFloat(STATE_POINT_DIFF, bid - e.Last_fix_rate)
+		} else {
+			st.SetFloat(STATE_POINT_DIFF, e.Last_fix_rate - ask)
+		}
+		return e.Point() * 100 >= th
+	}, nil
+}
@@ -2,8 +2,10 @@ package miniquet
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 	"time"
+	"strings"
 )
 
 import (
@@ -16,29 +18,74 @@ type Trader struct {//TODO: Upgrade2interface
 	name        string
 	description string
 
+	//win is the win of the archived entries, and closed is their number.
 	win         float64
+	closed      int
 
-	st          *Storage
+	st          EntryStore
 	shop        *gomocoin.GoMOcoin
 
 	entries     map[string]*Entry
+	//killed are the entries stopped by kill9, until they are revived or
+	//archived by ExpireKilled.
+	killed      map[string]*ArchiveRecord
 	check       func(*Entry, float64, float64) bool
+	bus         *EventBus
+
+	symbols     map[string]bool
+	limits      TraderLimits
 
 	mtx         *sync.Mutex
 }
 
-func NewTrader(name string, desc string, shop *gomocoin.GoMOcoin, st *Storage) *Trader {
+type TraderConfig struct {
+	Name        string
+	Description string
+	Strategy    string
+	Params      map[string]float64
+	Symbols     []string
+	Limits      TraderLimits
+}
+
+//TraderLimits bounds the entries a trader accepts. A zero value is
+//unlimited.
+type TraderLimits struct {
+	MaxEntries   int
+	MaxSize      float64
+	MaxTotalSize float64
+}
+
+func NewTrader(name string, desc string, shop *gomocoin.GoMOcoin, st EntryStore) *Trader {
 	return &Trader{
 		name: name,
 		description: desc,
 		st: st,
 		shop: shop,
 		entries: make(map[string]*Entry),
+		killed: make(map[string]*ArchiveRecord),
 		check: nil,
+		symbols: make(map[string]bool),
 		mtx: new(sync.Mutex),
 	}
 }
 
+func NewTraderFromConfig(c *TraderConfig, shop *gomocoin.GoMOcoin, st EntryStore) (*Trader, error) {
+	if c.Name == "" {
+		return nil, fmt.Errorf("trader has no name.")
+	}
+
+	check, err := NewStrategy(c.Strategy, c.Params)
+	if err != nil {
+		return nil, fmt.Errorf("trader '%s': %s", c.Name, err)
+	}
+
+	self := NewTrader(c.Name, c.Description, shop, st)
+	self.SetCheckFunc(check)
+	self.SetSymbols(c.Symbols)
+	self.SetLimits(c.Limits)
+	return self, nil
+}
+
 func DecodeTrader(b []byte) (*Trader, error) {
 	return nil, nil
 }
@@ -47,34 +94,143 @@ func (self *Trader) Encode() ([]byte, error) {
 	return nil, nil
 }
 
+//Reconfigure applies a new definition to a running trader. Entries are
+//kept as they are.
+func (self *Trader) Reconfigure(c *TraderConfig) error {
+	if c.Name != self.name {
+		return fmt.Errorf("cannot rename trader '%s' to '%s'.", self.name, c.Name)
+	}
+
+	check, err := NewStrategy(c.Strategy, c.Params)
+	if err != nil {
+		return err
+	}
+
+	self.SetDescription(c.Description)
+	self.SetCheckFunc(check)
+	self.SetSymbols(c.Symbols)
+	self.SetLimits(c.Limits)
+	return nil
+}
+
 func (self *Trader) Name() string {
 	return self.name
 }
 
 func (self *Trader) Description() string {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
 	return self.description
 }
 
+func (self *Trader) SetDescription(desc string) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	self.description = desc
+}
+
+//Win returns the win of the archived entries, the killed ones and the
+//open ones.
 func (self *Trader) Win() float64 {
 	self.mtx.Lock()
 	defer self.mtx.Unlock()
 
-	return self.win
+	win := self.win
+	for _, e := range self.entries {
+		win += e.Win
+	}
+	for _, r := range self.killed {
+		win += r.Win
+	}
+	return win
+}
+
+//ClosedWin returns the number and the win of the archived entries.
+func (self *Trader) ClosedWin() (int, float64) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	return self.closed, self.win
+}
+
+//AddClosed counts an archived entry, loaded from the storage.
+func (self *Trader) AddClosed(win float64) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	self.closed++
+	self.win += win
+}
+
+//archive moves the entry to the archive of the storage.
+func (self *Trader) archive(entry *Entry, reason string) error {
+	if err := self.st.Archive(entry, reason); err != nil {
+		return err
+	}
+	delete(self.entries, entry.Id())
+
+	self.closed++
+	self.win += entry.Win
+	return nil
 }
 
-func (self *Trader) Add(symbol string, size float64, want_rate float64) error {
+func (self *Trader) Add(symbol string, size float64, want_rate float64) (*Entry, error) {
 	self.mtx.Lock()
 	defer self.mtx.Unlock()
 
+	if err := self.checkLimits(symbol, size, ""); err != nil {
+		self.bus.Publish(&RiskBreach{At: time.Now(), Trader: self.name,
+								Symbol: symbol, Reason: err.Error()})
+		return nil, err
+	}
+
 	entry := NewEntry(self.name, symbol, size, want_rate)
 	_, ok := self.entries[entry.Id()]
 	if ok {
-		return fmt.Errorf("New entry id is already exist. '%s'", entry.Id())
+		return nil, fmt.Errorf("New entry id is already exist. '%s'", entry.Id())
 	}
 
 	self.entries[entry.Id()] = entry
 	if err := self.st.Put(entry); err != nil {
-		return err
+		return nil, err
+	}
+
+	self.bus.Publish(&EntryAdded{newEntryEvent(self.name, entry)})
+	return entry, nil
+}
+
+//checkLimits checks an entry of the symbol and the size can be held. The
+//entry of the id is left out of the counts, as it is replaced by an edit.
+//Give an empty id for a new entry.
+func (self *Trader) checkLimits(symbol string, size float64, id string) error {
+	if len(self.symbols) > 0 && !self.symbols[symbol] {
+		return fmt.Errorf("symbol '%s' is not allowed for %s.", symbol, self.name)
+	}
+	n := len(self.entries)
+	if _, ok := self.entries[id]; ok {
+		n--
+	}
+	if self.limits.MaxEntries > 0 && n >= self.limits.MaxEntries {
+		return fmt.Errorf("%s already has %d entries, limit is %d.", self.name,
+										n, self.limits.MaxEntries)
+	}
+	if self.limits.MaxSize > 0 && size > self.limits.MaxSize {
+		return fmt.Errorf("size %v is over the limit %v of %s.", size, self.limits.MaxSize, self.name)
+	}
+	if self.limits.MaxTotalSize > 0 {
+		total := size
+		for _, en := range self.entries {
+			if en.Symbol != symbol || en.Id() == id {
+				continue
+			}
+			total += en.Size
+		}
+		if total > self.limits.MaxTotalSize {
+			return fmt.Errorf("total size %v of %s is over the limit %v of %s.", total, symbol,
+											self.limits.MaxTotalSize, self.name)
+		}
 	}
 	return nil
 }
@@ -103,6 +259,8 @@ func (self *Trader) RequestStop(id string) error {
 	}
 
 	entry.Lastone()
+
+	self.bus.Publish(&EntryStopped{newEntryEvent(self.name, entry)})
 	return nil
 }
 
@@ -115,18 +273,153 @@ func (self *Trader) RequestKill9(id string) error {
 		return fmt.Errorf("%s is not found", id)
 	}
 
-	if err := self.st.Delete(entry); err != nil {
+	r, err := self.st.Kill(entry)
+	if err != nil {
 		return err
 	}
 	delete(self.entries, entry.Id())
+	self.killed[entry.Id()] = r
+
+	self.bus.Publish(&EntryKilled{newEntryEvent(self.name, entry)})
 	return nil
 }
 
+//RequestRevive brings back the entry stopped by kill9, as it was before.
+func (self *Trader) RequestRevive(id string) (*Entry, error) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	if _, ok := self.killed[id]; !ok {
+		return nil, fmt.Errorf("%s is not killed, or is already archived.", id)
+	}
+
+	entry, err := self.st.Revive(id)
+	if err != nil {
+		return nil, err
+	}
+	delete(self.killed, id)
+	self.entries[id] = entry
+
+	self.bus.Publish(&EntryRevived{newEntryEvent(self.name, entry)})
+	return entry, nil
+}
+
+//AppendKilled adds an entry killed before, loaded from the storage.
+func (self *Trader) AppendKilled(r *ArchiveRecord) error {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	if _, ok := self.killed[r.Entry.Id()]; ok {
+		return fmt.Errorf("killed entry id is already exist. '%s'", r.Entry.Id())
+	}
+	self.killed[r.Entry.Id()] = r
+	return nil
+}
+
+//Killed returns the entries stopped by kill9 which can be revived, the
+//last killed first.
+func (self *Trader) Killed() []*ArchiveRecord {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	rs := make([]*ArchiveRecord, 0, len(self.killed))
+	for _, r := range self.killed {
+		rs = append(rs, r)
+	}
+	sort.SliceStable(rs, func(i, j int) bool { return rs[i].ClosedAt.After(rs[j].ClosedAt) })
+	return rs
+}
+
+//ExpireKilled archives the entries killed before the time, and returns
+//their ids. They cannot be revived after it.
+func (self *Trader) ExpireKilled(before time.Time) ([]string, error) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	ids := []string{}
+	for id, r := range self.killed {
+		if r.ClosedAt.After(before) {
+			continue
+		}
+		if err := self.st.ArchiveKilled(id); err != nil {
+			return ids, err
+		}
+		delete(self.killed, id)
+
+		self.closed++
+		self.win += r.Win
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+//Entries returns a copy of the map of the entries.
 func (self *Trader) Entries() map[string]*Entry {
 	self.mtx.Lock()
 	defer self.mtx.Unlock()
 
-	return self.entries
+	es := make(map[string]*Entry, len(self.entries))
+	for id, e := range self.entries {
+		es[id] = e
+	}
+	return es
+}
+
+//EntryInfos returns the snapshots of the entries. They are taken under
+//the lock, as the strategy changes the entries while trading.
+func (self *Trader) EntryInfos() []*EntryInfo {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	infos := make([]*EntryInfo, 0, len(self.entries))
+	for _, e := range self.entries {
+		infos = append(infos, NewEntryInfo(e))
+	}
+	return infos
+}
+
+//FindEntry returns the entry whose id starts with the prefix. The prefix
+//must match only one entry.
+func (self *Trader) FindEntry(prefix string) (*Entry, error) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	ids := make([]string, 0, len(self.entries))
+	for id, _ := range self.entries {
+		ids = append(ids, id)
+	}
+	id, err := MatchId(prefix, ids)
+	if err != nil {
+		return nil, err
+	}
+	return self.entries[id], nil
+}
+
+//Edit changes the entry with f and saves it. The entry is not changed
+//when f fails, the edited entry is over the limits, or the storage fails.
+func (self *Trader) Edit(id string, f func(*Entry) error) (*Entry, error) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	entry, ok := self.entries[id]
+	if !ok {
+		return nil, fmt.Errorf("%s is not found", id)
+	}
+
+	edited := *entry
+	if err := f(&edited); err != nil {
+		return nil, err
+	}
+	if err := self.checkLimits(edited.Symbol, edited.Size, id); err != nil {
+		self.bus.Publish(&RiskBreach{At: time.Now(), Trader: self.name,
+								Symbol: edited.Symbol, Reason: err.Error()})
+		return nil, err
+	}
+	if err := self.st.Put(&edited); err != nil {
+		return nil, err
+	}
+	self.entries[id] = &edited
+	return &edited, nil
 }
 
 func (self *Trader) GetEntriy(id string) (*Entry, bool)  {
@@ -144,32 +437,66 @@ func (self *Trader) SetCheckFunc(f func(*Entry, float64, float64) bool) {
 	self.check = f
 }
 
-func (self *Trader) Do(log Logger, rates map[string]shop.Rate) {
+func (self *Trader) SetSymbols(symbols []string) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	self.symbols = make(map[string]bool)
+	for _, sym := range symbols {
+		self.symbols[sym] = true
+	}
+}
+
+func (self *Trader) SetLimits(limits TraderLimits) {
 	self.mtx.Lock()
 	defer self.mtx.Unlock()
 
+	self.limits = limits
+}
+
+func (self *Trader) SetEventBus(bus *EventBus) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	self.bus = bus
+}
+
+func (self *Trader) Do(log *LevelLogger, rates map[string]shop.Rate) {
+	self.mtx.Lock()
+	defer self.mtx.Unlock()
+
+	log = log.With("trader", self.name)
 	if self.check == nil {
-		log.WriteErrLog("trader has not check function. target is nil pointer.")
+		log.Error("trader has not check function. target is nil pointer.")
 		return
 	}
 
 	for _, entry := range self.entries {
 		rate, ok := rates[entry.Symbol]
 		if !ok {
-			log.WriteErrLog("Not found symbol : '%s'", entry.Symbol)
+			log.Error("not found symbol", "symbol", entry.Symbol, "entry", entry.Id())
 			continue
 		}
 
-		if !self.check(entry, rate.Ask(), rate.Bid()) {
+		gb := entry.loadGb()
+		ok = self.check(entry, rate.Ask(), rate.Bid())
+		entry.storeGb(gb)
+		if !ok {
 			continue
 		}
 
+		position := entry.Position
 		o_id, err := self.do(entry, rate.Ask(), rate.Bid())
 		if err != nil {
-			log.WriteErrLog("Failed the trade: '%s'", err)
+			log.Error("failed the trade", "entry", entry.Id(), "symbol", entry.Symbol, "err", err)
+
+			ev := &TradeFailed{EntryEvent: newEntryEvent(self.name, entry), Err: err}
+			ev.Rate = orderRate(entry, rate.Ask(), rate.Bid())
+			self.bus.Publish(ev)
 			continue
 		}
-		log.WriteMsgLog("Trade!!!!!! entry: %s, order_id: '%s'", entry.Id(), o_id)
+		log.Info("Trade!!!!!!", "entry", entry.Id(), "symbol", entry.Symbol,
+					"side", position, "size", entry.Size, "order_id", o_id, "win", entry.Win)
 	}
 
 	return
@@ -178,25 +505,52 @@ func (self *Trader) Do(log Logger, rates map[string]shop.Rate) {
 func (self *Trader) do(entry *Entry, ask float64, bid float64) (string, error) {
 	now := time.Now()
 
+	submitted := &OrderSubmitted{newEntryEvent(self.name, entry)}
+	submitted.Rate = orderRate(entry, ask, bid)
+	self.bus.Publish(submitted)
+
 	o_id, err := self.shop.Order(entry.Position, entry.Symbol, entry.Size, nil)
 	if err != nil {
 		return "", err
 	}
 
+	filled := &OrderFilled{EntryEvent: newEntryEvent(self.name, entry), OrderId: o_id}
+	filled.Rate = orderRate(entry, ask, bid)
+	self.bus.Publish(filled)
+
 	if entry.IsLastone() {
-		if err := self.st.Delete(entry); err != nil {
+		if err := self.closeEntry(entry, now, ask, bid); err != nil {
 			return "", err
 		}
-		delete(self.entries, entry.Id())
 
+		self.bus.Publish(&EntryClosed{newEntryEvent(self.name, entry)})
 		return o_id, nil
 	}
 
 	entry.Turn(now, ask, bid)
+	self.bus.Publish(&EntryTurned{newEntryEvent(self.name, entry)})
 	return o_id, self.st.Put(entry)
 }
 
+//closeEntry books the win of the last order and archives the entry. The
+//entry is turned on a copy, so that it stays as it was when the archive
+//fails.
+func (self *Trader) closeEntry(entry *Entry, now time.Time, ask float64, bid float64) error {
+	closed := *entry
+	closed.State = entry.State.clone()
+	closed.Turn(now, ask, bid)
+	if err := self.archive(&closed, ARCHIVE_CLOSED); err != nil {
+		return err
+	}
+
+	*entry = closed
+	return nil
+}
+
 type Entry struct {
+	//Version is the schema version of the record. See Migrations.
+	Version       int
+
 	Uuid          uuid.UUID
 	Trader      string
 
@@ -209,10 +563,16 @@ type Entry struct {
 	Last_fix_rate float64
 	Last_fix_date time.Time
 
-	Gb01        float64
-	Gb02        float64
-	Gb03        []byte
-	Gb04        []byte
+	//State is owned by the strategy of the trader. See StateOf.
+	State       State
+
+	//Gb01-Gb04 are the scratch buffers of the strategies of vouquet/brain.
+	//They are not stored, but mapped onto the State around each check
+	//until brain uses StateOf.
+	Gb01        float64 `codec:"-" json:"-"`
+	Gb02        float64 `codec:"-" json:"-"`
+	Gb03        []byte  `codec:"-" json:"-"`
+	Gb04        []byte  `codec:"-" json:"-"`
 
 	Last_run    bool
 }
@@ -231,7 +591,6 @@ func NewEntry(trader string, symbol string, size float64, want_rate float64) *En
 		Last_fix_rate: want_rate,
 		Last_run: false,
 	}
-	self.resetBuf()
 	return self
 }
 
@@ -250,19 +609,14 @@ func (self *Entry) Turn(now time.Time, ask float64, bid float64) {
 	}
 
 	self.Last_fix_date = now
-	self.resetBuf()
+	self.State = self.State.turn()
 }
 
-func (self *Entry) resetBuf() {
-	self.Gb01 = float64(0)
-	self.Gb02 = float64(0)
-	self.Gb03 = []byte{}
-	self.Gb04 = []byte{}
-}
-
-
+//Point is the move from the last fixed rate which the point strategy saw
+//at the last check, relative to the rate.
 func (self *Entry) Point() float64 {
-	return float64(self.Gb02 / self.Last_fix_rate)
+	diff, _ := self.StateOf(StrategyPoint).Float(STATE_POINT_DIFF)
+	return float64(diff / self.Last_fix_rate)
 }
 
 func (self *Entry) Lastone() {
@@ -277,6 +631,42 @@ func (self *Entry) Id() string {
 	return self.Uuid.String()
 }
 
+//MatchId returns the id which starts with the prefix. The prefix must
+//match only one of the ids, unless it is a whole id.
+func MatchId(prefix string, ids []string) (string, error) {
+	if prefix == "" {
+		return "", fmt.Errorf("id is empty.")
+	}
+
+	found := []string{}
+	for _, id := range ids {
+		if id == prefix {
+			return id, nil
+		}
+		if strings.HasPrefix(id, prefix) {
+			found = append(found, id)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("%s is not found", prefix)
+	case 1:
+		return found[0], nil
+	}
+	return "", &AmbiguousIdError{Prefix: prefix, N: len(found)}
+}
+
+//AmbiguousIdError is returned by MatchId when the prefix matches more
+//than one id.
+type AmbiguousIdError struct {
+	Prefix string
+	N      int
+}
+
+func (self *AmbiguousIdError) Error() string {
+	return fmt.Sprintf("%s is ambiguous, it matches %d entries.", self.Prefix, self.N)
+}
+
 func (self *Entry) LastRate() float64 {
 	return self.Last_fix_rate
 }
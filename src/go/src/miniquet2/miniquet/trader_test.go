@@ -0,0 +1,251 @@
+package miniquet
+
+import (
+	"fmt"
+	"time"
+	"testing"
+)
+
+import (
+	"github.com/vouquet/go-gmo-coin/gomocoin"
+)
+
+type failArchiveStore struct {
+	*Storage
+}
+
+func (self *failArchiveStore) Archive(*Entry, string) error {
+	return fmt.Errorf("archive failed.")
+}
+
+//TestCloseEntryFails checks the entry is not turned when the archive
+//fails, so that it is closed again at the next tick.
+func TestCloseEntryFails(t *testing.T) {
+	st := &failArchiveStore{NewMemStorage()}
+	defer st.Close()
+
+	tr := NewTrader("alice", "", nil, st)
+	e := NewEntry("alice", "BTC", 0.1, 100)
+	e.Position = gomocoin.SIDE_SELL
+	e.StateOf(StrategyPoint).SetFloat(STATE_POINT_DIFF, 10)
+	e.Lastone()
+	if err := tr.RequestAppend(e); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tr.closeEntry(e, time.Now(), 111, 110); err == nil {
+		t.Fatal("archive did not fail.")
+	}
+	if e.Position != gomocoin.SIDE_SELL || e.Win != 0 || e.Last_fix_rate != 100 {
+		t.Fatalf("entry is turned: %s %v %v", e.Position, e.Win, e.Last_fix_rate)
+	}
+	if f, ok := e.StateOf(StrategyPoint).Float(STATE_POINT_DIFF); !ok || f != 10 {
+		t.Fatalf("state is dropped: %v %v", f, ok)
+	}
+	if _, ok := tr.Entries()[e.Id()]; !ok {
+		t.Fatal("entry is not live.")
+	}
+}
+
+func TestCloseEntry(t *testing.T) {
+	st := NewMemStorage()
+	defer st.Close()
+
+	tr := NewTrader("alice", "", nil, st)
+	e := NewEntry("alice", "BTC", 0.1, 100)
+	e.Position = gomocoin.SIDE_SELL
+	e.Lastone()
+	if err := tr.RequestAppend(e); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Put(e); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tr.closeEntry(e, time.Now(), 111, 110); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tr.Entries()[e.Id()]; ok {
+		t.Fatal("entry is still live.")
+	}
+	if n, win := tr.ClosedWin(); n != 1 || win != e.Win || e.Win == 0 {
+		t.Fatalf("closed win is %d %v, entry win %v", n, win, e.Win)
+	}
+	r, err := st.GetArchive(e.Id())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Win != e.Win {
+		t.Fatalf("archived win is %v, want %v", r.Win, e.Win)
+	}
+}
+
+//TestEditLimits edits an entry past the limits of the trader.
+func TestEditLimits(t *testing.T) {
+	st := NewMemStorage()
+	defer st.Close()
+	bus := NewEventBus()
+	defer bus.Close()
+	sub := bus.Subscribe(SIZE_EVENT_BUFFER, DropOldest, EventRiskBreach)
+
+	tr := NewTrader("alice", "", nil, st)
+	tr.SetEventBus(bus)
+	tr.SetLimits(TraderLimits{MaxEntries: 2, MaxSize: 2, MaxTotalSize: 1.5})
+	a, err := tr.Add("BTC", 0.5, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tr.Add("BTC", 0.5, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	//the own size of the entry is not counted twice.
+	if _, err := tr.Edit(a.Id(), func(e *Entry) error {
+		e.Size = 1
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := tr.Edit(a.Id(), func(e *Entry) error {
+		e.Size = 1.1
+		return nil
+	})
+	if err == nil {
+		t.Fatalf("edited over the total size limit: %v", e.Size)
+	}
+	select {
+	case ev := <-sub.C():
+		if b := ev.(*RiskBreach); b.Trader != "alice" || b.Symbol != "BTC" {
+			t.Fatalf("unexpected breach: %+v", b)
+		}
+	default:
+		t.Fatal("RiskBreach is not published.")
+	}
+
+	if e, _ := tr.GetEntriy(a.Id()); e.Size != 1 {
+		t.Fatalf("refused edit changed the entry: %v", e.Size)
+	}
+	if got, err := st.Get(a.Id()); err != nil || got.Size != 1 {
+		t.Fatalf("refused edit is stored: %v %v", got, err)
+	}
+}
+
+func killedEntry(t *testing.T) (*Storage, *Trader, *Entry) {
+	st := NewMemStorage()
+	tr := NewTrader("alice", "", nil, st)
+	e, err := tr.Add("BTC", 0.1, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err = tr.Edit(e.Id(), func(e *Entry) error {
+		e.Win = 2.5
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tr.RequestKill9(e.Id()); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tr.Entries()[e.Id()]; ok {
+		t.Fatal("killed entry is live.")
+	}
+	if ks := tr.Killed(); len(ks) != 1 || ks[0].Entry.Id() != e.Id() || ks[0].Reason != ARCHIVE_KILLED {
+		t.Fatalf("killed are %v", ks)
+	}
+	if _, err := st.Get(e.Id()); err == nil {
+		t.Fatal("killed entry is left in the entries.")
+	}
+	expectIndexes(t, st, 0)
+	return st, tr, e
+}
+
+func expectIndexes(t *testing.T, st *Storage, want int) {
+	t.Helper()
+	if n, err := countEntries(st.ForEachByTrader, "alice"); err != nil || n != want {
+		t.Fatalf("index of the trader: %d entries, %v", n, err)
+	}
+	if n, err := countEntries(st.ForEachBySymbol, "BTC"); err != nil || n != want {
+		t.Fatalf("index of the symbol: %d entries, %v", n, err)
+	}
+}
+
+//TestKillRevive revives a killed entry within the grace period.
+func TestKillRevive(t *testing.T) {
+	st, tr, e := killedEntry(t)
+	defer st.Close()
+
+	//the grace period of an hour has not passed.
+	if ids, err := tr.ExpireKilled(time.Now().Add(-time.Hour)); err != nil || len(ids) != 0 {
+		t.Fatalf("expired %v, %v", ids, err)
+	}
+
+	revived, err := tr.RequestRevive(e.Id())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revived.Win != 2.5 || revived.Size != 0.1 {
+		t.Fatalf("entry is not revived as it was: %+v", revived)
+	}
+	if _, ok := tr.Entries()[e.Id()]; !ok {
+		t.Fatal("revived entry is not live.")
+	}
+	if len(tr.Killed()) != 0 {
+		t.Fatal("revived entry is still killed.")
+	}
+	if got, err := st.Get(e.Id()); err != nil || got.Win != 2.5 {
+		t.Fatalf("revived entry is not stored: %v, %v", got, err)
+	}
+	expectIndexes(t, st, 1)
+
+	if _, err := tr.RequestRevive(e.Id()); err == nil {
+		t.Fatal("revived twice.")
+	}
+}
+
+//TestExpireKilled archives a killed entry after the grace period, and it
+//cannot be revived after it.
+func TestExpireKilled(t *testing.T) {
+	st, tr, e := killedEntry(t)
+	defer st.Close()
+
+	ids, err := tr.ExpireKilled(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 || ids[0] != e.Id() {
+		t.Fatalf("expired %v", ids)
+	}
+	if len(tr.Killed()) != 0 {
+		t.Fatal("expired entry is still killed.")
+	}
+	if n, win := tr.ClosedWin(); n != 1 || win != 2.5 {
+		t.Fatalf("closed win is %d %v", n, win)
+	}
+
+	r, err := st.GetArchive(e.Id())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Reason != ARCHIVE_KILLED || r.Win != 2.5 || r.Entry.Id() != e.Id() {
+		t.Fatalf("unexpected archive: %+v", r)
+	}
+	n := 0
+	err = st.ForEachKilled(func(*ArchiveRecord) error {
+		n++
+		return nil
+	})
+	if err != nil || n != 0 {
+		t.Fatalf("%d killed records are left, %v", n, err)
+	}
+	expectIndexes(t, st, 0)
+
+	if _, err := tr.RequestRevive(e.Id()); err == nil {
+		t.Fatal("revived an expired entry.")
+	}
+	if _, err := st.Revive(e.Id()); err == nil {
+		t.Fatal("the storage revived an expired entry.")
+	}
+}